@@ -17,6 +17,7 @@ type WriteBatch struct {
 	mu            *sync.Mutex
 	db            *DB
 	pendingWrites map[string]*data.LogRecord // 暂存用户写入的数据
+	pendingIncrs  map[string][]numberDelta   // 暂存的数值增量，提交时在 pendingWrites 的基础上依次累加
 }
 
 // 初始化 WriteBatch
@@ -26,6 +27,7 @@ func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
 		mu:            new(sync.Mutex),
 		db:            db,
 		pendingWrites: make(map[string]*data.LogRecord),
+		pendingIncrs:  make(map[string][]numberDelta),
 	}
 }
 
@@ -34,12 +36,16 @@ func (wb *WriteBatch) Put(key []byte, value []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if err := wb.db.checkKeySize(key); err != nil {
+		return err
+	}
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
-	// 暂存 LogRecord
+	// 暂存 LogRecord，之前暂存的增量被覆盖
 	logRecord := &data.LogRecord{Key: key, Value: value}
 	wb.pendingWrites[string(key)] = logRecord
+	delete(wb.pendingIncrs, string(key))
 	return nil
 }
 
@@ -51,8 +57,14 @@ func (wb *WriteBatch) Delete(key []byte) error {
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
+	// 之前暂存的增量被覆盖
+	delete(wb.pendingIncrs, string(key))
+
 	// 数据不存在则直接返回
-	logRecordPos := wb.db.index.Get(key)
+	logRecordPos, err := wb.db.getIndexPos(key)
+	if err != nil {
+		return err
+	}
 	if logRecordPos == nil {
 		if wb.pendingWrites[string(key)] != nil {
 			delete(wb.pendingWrites, string(key))
@@ -71,10 +83,10 @@ func (wb *WriteBatch) Commit() error {
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
-	if len(wb.pendingWrites) == 0 {
+	if len(wb.pendingWrites) == 0 && len(wb.pendingIncrs) == 0 {
 		return nil
 	}
-	if uint(len(wb.pendingWrites)) > wb.options.MaxBatchNum {
+	if uint(wb.pendingCount()) > wb.options.MaxBatchNum {
 		return ErrExceedMaxBatchNum
 	}
 
@@ -82,21 +94,39 @@ func (wb *WriteBatch) Commit() error {
 	wb.db.mu.Lock()
 	defer wb.db.mu.Unlock()
 
+	// 在锁内读取当前的值，计算暂存的增量
+	pendingWrites, incrOutcomes, err := wb.resolveIncrsLocked()
+	if err != nil {
+		return err
+	}
+
 	// 获取当前最新的事务序列号
 	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
 
 	// 开始写数据到数据文件当中
-	positions := make(map[string]*data.LogRecordPos)
-	for _, record := range wb.pendingWrites {
-		logRecordPos, err := wb.db.appendLogRecordLocked(&data.LogRecord{
-			Key:   logRecordKeyWithSeq(record.Key, seqNo),
-			Value: record.Value,
-			Type:  record.Type,
-		})
+	written := make(map[string]*data.TransactionRecord)
+	reserved := make(dedupReservation)
+	defer wb.db.releaseDedupLocked(reserved)
+	for _, record := range pendingWrites {
+		var prev *data.LogRecordPos
+		if wb.db.options.KeepHistory {
+			prev = wb.db.historyHeadLocked(record.Key)
+		}
+		logRecord, err := wb.db.dedupLogRecordLocked(&data.LogRecord{
+			Key:     logRecordKeyWithSeq(record.Key, seqNo),
+			Value:   record.Value,
+			Type:    record.Type,
+			Prev:    prev,
+			Version: wb.db.nextVersionLocked(),
+		}, reserved)
 		if err != nil {
 			return err
 		}
-		positions[string(record.Key)] = logRecordPos
+		logRecordPos, err := wb.db.appendLogRecordLocked(logRecord)
+		if err != nil {
+			return err
+		}
+		written[string(record.Key)] = &data.TransactionRecord{Record: logRecord, Pos: logRecordPos}
 	}
 
 	// 写一条标识事务完成的数据
@@ -116,20 +146,21 @@ func (wb *WriteBatch) Commit() error {
 	}
 
 	// 更新内存索引
-	for _, record := range wb.pendingWrites {
-		pos := positions[string(record.Key)]
-		if record.Type == data.LogRecordNormal {
-			wb.db.index.Put(record.Key, pos)
-		}
-		if record.Type == data.LogRecordDeleted {
-			wb.db.index.Delete(record.Key)
-		}
+	for _, record := range pendingWrites {
+		txnRecord := written[string(record.Key)]
+		wb.db.updateIndexLocked(record.Key, txnRecord.Record, txnRecord.Pos)
+	}
+
+	// 提交成功之后才设置增量的结果
+	for _, outcome := range incrOutcomes {
+		outcome.result.value = outcome.value
 	}
 
 	// 清空暂存数据
 	wb.pendingWrites = make(map[string]*data.LogRecord)
+	wb.pendingIncrs = make(map[string][]numberDelta)
 
-	return nil
+	return wb.db.finishWriteLocked()
 }
 
 // key+Seq Number 编码
@@ -0,0 +1,365 @@
+package bitcask_kv_go
+
+import (
+	"bitcask-kv-go/data"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 从磁盘中加载 blob 文件，id 最大的文件作为活跃的 blob 文件
+func (db *DB) loadBlobFiles() error {
+	dirEntries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+
+	var fileIds []int
+	for _, entry := range dirEntries {
+		if strings.HasSuffix(entry.Name(), data.BlobFileNameSuffix) {
+			splitNames := strings.Split(entry.Name(), ".")
+			fileId, err := strconv.Atoi(splitNames[0])
+			if err != nil {
+				return ErrDataDirectoryCorrupted
+			}
+			fileIds = append(fileIds, fileId)
+		}
+	}
+	sort.Ints(fileIds)
+
+	for _, fid := range fileIds {
+		blobFile, err := db.openBlobFile(uint32(fid))
+		if err != nil {
+			return err
+		}
+		db.blobFiles[uint32(fid)] = blobFile
+		db.activeBlobFile = blobFile
+	}
+	return nil
+}
+
+func (db *DB) openBlobFile(fileId uint32) (*data.DataFile, error) {
+	blobFile, err := data.OpenBlobFile(db.options.DirPath, fileId)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.initFileHeader(blobFile); err != nil {
+		_ = blobFile.Close()
+		return nil, err
+	}
+	return blobFile, nil
+}
+
+// 打开新的活跃 blob 文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) setActiveBlobFileLocked() error {
+	var fileId uint32 = 0
+	if db.activeBlobFile != nil {
+		// 先持久化旧的 blob 文件
+		if err := db.activeBlobFile.Sync(); err != nil {
+			return err
+		}
+		fileId = db.activeBlobFile.FileId + 1
+	}
+	blobFile, err := db.openBlobFile(fileId)
+	if err != nil {
+		return err
+	}
+	db.blobFiles[fileId] = blobFile
+	db.activeBlobFile = blobFile
+	return nil
+}
+
+// 将 value 追加写入到活跃的 blob 文件中，返回 value 在 blob 文件中的位置
+// 在访问此方法前必须持有互斥锁
+func (db *DB) appendBlobLocked(key, value []byte, timestamp int64) (*data.LogRecordPos, error) {
+	if db.activeBlobFile == nil {
+		if err := db.setActiveBlobFileLocked(); err != nil {
+			return nil, err
+		}
+	}
+
+	blobRecord, err := db.compressLogRecordLocked(&data.LogRecord{
+		Key:       key,
+		Value:     value,
+		Type:      data.LogRecordNormal,
+		Timestamp: timestamp,
+	})
+	if err != nil {
+		return nil, err
+	}
+	encRecord, size, err := db.activeBlobFile.EncodeLogRecord(blobRecord)
+	if err != nil {
+		return nil, err
+	}
+	if db.activeBlobFile.WriteOff+size > db.options.BlobFileSize {
+		if err := db.setActiveBlobFileLocked(); err != nil {
+			return nil, err
+		}
+		encRecord, _, err = db.activeBlobFile.EncodeLogRecord(blobRecord)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	writeOff := db.activeBlobFile.WriteOff
+	if err := db.activeBlobFile.Write(encRecord); err != nil {
+		return nil, err
+	}
+	if db.options.SyncWrites {
+		if err := db.activeBlobFile.Sync(); err != nil {
+			return nil, err
+		}
+	}
+	return &data.LogRecordPos{Fid: db.activeBlobFile.FileId, Offset: writeOff, Size: data.RecordPosSize(int64(len(encRecord)))}, nil
+}
+
+// 如果 value 存储在 blob 文件中，则读取 blob 文件中实际的 value，调用方不能持有锁
+// 只在查找并固定 blob 文件时短暂地持有读锁，读取文件时不持有锁
+func (db *DB) resolveBlobUnlocked(logRecord *data.LogRecord) error {
+	if logRecord.Flags&data.FlagBlobRef == 0 {
+		return nil
+	}
+	blobPos := data.DecodeLogRecordPos(logRecord.Value)
+	db.mu.RLock()
+	blobFile := db.blobFiles[blobPos.Fid]
+	if blobFile != nil {
+		blobFile.Pin()
+	}
+	db.mu.RUnlock()
+	if blobFile == nil {
+		return ErrDataFileNotFound
+	}
+	defer blobFile.Unpin()
+	return setBlobValue(logRecord, blobFile, blobPos)
+}
+
+// 如果 value 存储在 blob 文件中，则读取 blob 文件中实际的 value
+// 在访问此方法前必须持有锁
+func (db *DB) resolveBlobLocked(logRecord *data.LogRecord) error {
+	if logRecord.Flags&data.FlagBlobRef == 0 {
+		return nil
+	}
+	blobPos := data.DecodeLogRecordPos(logRecord.Value)
+	blobFile := db.blobFiles[blobPos.Fid]
+	if blobFile == nil {
+		return ErrDataFileNotFound
+	}
+	return setBlobValue(logRecord, blobFile, blobPos)
+}
+
+// 从 blob 文件中读取 value，替换记录中的 blob 位置
+func setBlobValue(logRecord *data.LogRecord, blobFile *data.DataFile, blobPos *data.LogRecordPos) error {
+	blobRecord, _, err := blobFile.ReadLogRecord(blobPos.Offset)
+	if err != nil {
+		return err
+	}
+	logRecord.Value = blobRecord.Value
+	logRecord.Flags &^= data.FlagBlobRef
+	return nil
+}
+
+// GCBlobs 回收 blob 文件中的无效数据
+// 对于除活跃 blob 文件之外的每个 blob 文件，如果其中无效数据的比例大于等于 discardRatio，
+// 则将其中有效的 value 重写到活跃的 blob 文件中，更新数据文件中的 blob 位置，然后删除该 blob 文件
+// 开启加密时，没有使用当前密钥加密的 blob 文件无论无效数据的比例是多少都会被重写
+func (db *DB) GCBlobs(discardRatio float64) error {
+	if discardRatio <= 0 || discardRatio > 1 {
+		return ErrDiscardRatioInvalid
+	}
+
+	db.mu.RLock()
+	var fileIds []uint32
+	for fid := range db.blobFiles {
+		if db.activeBlobFile == nil || fid != db.activeBlobFile.FileId {
+			fileIds = append(fileIds, fid)
+		}
+	}
+	db.mu.RUnlock()
+	sort.Slice(fileIds, func(i, j int) bool { return fileIds[i] < fileIds[j] })
+
+	// 扫描和复制 blob 文件时不持有锁，只在替换每条数据的位置时短暂加锁，避免长时间阻塞读写
+	for _, fid := range fileIds {
+		if err := db.gcBlobFile(fid, discardRatio); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blob 文件中的一条有效记录
+type liveBlob struct {
+	value     []byte             // blob 中的 value
+	refRecord *data.LogRecord    // 数据文件中指向该 blob 的记录
+	refPos    *data.LogRecordPos // 扫描时指向该 blob 的记录在数据文件中的位置
+	realKey   []byte             // 实际的 key
+}
+
+func (db *DB) gcBlobFile(fid uint32, discardRatio float64) error {
+	// 固定 blob 文件，扫描期间不持有锁，文件不会被关闭
+	db.mu.RLock()
+	blobFile := db.blobFiles[fid]
+	if blobFile != nil {
+		blobFile.Pin()
+	}
+	db.mu.RUnlock()
+	if blobFile == nil {
+		return nil
+	}
+	pinned := true
+	defer func() {
+		if pinned {
+			blobFile.Unpin()
+		}
+	}()
+
+	// 找出所有仍然被索引引用的 value
+	lives, totalSize, liveSize, err := db.scanBlobFile(blobFile)
+	if err != nil {
+		return err
+	}
+
+	// 无效数据的比例没有达到阈值，并且不需要使用新的密钥重写
+	if totalSize > 0 && float64(totalSize-liveSize)/float64(totalSize) < discardRatio && !db.needsRekey(blobFile) {
+		return nil
+	}
+
+	// 重写有效的 value，并更新数据文件中的 blob 位置
+	// 每条数据单独加锁，扫描之后被更新或删除的 key 不需要重写
+	for _, live := range lives {
+		if err := db.rewriteLiveBlob(live); err != nil {
+			return err
+		}
+	}
+
+	db.mu.Lock()
+	// 保证新的数据持久化之后，再删除旧的 blob 文件
+	if err := db.syncBlobGCLocked(); err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	// 并发的回收已经删除了这个 blob 文件
+	if db.blobFiles[fid] != blobFile {
+		db.mu.Unlock()
+		return nil
+	}
+	// 索引中已经没有指向这个 blob 文件的引用，但是之前查找索引的读取可能仍然持有旧的引用
+	// 切换到新的回收周期，不持有锁等待之前的读取结束
+	blobPins := db.blobPins
+	db.blobPins = new(sync.WaitGroup)
+	db.mu.Unlock()
+	blobPins.Wait()
+
+	db.mu.Lock()
+	if db.blobFiles[fid] != blobFile {
+		db.mu.Unlock()
+		return nil
+	}
+	delete(db.blobFiles, fid)
+	db.mu.Unlock()
+
+	// 从 blob 文件列表中删除之后不会再有新的读取固定这个文件，关闭时等待已经固定文件的读取结束
+	blobFile.Unpin()
+	pinned = false
+	if err := blobFile.Close(); err != nil {
+		return err
+	}
+	return os.Remove(data.GetBlobFileName(db.options.DirPath, fid))
+}
+
+// 持久化回收过程中写入的 blob 和数据文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) syncBlobGCLocked() error {
+	if db.activeBlobFile != nil {
+		if err := db.activeBlobFile.Sync(); err != nil {
+			return err
+		}
+	}
+	if db.activeFile != nil {
+		return db.activeFile.Sync()
+	}
+	return nil
+}
+
+// 扫描 blob 文件，找出仍然被索引引用的 value，调用方不能持有锁
+func (db *DB) scanBlobFile(blobFile *data.DataFile) ([]*liveBlob, int64, int64, error) {
+	var lives []*liveBlob
+	var totalSize, liveSize int64
+	var offset int64 = 0
+	for {
+		blobRecord, size, err := blobFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, 0, 0, err
+		}
+		if blobRecord.Type == data.LogRecordFileHeader {
+			offset += size
+			continue
+		}
+		totalSize += size
+
+		// 读取索引失败时不能把 blob 当作无效数据回收
+		db.mu.RLock()
+		refPos, err := db.getIndexPos(blobRecord.Key)
+		db.mu.RUnlock()
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if refPos != nil {
+			refRecord, err := db.readLogRecordUnlocked(refPos)
+			if err != nil {
+				return nil, 0, 0, err
+			}
+			if refRecord.Flags&data.FlagBlobRef != 0 {
+				blobPos := data.DecodeLogRecordPos(refRecord.Value)
+				if blobPos.Fid == blobFile.FileId && blobPos.Offset == offset {
+					liveSize += size
+					lives = append(lives, &liveBlob{
+						value:     blobRecord.Value,
+						refRecord: refRecord,
+						refPos:    refPos,
+						realKey:   blobRecord.Key,
+					})
+				}
+			}
+		}
+		offset += size
+	}
+	return lives, totalSize, liveSize, nil
+}
+
+// 将一条有效的 value 写入活跃的 blob 文件，并替换索引中的位置
+// 只有 key 的位置和扫描时相同才替换，扫描之后 key 已经被更新或删除时跳过
+func (db *DB) rewriteLiveBlob(live *liveBlob) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	pos, err := db.getIndexPos(live.realKey)
+	if err != nil {
+		return err
+	}
+	if pos == nil || pos.Fid != live.refPos.Fid || pos.Offset != live.refPos.Offset {
+		return nil
+	}
+
+	blobPos, err := db.appendBlobLocked(live.realKey, live.value, live.refRecord.Timestamp)
+	if err != nil {
+		return err
+	}
+	refRecord := live.refRecord
+	refRecord.Key = logRecordKeyWithSeq(live.realKey, nonTransactionSeqNo)
+	refRecord.Value = data.EncodeLogRecordPos(blobPos)
+	pos, err = db.appendLogRecordLocked(refRecord)
+	if err != nil {
+		return err
+	}
+	if ok := db.updateIndexLocked(live.realKey, refRecord, pos); !ok {
+		return ErrIndexUpdateFailed
+	}
+	return db.finishWriteLocked()
+}
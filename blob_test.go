@@ -0,0 +1,275 @@
+package bitcask_kv_go
+
+import (
+	"bitcask-kv-go/data"
+	"bitcask-kv-go/fio"
+	"bitcask-kv-go/utils"
+	"bytes"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func initBlobDB(t *testing.T) (*DB, Options) {
+	t.Helper()
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.BlobThreshold = 1024
+	opts.BlobFileSize = 32 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	return db, opts
+}
+
+func TestDB_BlobValue(t *testing.T) {
+	db, opts := initBlobDB(t)
+
+	bigValue := bytes.Repeat([]byte("thumbnail"), 1000)
+	assert.Nil(t, db.Put([]byte("big"), bigValue))
+	assert.Nil(t, db.Put([]byte("small"), []byte("value")))
+
+	// 数据文件中只保存 blob 的位置
+	assert.Less(t, db.activeFile.WriteOff, int64(1024))
+	assert.Equal(t, 1, len(db.blobFiles))
+
+	check := func(db *DB) {
+		val, err := db.Get([]byte("big"))
+		assert.Nil(t, err)
+		assert.Equal(t, bigValue, val)
+
+		val, meta, err := db.GetWithMeta([]byte("big"))
+		assert.Nil(t, err)
+		assert.Equal(t, bigValue, val)
+		assert.Equal(t, int64(len(bigValue)), meta.ValueSize)
+
+		val, err = db.Get([]byte("small"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("value"), val)
+
+		seen := make(map[string][]byte)
+		assert.Nil(t, db.Fold(func(key []byte, value []byte) bool {
+			seen[string(key)] = value
+			return true
+		}))
+		assert.Equal(t, bigValue, seen["big"])
+	}
+	check(db)
+
+	// 重启以及 merge 之后，blob 位置依然有效
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	check(db)
+}
+
+func TestDB_GCBlobs(t *testing.T) {
+	db, opts := initBlobDB(t)
+
+	assert.Equal(t, ErrDiscardRatioInvalid, db.GCBlobs(0))
+
+	// 写入多个 blob 文件，然后覆盖其中大部分的 key
+	for i := 0; i < 40; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(2048)))
+	}
+	for i := 0; i < 30; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("small")))
+	}
+	values := make(map[int][]byte)
+	for i := 30; i < 40; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		values[i] = val
+	}
+
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	blobFilesBefore := stat.BlobFileNum
+	assert.Greater(t, blobFilesBefore, uint(2))
+
+	assert.Nil(t, db.GCBlobs(0.5))
+
+	stat, err = db.Stat()
+	assert.Nil(t, err)
+	assert.Less(t, stat.BlobFileNum, blobFilesBefore)
+
+	check := func(db *DB) {
+		for i := 0; i < 40; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			if i < 30 {
+				assert.Equal(t, []byte("small"), val)
+			} else {
+				assert.Equal(t, values[i], val)
+			}
+		}
+	}
+	check(db)
+
+	// 被回收的 blob 文件已经从磁盘删除
+	_, err = os.Stat(data.GetBlobFileName(opts.DirPath, 0))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	check(db)
+}
+
+func TestDB_BlobFileSizeDefault(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.BlobFileSize = -1
+	_, err := Open(opts)
+	assert.Equal(t, ErrBlobOptionsInvalid, err)
+
+	// 没有设置 blob 文件大小时使用默认值，不影响没有开启 blob 的数据库
+	opts.BlobFileSize = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() { _ = db.Close() }()
+	assert.Equal(t, DefaultOptions.BlobFileSize, db.options.BlobFileSize)
+}
+
+func TestDB_GCBlobsUpdatedAfterScan(t *testing.T) {
+	db, _ := initBlobDB(t)
+	defer db.Close()
+
+	for i := 0; i < 40; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(2048)))
+	}
+	db.mu.RLock()
+	blobFile := db.blobFiles[0]
+	db.mu.RUnlock()
+	lives, _, _, err := db.scanBlobFile(blobFile)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, lives)
+
+	// 扫描之后 key 被更新或删除，重写时不能覆盖新的数据
+	assert.Nil(t, db.Put(lives[0].realKey, []byte("updated")))
+	assert.Nil(t, db.Delete(lives[1].realKey))
+	for _, live := range lives {
+		assert.Nil(t, db.rewriteLiveBlob(live))
+	}
+
+	val, err := db.Get(lives[0].realKey)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("updated"), val)
+	_, err = db.Get(lives[1].realKey)
+	assert.Equal(t, ErrKeyNotFound, err)
+	for _, live := range lives[2:] {
+		val, err := db.Get(live.realKey)
+		assert.Nil(t, err)
+		assert.Equal(t, live.value, val)
+		pos := db.index.Get(live.realKey)
+		refRecord, err := db.readLogRecordUnlocked(pos)
+		assert.Nil(t, err)
+		assert.NotEqual(t, uint32(0), data.DecodeLogRecordPos(refRecord.Value).Fid)
+	}
+}
+
+func TestDB_GCBlobsConcurrentWrites(t *testing.T) {
+	db, _ := initBlobDB(t)
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(2048)))
+	}
+
+	// 回收期间并发地覆盖 key，回收结束之后每个 key 都是最后一次写入的值
+	expected := make(map[int][]byte)
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i += 2 {
+			value := utils.RandomValue(2048)
+			assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+			expected[i] = value
+		}
+	}()
+	assert.Nil(t, db.GCBlobs(0.1))
+	wg.Wait()
+
+	for i, value := range expected {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	for i := 1; i < 100; i += 2 {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+}
+
+// 只阻塞第一次读取的 IO，之后的读取直接进行
+type blockFirstReadIO struct {
+	fio.IOManager
+	blocked atomic.Bool
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockFirstReadIO(ioManager fio.IOManager) *blockFirstReadIO {
+	return &blockFirstReadIO{IOManager: ioManager, started: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (b *blockFirstReadIO) Read(buf []byte, offset int64) (int, error) {
+	if b.blocked.CompareAndSwap(false, true) {
+		close(b.started)
+		<-b.release
+	}
+	return b.IOManager.Read(buf, offset)
+}
+
+func TestDB_GCBlobsConcurrentGet(t *testing.T) {
+	db, _ := initBlobDB(t)
+	defer db.Close()
+
+	bigValue := bytes.Repeat([]byte("thumbnail"), 1000)
+	assert.Nil(t, db.Put([]byte("big"), bigValue))
+	assert.Nil(t, db.Put([]byte("garbage"), utils.RandomValue(2048)))
+	assert.Nil(t, db.Delete([]byte("garbage")))
+	db.mu.Lock()
+	assert.Nil(t, db.setActiveBlobFileLocked())
+	assert.Nil(t, db.rotateActiveFileLocked())
+	db.mu.Unlock()
+
+	// Get 读取到 blob 引用之前阻塞，回收期间 blob 的 value 被重写，旧的 blob 文件需要等待 Get 结束之后才能删除
+	blocked := newBlockFirstReadIO(db.olderFiles[0].IoManager)
+	db.olderFiles[0].IoManager = blocked
+	result := make(chan error, 1)
+	go func() {
+		val, err := db.Get([]byte("big"))
+		if err == nil && !bytes.Equal(bigValue, val) {
+			err = ErrKeyNotFound
+		}
+		result <- err
+	}()
+	<-blocked.started
+
+	gcDone := make(chan error, 1)
+	go func() { gcDone <- db.GCBlobs(0.1) }()
+	select {
+	case <-gcDone:
+		close(blocked.release)
+		<-result
+		t.Fatal("GCBlobs should wait for the read that holds the old blob reference")
+	case <-time.After(50 * time.Millisecond):
+	}
+	_, err := db.Get([]byte("big"))
+	assert.Nil(t, err)
+
+	close(blocked.release)
+	assert.Nil(t, <-result)
+	assert.Nil(t, <-gcDone)
+	_, err = os.Stat(data.GetBlobFileName(db.options.DirPath, 0))
+	assert.True(t, os.IsNotExist(err))
+}
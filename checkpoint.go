@@ -0,0 +1,163 @@
+package bitcask_kv_go
+
+import (
+	"bitcask-kv-go/data"
+	"bitcask-kv-go/index"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// 持久化索引的检查点，记录索引已经包含了哪些数据，之后的数据需要在打开数据库时重新加载
+type indexCheckpoint struct {
+	fid         uint32 // 检查点所在的数据文件 id
+	offset      int64  // 检查点在数据文件中的偏移
+	seqNo       uint64 // 事务序列号
+	mergeFileId uint32 // 已经应用到索引中的 merge 的 nonMergeFileId，0 表示没有发生过 merge
+	version     uint64 // 检查点之前已经分配的版本号
+}
+
+func (c *indexCheckpoint) encode() []byte {
+	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64*3)
+	var index = 0
+	index += binary.PutUvarint(buf[index:], uint64(c.fid))
+	index += binary.PutVarint(buf[index:], c.offset)
+	index += binary.PutUvarint(buf[index:], c.seqNo)
+	index += binary.PutUvarint(buf[index:], uint64(c.mergeFileId))
+	index += binary.PutUvarint(buf[index:], c.version)
+	return buf[:index]
+}
+
+// 解码检查点，没有检查点或者检查点无效时返回 nil
+func decodeIndexCheckpoint(buf []byte) *indexCheckpoint {
+	if len(buf) == 0 {
+		return nil
+	}
+	var index = 0
+	fid, n := binary.Uvarint(buf[index:])
+	if n <= 0 {
+		return nil
+	}
+	index += n
+	offset, n := binary.Varint(buf[index:])
+	if n <= 0 {
+		return nil
+	}
+	index += n
+	seqNo, n := binary.Uvarint(buf[index:])
+	if n <= 0 {
+		return nil
+	}
+	index += n
+	mergeFileId, n := binary.Uvarint(buf[index:])
+	if n <= 0 {
+		return nil
+	}
+	index += n
+	ckpt := &indexCheckpoint{fid: uint32(fid), offset: offset, seqNo: seqNo, mergeFileId: uint32(mergeFileId)}
+	// 之前写入的检查点没有记录版本号
+	if version, n := binary.Uvarint(buf[index:]); n > 0 {
+		ckpt.version = version
+	}
+	return ckpt
+}
+
+// 打开持久化的 B+ 树索引，索引文件损坏时删除并重建
+func openBPlusTree(dirPath string) (*index.BPlusTree, error) {
+	bpt, err := index.NewBPlusTree(dirPath)
+	if err == index.ErrBPlusTreeCorrupted {
+		if err := os.Remove(filepath.Join(dirPath, index.BPlusTreeFileName)); err != nil {
+			return nil, err
+		}
+		return index.NewBPlusTree(dirPath)
+	}
+	return bpt, err
+}
+
+// 加载持久化索引的检查点，检查点有效时只需要加载检查点之后的数据
+// 检查点无效时清空索引，从 hint 文件和数据文件中重建
+func (db *DB) loadIndexCheckpoint() error {
+	bpt, ok := db.index.(*index.BPlusTree)
+	if !ok {
+		return nil
+	}
+
+	var nonMergeFileId uint32
+	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
+	if _, err := os.Stat(mergeFinFileName); err == nil {
+		fid, _, err := db.getNonMergeFileId(db.options.DirPath)
+		if err != nil {
+			return err
+		}
+		nonMergeFileId = fid
+	}
+
+	ckpt := decodeIndexCheckpoint(bpt.Checkpoint())
+	valid := ckpt != nil && ckpt.fid >= nonMergeFileId &&
+		(len(db.fileIds) == 0 || ckpt.fid <= uint32(db.fileIds[len(db.fileIds)-1]))
+	if !valid {
+		if bpt.Size() == 0 {
+			return nil
+		}
+		// 重新创建空的索引文件
+		if err := bpt.Close(); err != nil {
+			return err
+		}
+		if err := os.Remove(filepath.Join(db.options.DirPath, index.BPlusTreeFileName)); err != nil {
+			return err
+		}
+		newBpt, err := index.NewBPlusTree(db.options.DirPath)
+		if err != nil {
+			return err
+		}
+		db.index = newBpt
+		return nil
+	}
+
+	// 检查点之后发生过 merge，索引中指向被 merge 的数据文件的位置需要更新
+	if ckpt.mergeFileId != nonMergeFileId {
+		if err := db.remapIndexFromHintFile(nonMergeFileId); err != nil {
+			return err
+		}
+	}
+	db.replayFrom = ckpt
+	return nil
+}
+
+// 提交持久化索引的修改，并将当前活跃文件的写入位置记录为检查点
+// force 为 false 时，只在未提交的修改较多时提交
+// 在访问此方法前必须持有互斥锁
+func (db *DB) commitIndexLocked(force bool) error {
+	bpt, ok := db.index.(*index.BPlusTree)
+	if !ok || (!force && !bpt.NeedCommit()) {
+		return nil
+	}
+	ckpt := &indexCheckpoint{seqNo: db.seqNo, mergeFileId: db.mergeBoundary, version: db.version}
+	if db.activeFile != nil {
+		// 检查点之前的数据必须已经持久化
+		if err := db.activeFile.Sync(); err != nil {
+			return err
+		}
+		ckpt.fid = db.activeFile.FileId
+		ckpt.offset = db.activeFile.WriteOff
+	}
+	return bpt.Commit(ckpt.encode())
+}
+
+// 写操作完成之后调用，为封存的数据文件生成 key 文件，并按需提交持久化索引
+// 在访问此方法前必须持有互斥锁
+func (db *DB) finishWriteLocked() error {
+	if err := db.sealFilesLocked(); err != nil {
+		return err
+	}
+	return db.commitIndexLocked(false)
+}
+
+// 关闭索引中打开的文件
+func closeIndexer(indexer index.Indexer) error {
+	if closer, ok := indexer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package bitcask_kv_go
+
+import (
+	"bitcask-kv-go/index"
+	"bitcask-kv-go/utils"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func initBPTreeDB(t *testing.T) (*DB, Options) {
+	t.Helper()
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.DataFileSize = 64 * 1024
+	opts.IndexType = BPTree
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	return db, opts
+}
+
+func TestDB_BPTreeIndex(t *testing.T) {
+	db, opts := initBPTreeDB(t)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Equal(t, ErrKeyIsTooLarge, db.Put(bytes.Repeat([]byte("k"), index.BPTreeMaxKeySize+1), []byte("v")))
+	assert.Nil(t, db.Close())
+
+	// 重新打开时直接使用检查点之前的索引，只加载检查点之后的数据
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db2.replayFrom)
+	assert.Equal(t, db2.activeFile.FileId, db2.replayFrom.fid)
+	assert.Equal(t, db2.activeFile.WriteOff, db2.replayFrom.offset)
+	assert.Equal(t, 1900, db2.index.Size())
+	_, err = db2.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(1000))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	// merge 之后根据 hint 文件更新索引中的位置
+	assert.Nil(t, db2.Merge())
+	assert.Nil(t, db2.Put(utils.GetTestKey(1), []byte("after merge")))
+	assert.Nil(t, db2.Close())
+
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	defer db3.Close()
+	assert.Equal(t, 1901, db3.index.Size())
+	keys := 0
+	assert.Nil(t, db3.Fold(func(key []byte, value []byte) bool {
+		keys++
+		return true
+	}))
+	assert.Equal(t, 1901, keys)
+	val, err = db3.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("after merge"), val)
+}
+
+func TestDB_BPTreeIndexRecover(t *testing.T) {
+	db, opts := initBPTreeDB(t)
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	assert.Nil(t, db.Sync())
+	// 检查点之后的写入没有提交到索引中，模拟进程崩溃
+	for i := 500; i < 600; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(16)))
+	}
+	assert.Nil(t, db.activeFile.Sync())
+	assert.Nil(t, db.activeFile.Close())
+	assert.Nil(t, closeIndexer(db.index))
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 600, db2.index.Size())
+	_, err = db2.Get(utils.GetTestKey(599))
+	assert.Nil(t, err)
+	assert.Nil(t, db2.Close())
+
+	// 索引文件损坏时重建索引
+	assert.Nil(t, os.WriteFile(filepath.Join(opts.DirPath, index.BPlusTreeFileName), []byte("corrupted"), 0644))
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	defer db3.Close()
+	assert.Equal(t, 600, db3.index.Size())
+}
@@ -0,0 +1,107 @@
+package bitcask_kv_go
+
+import "bytes"
+
+// PutIfAbsent key 不存在时写入数据，返回是否写入成功
+func (db *DB) PutIfAbsent(key, value []byte) (bool, error) {
+	if err := db.checkPutKey(key); err != nil {
+		return false, err
+	}
+
+	// 检查和写入在同一把锁内完成
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if pos, err := db.getIndexPos(key); pos != nil || err != nil {
+		return false, err
+	}
+	if err := db.putLocked(key, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CompareAndSwap key 当前的值等于 expected 时写入新的值，返回是否写入成功，key 不存在时不写入
+func (db *DB) CompareAndSwap(key, expected, value []byte) (bool, error) {
+	if err := db.checkPutKey(key); err != nil {
+		return false, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if ok, err := db.valueEqualsLocked(key, expected); !ok || err != nil {
+		return false, err
+	}
+	if err := db.putLocked(key, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteIfEquals key 当前的值等于 expected 时删除，返回是否删除成功
+func (db *DB) DeleteIfEquals(key, expected []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if ok, err := db.valueEqualsLocked(key, expected); !ok || err != nil {
+		return false, err
+	}
+	if err := db.deleteLocked(key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PutIfVersion key 当前的版本号等于 version 时写入数据，返回是否写入成功
+// key 的版本号可以通过 GetWithMeta 获取，version 为 0 表示 key 不存在（或者由旧版本写入，没有记录版本号）
+func (db *DB) PutIfVersion(key, value []byte, version uint64) (bool, error) {
+	if err := db.checkPutKey(key); err != nil {
+		return false, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var current uint64
+	pos, err := db.getIndexPos(key)
+	if err != nil {
+		return false, err
+	}
+	if pos != nil {
+		current = pos.Version
+	}
+	if current != version {
+		return false, nil
+	}
+	if err := db.putLocked(key, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// 检查写入的 key 是否有效
+func (db *DB) checkPutKey(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return db.checkKeySize(key)
+}
+
+// 判断 key 当前的值是否等于 expected，key 不存在时返回 false
+// 在访问此方法前必须持有锁
+func (db *DB) valueEqualsLocked(key, expected []byte) (bool, error) {
+	pos, err := db.getIndexPos(key)
+	if pos == nil || err != nil {
+		return false, err
+	}
+	value, err := db.getValueByPosition(pos)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(value, expected), nil
+}
@@ -0,0 +1,211 @@
+package bitcask_kv_go
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_PutIfAbsent(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+
+	_, err := db.PutIfAbsent(nil, []byte("v"))
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	ok, err := db.PutIfAbsent([]byte("leader"), []byte("node-1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = db.PutIfAbsent([]byte("leader"), []byte("node-2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	val, err := db.Get([]byte("leader"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("node-1"), val)
+}
+
+func TestDB_CompareAndSwap(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+
+	// key 不存在
+	ok, err := db.CompareAndSwap([]byte("k"), nil, []byte("v1"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	assert.Nil(t, db.Put([]byte("k"), []byte("v1")))
+	ok, err = db.CompareAndSwap([]byte("k"), []byte("v0"), []byte("v2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.CompareAndSwap([]byte("k"), []byte("v1"), []byte("v2"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	val, err := db.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+}
+
+func TestDB_DeleteIfEquals(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+
+	assert.Nil(t, db.Put([]byte("lock"), []byte("owner-a")))
+	ok, err := db.DeleteIfEquals([]byte("lock"), []byte("owner-b"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.DeleteIfEquals([]byte("lock"), []byte("owner-a"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	_, err = db.Get([]byte("lock"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	ok, err = db.DeleteIfEquals([]byte("lock"), []byte("owner-a"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestDB_PutIfVersion(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	// 版本号 0 表示 key 不存在
+	ok, err := db.PutIfVersion([]byte("k"), []byte("v1"), 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_, meta, err := db.GetWithMeta([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), meta.Version)
+
+	ok, err = db.PutIfVersion([]byte("k"), []byte("v2"), 0)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.PutIfVersion([]byte("k"), []byte("v2"), 1)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// 普通写入和批量写入同样递增版本号
+	assert.Nil(t, db.Put([]byte("k"), []byte("v3")))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("k"), []byte("v4")))
+	assert.Nil(t, wb.Commit())
+	_, meta, err = db.GetWithMeta([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), meta.Version)
+
+	// 版本号在重启和 merge 之后保持不变
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer func() { _ = db.Close() }()
+
+	_, meta, err = db.GetWithMeta([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), meta.Version)
+	ok, err = db.PutIfVersion([]byte("k"), []byte("v5"), 3)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.PutIfVersion([]byte("k"), []byte("v5"), 4)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestDB_CompareAndSwapConcurrent(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+
+	// 多个 goroutine 同时竞争，只有一个能够成功
+	var wins int
+	var mu sync.Mutex
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, err := db.PutIfVersion([]byte("leader"), []byte("candidate"), 0)
+			assert.Nil(t, err)
+			if ok {
+				mu.Lock()
+				wins++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, wins)
+}
+
+func TestDB_VersionAfterDelete(t *testing.T) {
+	cases := map[string]func(opts *Options){
+		"btree":      func(opts *Options) {},
+		"low memory": func(opts *Options) { opts.LowMemory = true },
+		"bptree":     func(opts *Options) { opts.IndexType = BPTree },
+	}
+	for name, setup := range cases {
+		t.Run(name, func(t *testing.T) {
+			opts := DefaultOptions
+			opts.DirPath = t.TempDir()
+			opts.DataFileSize = 4 * 1024
+			setup(&opts)
+			db, err := Open(opts)
+			assert.Nil(t, err)
+
+			key := []byte("k")
+			assert.Nil(t, db.Put(key, []byte("v1")))
+			for i := 0; i < 100; i++ {
+				assert.Nil(t, db.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte("value")))
+			}
+			assert.Nil(t, db.Put(key, []byte("v2")))
+			_, meta, err := db.GetWithMeta(key)
+			assert.Nil(t, err)
+			oldVersion := meta.Version
+
+			// 删除之后重新写入，版本号不会和删除之前的版本重复
+			assert.Nil(t, db.Delete(key))
+			deleted := db.version
+			assert.Greater(t, deleted, oldVersion)
+			ok, err := db.PutIfVersion(key, []byte("v3"), oldVersion)
+			assert.Nil(t, err)
+			assert.False(t, ok)
+			// 封存删除记录所在的数据文件，低内存模式下重新打开时只加载 key 文件
+			db.mu.Lock()
+			assert.Nil(t, db.rotateActiveFileLocked())
+			assert.Nil(t, db.sealFilesLocked())
+			db.mu.Unlock()
+			assert.Nil(t, db.Close())
+
+			db, err = Open(opts)
+			assert.Nil(t, err)
+			ok, err = db.PutIfVersion(key, []byte("v3"), 0)
+			assert.Nil(t, err)
+			assert.True(t, ok)
+			_, meta, err = db.GetWithMeta(key)
+			assert.Nil(t, err)
+			assert.Greater(t, meta.Version, deleted)
+
+			// merge 丢弃删除记录之后，版本号同样不会重复
+			assert.Nil(t, db.Delete(key))
+			deleted = db.version
+			assert.Nil(t, db.Merge())
+			assert.Nil(t, db.Close())
+			db, err = Open(opts)
+			assert.Nil(t, err)
+			defer func() { _ = db.Close() }()
+			_, err = db.Get(key)
+			assert.Equal(t, ErrKeyNotFound, err)
+			assert.Nil(t, db.Put(key, []byte("v4")))
+			_, meta, err = db.GetWithMeta(key)
+			assert.Nil(t, err)
+			assert.Greater(t, meta.Version, deleted)
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package bitcask_kv_go
+
+// CountPrefix 统计前缀为 prefix 的 key 数量，只访问内存索引
+func (db *DB) CountPrefix(prefix []byte) int {
+	return db.count(IteratorOptions{Prefix: prefix, KeyOnly: true})
+}
+
+// CountRange 统计 [start, end) 范围内的 key 数量，start 或 end 为 nil 时表示不限制，只访问内存索引
+func (db *DB) CountRange(start, end []byte) int {
+	return db.count(IteratorOptions{LowerBound: start, UpperBound: end, KeyOnly: true})
+}
+
+// EstimateSize 估算 [start, end) 范围内的数据在数据文件中占用的字节数
+// 根据索引中记录的数据大小计算，不会读取数据文件；分离到 blob 文件中的 value 和共享的 value 不计算在内，
+// 旧版本写入、没有记录大小的数据按照已知大小的平均值估算
+func (db *DB) EstimateSize(start, end []byte) int64 {
+	it := db.NewIterator(IteratorOptions{LowerBound: start, UpperBound: end, KeyOnly: true})
+	defer it.Close()
+
+	var total, known, unknown int64
+	for ; it.Valid(); it.Next() {
+		if size := it.indexIter.Value().Size; size > 0 {
+			total += int64(size)
+			known++
+		} else {
+			unknown++
+		}
+	}
+	if known > 0 {
+		total += total / known * unknown
+	}
+	return total
+}
+
+func (db *DB) count(opts IteratorOptions) int {
+	it := db.NewIterator(opts)
+	defer it.Close()
+
+	var n int
+	for ; it.Valid(); it.Next() {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,76 @@
+package bitcask_kv_go
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_CountPrefixAndRange(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+
+	assert.Equal(t, 0, db.CountPrefix([]byte("user:")))
+	for i := 0; i < 50; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("user:%02d", i)), []byte("v")))
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("order:%02d", i)), []byte("v")))
+	}
+	assert.Nil(t, db.Delete([]byte("user:10")))
+
+	assert.Equal(t, 49, db.CountPrefix([]byte("user:")))
+	assert.Equal(t, 10, db.CountPrefix([]byte("user:2")))
+	assert.Equal(t, 99, db.CountPrefix(nil))
+	assert.Equal(t, 9, db.CountRange([]byte("user:10"), []byte("user:20")))
+	assert.Equal(t, 50, db.CountRange(nil, []byte("user:")))
+}
+
+func TestIterator_KeyOnly(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+
+	assert.Nil(t, db.Put([]byte("a"), []byte("value-a")))
+	assert.Nil(t, db.Put([]byte("b"), []byte("value-b")))
+
+	iter := db.NewIterator(IteratorOptions{KeyOnly: true})
+	defer iter.Close()
+	assert.True(t, iter.Valid())
+	assert.Equal(t, []byte("a"), iter.Key())
+	_, err := iter.Value()
+	assert.Equal(t, ErrKeyOnlyIterator, err)
+
+	page, _, err := db.Scan(IteratorOptions{KeyOnly: true}, "", 10)
+	assert.Nil(t, err)
+	assert.Equal(t, []KeyValue{{Key: []byte("a")}, {Key: []byte("b")}}, page)
+}
+
+func TestDB_EstimateSize(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key-%03d", i)), make([]byte, 100)))
+	}
+	// 每条数据至少包含 key 和 value
+	all := db.EstimateSize(nil, nil)
+	assert.GreaterOrEqual(t, all, int64(100*107))
+	half := db.EstimateSize([]byte("key-000"), []byte("key-050"))
+	assert.Equal(t, all/2, half)
+	assert.Equal(t, int64(0), db.EstimateSize([]byte("z"), nil))
+
+	// 重启之后从数据文件中恢复数据大小
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, all, db.EstimateSize(nil, nil))
+
+	// merge 之后从 hint 文件中恢复数据大小
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer func() { _ = db.Close() }()
+	assert.Equal(t, all, db.EstimateSize(nil, nil))
+}
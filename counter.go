@@ -0,0 +1,238 @@
+package bitcask_kv_go
+
+import (
+	"bitcask-kv-go/data"
+	"encoding/binary"
+	"math"
+)
+
+// 数值固定编码为 8 字节的大端序，整数使用补码，浮点数使用 IEEE 754
+const numberSize = 8
+
+// 数值增量
+type numberDelta struct {
+	isFloat    bool
+	intDelta   int64
+	floatDelta float64
+	result     *IncrResult // WriteBatch 中的增量提交之后记录结果
+}
+
+// IncrResult WriteBatch 中一个增量的结果，批次提交成功之后才能读取
+// 同一个 key 的多个增量依次累加，每个结果是累加到这个增量为止的值
+type IncrResult struct {
+	value []byte // 累加之后编码的值，批次提交成功之前为 nil
+}
+
+// Int 返回 Incr 累加之后的整数，批次还没有提交成功，或者增量被之后的 Put、Delete 覆盖时返回 ErrIncrNotCommitted
+func (r *IncrResult) Int() (int64, error) {
+	if r.value == nil {
+		return 0, ErrIncrNotCommitted
+	}
+	return decodeInt(r.value), nil
+}
+
+// Float 返回 IncrFloat 累加之后的浮点数，错误同 Int
+func (r *IncrResult) Float() (float64, error) {
+	if r.value == nil {
+		return 0, ErrIncrNotCommitted
+	}
+	return decodeFloat(r.value), nil
+}
+
+// 增量计算出的值，提交成功之后设置到对应的结果中
+type incrOutcome struct {
+	result *IncrResult
+	value  []byte
+}
+
+// Incr 将 key 对应的整数加上 delta，返回相加之后的值，key 不存在时从 0 开始
+func (db *DB) Incr(key []byte, delta int64) (int64, error) {
+	value, err := db.incr(key, numberDelta{intDelta: delta})
+	if err != nil {
+		return 0, err
+	}
+	return decodeInt(value), nil
+}
+
+// IncrFloat 将 key 对应的浮点数加上 delta，返回相加之后的值，key 不存在时从 0 开始
+func (db *DB) IncrFloat(key []byte, delta float64) (float64, error) {
+	value, err := db.incr(key, numberDelta{isFloat: true, floatDelta: delta})
+	if err != nil {
+		return 0, err
+	}
+	return decodeFloat(value), nil
+}
+
+// GetInt 读取 Incr 写入的整数
+func (db *DB) GetInt(key []byte) (int64, error) {
+	value, err := db.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(value) != numberSize {
+		return 0, ErrValueIsNotNumber
+	}
+	return decodeInt(value), nil
+}
+
+// GetFloat 读取 IncrFloat 写入的浮点数
+func (db *DB) GetFloat(key []byte) (float64, error) {
+	value, err := db.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(value) != numberSize {
+		return 0, ErrValueIsNotNumber
+	}
+	return decodeFloat(value), nil
+}
+
+func (db *DB) incr(key []byte, delta numberDelta) ([]byte, error) {
+	if err := db.checkPutKey(key); err != nil {
+		return nil, err
+	}
+
+	// 读取、相加和写入在同一把锁内完成
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var current []byte
+	pos, err := db.getIndexPos(key)
+	if err != nil {
+		return nil, err
+	}
+	if pos != nil {
+		value, err := db.getValueByPosition(pos)
+		if err != nil {
+			return nil, err
+		}
+		current = value
+	}
+	value, err := delta.apply(current)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.putLocked(key, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Incr 暂存一个整数增量，提交时在 key 当前的值（包括本批次中之前的写入）上累加
+// 返回的 IncrResult 在 Commit 成功之后可以读取累加之后的值
+func (wb *WriteBatch) Incr(key []byte, delta int64) (*IncrResult, error) {
+	return wb.incr(key, numberDelta{intDelta: delta})
+}
+
+// IncrFloat 暂存一个浮点数增量，提交时在 key 当前的值（包括本批次中之前的写入）上累加
+// 返回的 IncrResult 在 Commit 成功之后可以读取累加之后的值
+func (wb *WriteBatch) IncrFloat(key []byte, delta float64) (*IncrResult, error) {
+	return wb.incr(key, numberDelta{isFloat: true, floatDelta: delta})
+}
+
+func (wb *WriteBatch) incr(key []byte, delta numberDelta) (*IncrResult, error) {
+	if err := wb.db.checkPutKey(key); err != nil {
+		return nil, err
+	}
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	delta.result = new(IncrResult)
+	wb.pendingIncrs[string(key)] = append(wb.pendingIncrs[string(key)], delta)
+	return delta.result, nil
+}
+
+// 批次中涉及的 key 数量
+func (wb *WriteBatch) pendingCount() int {
+	count := len(wb.pendingWrites)
+	for key := range wb.pendingIncrs {
+		if _, ok := wb.pendingWrites[key]; !ok {
+			count++
+		}
+	}
+	return count
+}
+
+// 将暂存的增量累加到当前的值上，返回需要写入的所有记录和每个增量的结果，暂存的数据保持不变，提交失败之后可以重试
+// 在访问此方法前必须持有数据库的互斥锁
+func (wb *WriteBatch) resolveIncrsLocked() (map[string]*data.LogRecord, []incrOutcome, error) {
+	if len(wb.pendingIncrs) == 0 {
+		return wb.pendingWrites, nil, nil
+	}
+
+	writes := make(map[string]*data.LogRecord, wb.pendingCount())
+	var outcomes []incrOutcome
+	for key, record := range wb.pendingWrites {
+		writes[key] = record
+	}
+	for key, deltas := range wb.pendingIncrs {
+		var current []byte
+		if record, ok := wb.pendingWrites[key]; ok {
+			if record.Type == data.LogRecordNormal {
+				current = record.Value
+			}
+		} else if pos, err := wb.db.getIndexPos([]byte(key)); err != nil {
+			return nil, nil, err
+		} else if pos != nil {
+			value, err := wb.db.getValueByPosition(pos)
+			if err != nil {
+				return nil, nil, err
+			}
+			current = value
+		}
+		for _, delta := range deltas {
+			value, err := delta.apply(current)
+			if err != nil {
+				return nil, nil, err
+			}
+			current = value
+			outcomes = append(outcomes, incrOutcome{result: delta.result, value: value})
+		}
+		writes[key] = &data.LogRecord{Key: []byte(key), Value: current}
+	}
+	return writes, outcomes, nil
+}
+
+// 将增量累加到编码后的值上，值为空时从 0 开始
+func (d numberDelta) apply(current []byte) ([]byte, error) {
+	if current != nil && len(current) != numberSize {
+		return nil, ErrValueIsNotNumber
+	}
+	if d.isFloat {
+		var value float64
+		if current != nil {
+			value = decodeFloat(current)
+		}
+		return encodeFloat(value + d.floatDelta), nil
+	}
+
+	var value int64
+	if current != nil {
+		value = decodeInt(current)
+	}
+	if (d.intDelta > 0 && value > math.MaxInt64-d.intDelta) ||
+		(d.intDelta < 0 && value < math.MinInt64-d.intDelta) {
+		return nil, ErrIncrOverflow
+	}
+	return encodeInt(value + d.intDelta), nil
+}
+
+func encodeInt(v int64) []byte {
+	buf := make([]byte, numberSize)
+	binary.BigEndian.PutUint64(buf, uint64(v))
+	return buf
+}
+
+func decodeInt(buf []byte) int64 {
+	return int64(binary.BigEndian.Uint64(buf))
+}
+
+func encodeFloat(v float64) []byte {
+	buf := make([]byte, numberSize)
+	binary.BigEndian.PutUint64(buf, math.Float64bits(v))
+	return buf
+}
+
+func decodeFloat(buf []byte) float64 {
+	return math.Float64frombits(binary.BigEndian.Uint64(buf))
+}
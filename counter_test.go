@@ -0,0 +1,154 @@
+package bitcask_kv_go
+
+import (
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Incr(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	_, err = db.Incr(nil, 1)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+	_, err = db.GetInt([]byte("hits"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	n, err := db.Incr([]byte("hits"), 5)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), n)
+	n, err = db.Incr([]byte("hits"), -7)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-2), n)
+
+	// 重启之后数值保持不变
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer func() { _ = db.Close() }()
+	n, err = db.GetInt([]byte("hits"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-2), n)
+
+	// 不是数值的 value
+	assert.Nil(t, db.Put([]byte("name"), []byte("bitcask")))
+	_, err = db.Incr([]byte("name"), 1)
+	assert.Equal(t, ErrValueIsNotNumber, err)
+	_, err = db.GetInt([]byte("name"))
+	assert.Equal(t, ErrValueIsNotNumber, err)
+
+	// 溢出
+	_, err = db.Incr([]byte("max"), math.MaxInt64)
+	assert.Nil(t, err)
+	_, err = db.Incr([]byte("max"), 1)
+	assert.Equal(t, ErrIncrOverflow, err)
+}
+
+func TestDB_IncrFloat(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+
+	f, err := db.IncrFloat([]byte("score"), 1.5)
+	assert.Nil(t, err)
+	assert.Equal(t, 1.5, f)
+	f, err = db.IncrFloat([]byte("score"), 0.25)
+	assert.Nil(t, err)
+	assert.Equal(t, 1.75, f)
+	f, err = db.GetFloat([]byte("score"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1.75, f)
+}
+
+func TestDB_IncrConcurrent(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := db.Incr([]byte("counter"), 1)
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	n, err := db.GetInt([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000), n)
+}
+
+func TestWriteBatch_Incr(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+
+	_, err := db.Incr([]byte("a"), 10)
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	a1, err := wb.Incr([]byte("a"), 1)
+	assert.Nil(t, err)
+	a2, err := wb.Incr([]byte("a"), 2)
+	assert.Nil(t, err)
+	// 在本批次之前的写入上累加
+	assert.Nil(t, wb.Put([]byte("b"), encodeInt(100)))
+	b, err := wb.Incr([]byte("b"), -1)
+	assert.Nil(t, err)
+	c, err := wb.IncrFloat([]byte("c"), 0.5)
+	assert.Nil(t, err)
+	// 之后的写入覆盖之前暂存的增量
+	d, err := wb.Incr([]byte("d"), 1)
+	assert.Nil(t, err)
+	assert.Nil(t, wb.Put([]byte("d"), encodeInt(7)))
+
+	// 提交之前不可见
+	n, err := db.GetInt([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), n)
+	_, err = a1.Int()
+	assert.Equal(t, ErrIncrNotCommitted, err)
+
+	assert.Nil(t, wb.Commit())
+	for key, expected := range map[string]int64{"a": 13, "b": 99, "d": 7} {
+		n, err := db.GetInt([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, n)
+	}
+	f, err := db.GetFloat([]byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0.5, f)
+
+	// 提交之后通过返回的结果读取每个增量累加之后的值
+	for result, expected := range map[*IncrResult]int64{a1: 11, a2: 13, b: 99} {
+		n, err := result.Int()
+		assert.Nil(t, err)
+		assert.Equal(t, expected, n)
+	}
+	f, err = c.Float()
+	assert.Nil(t, err)
+	assert.Equal(t, 0.5, f)
+	_, err = d.Int()
+	assert.Equal(t, ErrIncrNotCommitted, err)
+
+	// 增量计算失败时不写入任何数据，也不设置结果
+	assert.Nil(t, db.Put([]byte("name"), []byte("bitcask")))
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	a3, err := wb.Incr([]byte("a"), 1)
+	assert.Nil(t, err)
+	_, err = wb.Incr([]byte("name"), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrValueIsNotNumber, wb.Commit())
+	n, err = db.GetInt([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(13), n)
+	_, err = a3.Int()
+	assert.Equal(t, ErrIncrNotCommitted, err)
+}
@@ -0,0 +1,117 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrDecryptFailed         = errors.New("failed to decrypt log record, the encryption key may be wrong")
+	ErrEncryptionKeyRequired = errors.New("the file is encrypted but no encryption key is provided")
+)
+
+// 文件头中用于校验密钥是否正确的明文
+var keyCheckPlaintext = []byte("bitcask-key-check")
+
+// Cipher 使用 AES-GCM 对单条记录进行加解密
+type Cipher struct {
+	KeyId uint32 // 密钥 id，记录在文件头中
+	aead  cipher.AEAD
+}
+
+// NewCipher 根据密钥初始化 Cipher，密钥长度为 16、24 或 32 字节
+func NewCipher(keyId uint32, key []byte) (*Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{KeyId: keyId, aead: aead}, nil
+}
+
+// 加密数据，返回 nonce + 密文，additionalData 不会被加密，但是解密时需要提供相同的数据
+func (c *Cipher) seal(plaintext, additionalData []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	buf := make([]byte, nonceSize, c.sealedSize(len(plaintext)))
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(buf, buf[:nonceSize], plaintext, additionalData), nil
+}
+
+// seal 生成的数据的长度
+func (c *Cipher) sealedSize(plaintextSize int) int {
+	return c.aead.NonceSize() + plaintextSize + c.aead.Overhead()
+}
+
+// 解密 seal 生成的数据
+func (c *Cipher) open(ciphertext, additionalData []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, ErrDecryptFailed
+	}
+	plaintext, err := c.aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], additionalData)
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return plaintext, nil
+}
+
+// 加密 LogRecord，key 和 value 一起加密后作为新的 value
+// | key size (变长) | key | value |
+// 记录的 header（类型、标志位、写入时间、长度、上一个版本的位置和版本号）作为附加数据参与认证，被篡改之后无法解密
+func (c *Cipher) encryptLogRecord(logRecord *LogRecord) (*LogRecord, error) {
+	plaintext := make([]byte, binary.MaxVarintLen32+len(logRecord.Key)+len(logRecord.Value))
+	index := binary.PutUvarint(plaintext, uint64(len(logRecord.Key)))
+	index += copy(plaintext[index:], logRecord.Key)
+	index += copy(plaintext[index:], logRecord.Value)
+
+	encrypted := *logRecord
+	encrypted.Key = nil
+	encrypted.Flags |= FlagEncrypted
+	header := make([]byte, maxLogRecordHeaderSize)
+	headerSize := encodeLogRecordHeader(header, &encrypted, c.sealedSize(index))
+
+	ciphertext, err := c.seal(plaintext[:index], header[:headerSize])
+	if err != nil {
+		return nil, err
+	}
+	encrypted.Value = ciphertext
+	return &encrypted, nil
+}
+
+// 解密 LogRecord，并清除加密标志位
+func (c *Cipher) decryptLogRecord(logRecord *LogRecord) error {
+	header := make([]byte, maxLogRecordHeaderSize)
+	headerSize := encodeLogRecordHeader(header, logRecord, len(logRecord.Value))
+	plaintext, err := c.open(logRecord.Value, header[:headerSize])
+	if err != nil {
+		return err
+	}
+	keySize, n := binary.Uvarint(plaintext)
+	if n <= 0 || uint64(len(plaintext)-n) < keySize {
+		return ErrDecryptFailed
+	}
+	logRecord.Key = plaintext[n : n+int(keySize)]
+	logRecord.Value = plaintext[n+int(keySize):]
+	logRecord.Flags &^= FlagEncrypted
+	return nil
+}
+
+// VerifyFileHeader 校验密钥是否能够解密文件头，密钥错误时返回 ErrDecryptFailed
+func VerifyFileHeader(c *Cipher, header *FileHeader) error {
+	plaintext, err := c.open(header.keyCheck, nil)
+	if err != nil {
+		return err
+	}
+	if string(plaintext) != string(keyCheckPlaintext) {
+		return ErrDecryptFailed
+	}
+	return nil
+}
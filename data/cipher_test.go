@@ -0,0 +1,115 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCipher_EncryptDecryptLogRecord(t *testing.T) {
+	c, err := NewCipher(1, bytes.Repeat([]byte("k"), 32))
+	assert.Nil(t, err)
+
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go"), Type: LogRecordNormal}
+	encrypted, err := c.encryptLogRecord(rec)
+	assert.Nil(t, err)
+	assert.Nil(t, encrypted.Key)
+	assert.NotEqual(t, FlagEncrypted&encrypted.Flags, uint32(0))
+	assert.False(t, bytes.Contains(encrypted.Value, []byte("bitcask-go")))
+
+	err = c.decryptLogRecord(encrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, rec.Key, encrypted.Key)
+	assert.Equal(t, rec.Value, encrypted.Value)
+	assert.Equal(t, uint32(0), encrypted.Flags)
+
+	t.Run("wrong key", func(t *testing.T) {
+		encrypted, err := c.encryptLogRecord(rec)
+		assert.Nil(t, err)
+		wrong, err := NewCipher(1, bytes.Repeat([]byte("x"), 32))
+		assert.Nil(t, err)
+		assert.Equal(t, ErrDecryptFailed, wrong.decryptLogRecord(encrypted))
+	})
+
+	t.Run("tampered header", func(t *testing.T) {
+		for _, tamper := range []func(rec *LogRecord){
+			func(rec *LogRecord) { rec.Type = LogRecordDeleted },
+			func(rec *LogRecord) { rec.Timestamp++ },
+			func(rec *LogRecord) { rec.Version = 7 },
+			func(rec *LogRecord) { rec.Prev = &LogRecordPos{Fid: 1, Offset: 2} },
+			func(rec *LogRecord) { rec.Flags |= FlagDedupRef },
+		} {
+			encrypted, err := c.encryptLogRecord(rec)
+			assert.Nil(t, err)
+			tamper(encrypted)
+			assert.Equal(t, ErrDecryptFailed, c.decryptLogRecord(encrypted))
+		}
+	})
+}
+
+func TestFileHeader(t *testing.T) {
+	c, err := NewCipher(7, bytes.Repeat([]byte("k"), 16))
+	assert.Nil(t, err)
+
+	buf, err := EncodeFileHeader(c)
+	assert.Nil(t, err)
+	header, err := DecodeFileHeader(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, CurrentFileFormat, header.Format)
+	assert.Equal(t, uint32(7), header.KeyId)
+	assert.True(t, header.Encrypted())
+	assert.Nil(t, VerifyFileHeader(c, header))
+
+	wrong, err := NewCipher(7, bytes.Repeat([]byte("x"), 16))
+	assert.Nil(t, err)
+	assert.Equal(t, ErrDecryptFailed, VerifyFileHeader(wrong, header))
+}
+
+func TestDataFile_ReadEncryptedLogRecord(t *testing.T) {
+	dataFile, err := OpenDataFile(t.TempDir(), 0)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	c, err := NewCipher(1, bytes.Repeat([]byte("k"), 32))
+	assert.Nil(t, err)
+	dataFile.SetCipher(c)
+
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go"), Type: LogRecordNormal}
+	encRec, _, err := dataFile.EncodeLogRecord(rec)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Write(encRec))
+
+	readRec, _, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, rec.Key, readRec.Key)
+	assert.Equal(t, rec.Value, readRec.Value)
+
+	// 没有密钥时无法读取
+	dataFile.SetCipher(nil)
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.Equal(t, ErrEncryptionKeyRequired, err)
+}
+
+func TestDataFile_ReadTamperedEncryptedLogRecord(t *testing.T) {
+	dataFile, err := OpenDataFile(t.TempDir(), 0)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	c, err := NewCipher(1, bytes.Repeat([]byte("k"), 32))
+	assert.Nil(t, err)
+	dataFile.SetCipher(c)
+
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-go"), Type: LogRecordNormal}
+	encRec, _, err := dataFile.EncodeLogRecord(rec)
+	assert.Nil(t, err)
+
+	// 修改记录的类型并重新计算 crc，crc 校验通过，但是无法解密
+	encRec[crc32.Size] = LogRecordDeleted
+	binary.LittleEndian.PutUint32(encRec, crc32.ChecksumIEEE(encRec[crc32.Size:]))
+	assert.Nil(t, dataFile.Write(encRec))
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.Equal(t, ErrDecryptFailed, err)
+}
@@ -0,0 +1,94 @@
+package data
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+)
+
+var (
+	ErrUnsupportedCompression = errors.New("unsupported compression type")
+)
+
+type CompressionType = byte
+
+const (
+	// 不压缩
+	CompressionNone CompressionType = iota
+	// 标准库 compress/flate
+	CompressionFlate
+	// 标准库 compress/gzip
+	CompressionGzip
+)
+
+// 所有表示压缩的标志位
+const compressFlags = FlagCompressFlate | FlagCompressGzip
+
+// CompressLogRecord 使用指定的算法压缩 value，返回新的 LogRecord
+// 如果压缩之后没有变小，则直接返回原始的 LogRecord
+func CompressLogRecord(logRecord *LogRecord, typ CompressionType, level int) (*LogRecord, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var flag uint32
+	var err error
+	switch typ {
+	case CompressionFlate:
+		w, err = flate.NewWriter(&buf, level)
+		flag = FlagCompressFlate
+	case CompressionGzip:
+		w, err = gzip.NewWriterLevel(&buf, level)
+		flag = FlagCompressGzip
+	default:
+		return nil, ErrUnsupportedCompression
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(logRecord.Value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	if buf.Len() >= len(logRecord.Value) {
+		return logRecord, nil
+	}
+	compressed := *logRecord
+	compressed.Value = buf.Bytes()
+	compressed.Flags = logRecord.Flags&^compressFlags | flag
+	return &compressed, nil
+}
+
+// IsCompressed 判断 LogRecord 的 value 是否经过压缩
+func IsCompressed(logRecord *LogRecord) bool {
+	return logRecord.Flags&compressFlags != 0
+}
+
+// 解压 value，并清除压缩标志位
+func decompressLogRecord(logRecord *LogRecord) error {
+	var r io.ReadCloser
+	var err error
+	switch {
+	case logRecord.Flags&FlagCompressFlate != 0:
+		r = flate.NewReader(bytes.NewReader(logRecord.Value))
+	case logRecord.Flags&FlagCompressGzip != 0:
+		r, err = gzip.NewReader(bytes.NewReader(logRecord.Value))
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	value, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	logRecord.Value = value
+	logRecord.Flags &^= compressFlags
+	return nil
+}
@@ -0,0 +1,67 @@
+package data
+
+import (
+	"bytes"
+	"compress/flate"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressLogRecord(t *testing.T) {
+	value := bytes.Repeat([]byte(`{"name":"bitcask","type":"kv"}`), 100)
+
+	for _, typ := range []CompressionType{CompressionFlate, CompressionGzip} {
+		rec := &LogRecord{Key: []byte("name"), Value: value, Type: LogRecordNormal}
+		compressed, err := CompressLogRecord(rec, typ, flate.DefaultCompression)
+		assert.Nil(t, err)
+		assert.True(t, IsCompressed(compressed))
+		assert.Less(t, len(compressed.Value), len(value))
+		// 原始记录不会被修改
+		assert.False(t, IsCompressed(rec))
+
+		err = decompressLogRecord(compressed)
+		assert.Nil(t, err)
+		assert.False(t, IsCompressed(compressed))
+		assert.Equal(t, value, compressed.Value)
+	}
+
+	t.Run("incompressible value", func(t *testing.T) {
+		rec := &LogRecord{Key: []byte("name"), Value: []byte("a"), Type: LogRecordNormal}
+		compressed, err := CompressLogRecord(rec, CompressionFlate, flate.DefaultCompression)
+		assert.Nil(t, err)
+		assert.Same(t, rec, compressed)
+	})
+
+	t.Run("unsupported type", func(t *testing.T) {
+		rec := &LogRecord{Key: []byte("name"), Value: value, Type: LogRecordNormal}
+		_, err := CompressLogRecord(rec, CompressionNone, flate.DefaultCompression)
+		assert.Equal(t, ErrUnsupportedCompression, err)
+	})
+}
+
+func TestDataFile_ReadCompressedLogRecord(t *testing.T) {
+	dataFile, err := OpenDataFile(t.TempDir(), 0)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	value := bytes.Repeat([]byte("bitcask-"), 64)
+	rec := &LogRecord{Key: []byte("name"), Value: value, Type: LogRecordNormal}
+	compressed, err := CompressLogRecord(rec, CompressionGzip, flate.BestCompression)
+	assert.Nil(t, err)
+
+	// 压缩和未压缩的记录可以写在同一个文件中
+	encRec1, size1 := EncodeLogRecord(compressed)
+	assert.Nil(t, dataFile.Write(encRec1))
+	encRec2, _ := EncodeLogRecord(rec)
+	assert.Nil(t, dataFile.Write(encRec2))
+
+	readRec1, _, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, value, readRec1.Value)
+	assert.False(t, IsCompressed(readRec1))
+
+	readRec2, _, err := dataFile.ReadLogRecord(size1)
+	assert.Nil(t, err)
+	assert.Equal(t, value, readRec2.Value)
+}
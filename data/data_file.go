@@ -7,6 +7,7 @@ import (
 	"hash/crc32"
 	"io"
 	"path/filepath"
+	"sync"
 )
 
 var (
@@ -15,6 +16,8 @@ var (
 
 const (
 	DataFileNameSuffix    = ".data"
+	BlobFileNameSuffix    = ".blob"
+	KeyFileNameSuffix     = ".keys"
 	HintFileName          = "hint-index"
 	MergeFinishedFileName = "merge-finished"
 )
@@ -24,6 +27,16 @@ type DataFile struct {
 	FileId    uint32        // 文件id
 	WriteOff  int64         // 文件写到了哪个位置
 	IoManager fio.IOManager // io 读写管理
+	cipher    *Cipher       // 加解密，为 nil 时表示文件未加密
+	header    *FileHeader   // 文件头，为 nil 时表示文件没有文件头
+	format    uint64        // 文件的格式版本，决定如何解码记录的 header
+
+	footer    *FileFooter // 文件封存之后写入的 footer，为 nil 时表示文件没有封存
+	records   int64       // 写入的记录数量，用于生成 footer
+	checksum  uint32      // 写入的所有数据的校验值，用于生成 footer
+	statsDone bool        // records 和 checksum 是否包含了文件中所有的数据，打开已有数据的文件时需要在封存时重新计算
+
+	pins sync.WaitGroup // 正在进行的不持有数据库锁的读取，封存或者关闭文件之前需要等待读取结束
 }
 
 // 打开新的数据文件
@@ -32,6 +45,18 @@ func OpenDataFile(dirPath string, fileId uint32) (*DataFile, error) {
 	return newDataFile(fileName, fileId)
 }
 
+// 打开 blob 文件，用于存储和 key 分离的大 value
+func OpenBlobFile(dirPath string, fileId uint32) (*DataFile, error) {
+	fileName := GetBlobFileName(dirPath, fileId)
+	return newDataFile(fileName, fileId)
+}
+
+// 打开 key 文件，用于低内存模式下存储已封存数据文件中有序的 key
+func OpenKeyFile(dirPath string, fileId uint32) (*DataFile, error) {
+	fileName := GetKeyFileName(dirPath, fileId)
+	return newDataFile(fileName, fileId)
+}
+
 // 打开 Hint 索引文件
 func OpenHintFile(dirPath string) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, HintFileName)
@@ -48,6 +73,14 @@ func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
 }
 
+func GetBlobFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+BlobFileNameSuffix)
+}
+
+func GetKeyFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+KeyFileNameSuffix)
+}
+
 func newDataFile(fileName string, fileId uint32) (*DataFile, error) {
 	// 初始化 IOManager 管理器接口
 	ioManager, err := fio.NewIOManager(fileName)
@@ -55,21 +88,53 @@ func newDataFile(fileName string, fileId uint32) (*DataFile, error) {
 		return nil, err
 	}
 
+	return newDataFileWithIO(ioManager, fileId)
+}
+
+// 打开已经封存的数据文件，文件以只读方式打开，并读取文件末尾的 footer
+func OpenReadOnlyDataFile(dirPath string, fileId uint32) (*DataFile, error) {
+	ioManager, err := fio.NewReadOnlyFileIOManager(GetDataFileName(dirPath, fileId))
+	if err != nil {
+		return nil, err
+	}
+	dataFile, err := newDataFileWithIO(ioManager, fileId)
+	if err != nil {
+		return nil, err
+	}
+	if err := dataFile.LoadFooter(); err != nil {
+		_ = dataFile.Close()
+		return nil, err
+	}
+	return dataFile, nil
+}
+
+func newDataFileWithIO(ioManager fio.IOManager, fileId uint32) (*DataFile, error) {
 	offset, err := ioManager.Size()
 	if err != nil {
+		_ = ioManager.Close()
 		return nil, err
 	}
 
-	return &DataFile{
+	dataFile := &DataFile{
 		FileId:    fileId,
 		WriteOff:  offset,
 		IoManager: ioManager,
-	}, nil
+		statsDone: offset == 0,
+		format:    CurrentFileFormat,
+	}
+	// 已有数据的文件根据文件头确定格式版本
+	if offset > 0 {
+		if err := dataFile.loadFileHeader(); err != nil {
+			_ = ioManager.Close()
+			return nil, err
+		}
+	}
+	return dataFile, nil
 }
 
 // 根据 offset 从数据文件中读取 LogRecord
 func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
-	fileSize, err := df.IoManager.Size()
+	fileSize, err := df.dataSize()
 	if err != nil {
 		return nil, 0, err
 	}
@@ -91,7 +156,7 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 		return nil, 0, err
 	}
 
-	header, headerSize := decodeLogRecordHeader(headerBuf)
+	header, headerSize := decodeHeader(df.format, headerBuf)
 	// header 为 nil，或 headerSize 为 0，说明读取到了文件末尾
 	// keySize 和 valueSize 均为 0，说明是文件末尾的填充字节，或是被损坏的记录
 	if header == nil || headerSize == 0 {
@@ -103,54 +168,190 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 
 	// 取出对应的 key 和 value 的长度
 	keySize, valueSize := header.keySize, header.valueSize
+	if keySize < 0 || valueSize < 0 {
+		return nil, 0, ErrInvalidCRC
+	}
 	var recordSize = headerSize + keySize + valueSize
+	// 记录超出了文件的末尾，说明记录没有写完整，或者长度被损坏
+	if keySize > fileSize || valueSize > fileSize || recordSize > fileSize-offset {
+		return nil, 0, io.EOF
+	}
 
-	logRecord := &LogRecord{Type: header.recordType}
 	// 开始读取用户实际存储的 key/value 数据
+	var kvBuf []byte
 	if keySize > 0 || valueSize > 0 {
-		kvBuf, err := df.readNBytes(keySize+valueSize, offset+headerSize)
+		kvBuf, err = df.readNBytes(keySize+valueSize, offset+headerSize)
 		if err != nil {
 			return nil, 0, err
 		}
-		//	解出 key 和 value
-		logRecord.Key = kvBuf[:keySize]
-		logRecord.Value = kvBuf[keySize:]
 	}
+	logRecord, err := df.decodeLogRecordBody(header, headerBuf[:headerSize], kvBuf)
+	if err != nil {
+		return nil, 0, err
+	}
+	return logRecord, recordSize, nil
+}
 
-	// 校验数据的有效性
-	crc := getLogRecordCRC(logRecord, headerBuf[crc32.Size:headerSize])
-	if crc != header.crc {
+// ReadBytes 从 offset 开始读取 n 个字节，用于一次读取多条相邻的记录
+func (df *DataFile) ReadBytes(offset, n int64) ([]byte, error) {
+	return df.readNBytes(n, offset)
+}
+
+// ReadAt 从 offset 开始读取数据填满 b，用于复用调用方的缓冲区
+func (df *DataFile) ReadAt(b []byte, offset int64) error {
+	_, err := df.IoManager.Read(b, offset)
+	return err
+}
+
+// DecodeLogRecord 从内存中解码一条完整的 LogRecord，buf 的起始位置需要是记录的开头
+// 返回解码之后的记录及其在文件中占用的长度
+func (df *DataFile) DecodeLogRecord(buf []byte) (*LogRecord, int64, error) {
+	header, headerSize := decodeHeader(df.format, buf)
+	if header == nil || headerSize == 0 || header.keySize < 0 || header.valueSize < 0 {
+		return nil, 0, ErrInvalidCRC
+	}
+	recordSize := headerSize + header.keySize + header.valueSize
+	if recordSize > int64(len(buf)) {
 		return nil, 0, ErrInvalidCRC
 	}
+	logRecord, err := df.decodeLogRecordBody(header, buf[:headerSize], buf[headerSize:recordSize])
+	if err != nil {
+		return nil, 0, err
+	}
 	return logRecord, recordSize, nil
 }
 
+// 根据 header 和 key/value 数据构造 LogRecord，校验 crc 之后解密、解压
+func (df *DataFile) decodeLogRecordBody(header *logRecordHeader, headerBuf, kvBuf []byte) (*LogRecord, error) {
+	logRecord := &LogRecord{
+		Type:      header.recordType,
+		Flags:     header.flags,
+		Timestamp: header.timestamp,
+		Prev:      header.prev,
+		Version:   header.version,
+	}
+	//	解出 key 和 value
+	if len(kvBuf) > 0 {
+		logRecord.Key = kvBuf[:header.keySize]
+		logRecord.Value = kvBuf[header.keySize:]
+	}
+
+	// 校验数据的有效性
+	crc := getLogRecordCRC(logRecord, headerBuf[crc32.Size:])
+	if crc != header.crc {
+		return nil, ErrInvalidCRC
+	}
+
+	// 加密的记录需要先解密，再解压
+	if logRecord.Flags&FlagEncrypted != 0 {
+		if df.cipher == nil {
+			return nil, ErrEncryptionKeyRequired
+		}
+		if err := df.cipher.decryptLogRecord(logRecord); err != nil {
+			return nil, err
+		}
+	}
+
+	// 压缩过的 value 需要先解压
+	if IsCompressed(logRecord) {
+		if err := decompressLogRecord(logRecord); err != nil {
+			return nil, err
+		}
+	}
+	return logRecord, nil
+}
+
+// SetCipher 设置文件的加解密方式，之后写入的记录都会被加密
+func (df *DataFile) SetCipher(c *Cipher) {
+	df.cipher = c
+}
+
+// Cipher 返回文件使用的加解密方式，文件未加密时返回 nil
+func (df *DataFile) Cipher() *Cipher {
+	return df.cipher
+}
+
+// EncodeLogRecord 对 LogRecord 进行编码，如果文件设置了加密，则先对记录进行加密
+func (df *DataFile) EncodeLogRecord(logRecord *LogRecord) ([]byte, int64, error) {
+	if df.cipher != nil && logRecord.Type != LogRecordFileHeader {
+		encrypted, err := df.cipher.encryptLogRecord(logRecord)
+		if err != nil {
+			return nil, 0, err
+		}
+		logRecord = encrypted
+	}
+	encRecord, size := EncodeLogRecord(logRecord)
+	return encRecord, size, nil
+}
+
 func (df *DataFile) Write(buf []byte) error {
 	n, err := df.IoManager.Write(buf)
 	if err != nil {
 		return err
 	}
 	df.WriteOff += int64(n)
+	// 每次写入一条完整的记录
+	df.records++
+	df.checksum = crc32.Update(df.checksum, crc32.IEEETable, buf[:n])
 	return nil
 }
 
-// 写入索引信息到 hint 文件中
-func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
+// 写入索引信息到 hint 文件中，typ 标识该索引对应的记录类型
+func (df *DataFile) WriteHintRecord(key []byte, typ LogRecordType, pos *LogRecordPos) error {
 	record := &LogRecord{
 		Key:   key,
 		Value: EncodeLogRecordPos(pos),
+		Type:  typ,
+	}
+	encRecord, _, err := df.EncodeLogRecord(record)
+	if err != nil {
+		return err
+	}
+	return df.Write(encRecord)
+}
+
+// 写入引用了去重 value 的 key 的索引信息，value 的哈希追加在位置信息之后
+func (df *DataFile) WriteDedupRefHintRecord(key []byte, pos *LogRecordPos, hash []byte) error {
+	record := &LogRecord{
+		Key:   key,
+		Value: append(EncodeLogRecordPos(pos), hash...),
+		Type:  LogRecordNormal,
+		Flags: FlagDedupRef,
+	}
+	encRecord, _, err := df.EncodeLogRecord(record)
+	if err != nil {
+		return err
 	}
-	encRecord, _ := EncodeLogRecord(record)
 	return df.Write(encRecord)
 }
 
 func (df *DataFile) Sync() error {
 	return df.IoManager.Sync()
 }
+
+// Close 关闭文件，等待正在进行的读取结束之后再关闭
 func (df *DataFile) Close() error {
+	df.WaitUnpinned()
 	return df.IoManager.Close()
 }
 
+// Pin 固定文件，之后可以在不持有数据库锁的情况下读取，读取结束之后需要调用 Unpin
+// 调用时必须持有数据库的锁，保证不会和 WaitUnpinned 同时进行
+func (df *DataFile) Pin() {
+	df.pins.Add(1)
+}
+
+// Unpin 释放 Pin 固定的文件
+func (df *DataFile) Unpin() {
+	df.pins.Done()
+}
+
+// WaitUnpinned 等待所有固定了文件的读取结束，在封存、重新打开或者关闭文件之前调用
+// 调用时必须持有数据库的写锁，保证等待期间不会有新的读取固定文件
+func (df *DataFile) WaitUnpinned() {
+	df.pins.Wait()
+}
+
 // 从数据文件中读取 n 个字节的数据
 func (df *DataFile) readNBytes(n int64, offset int64) (b []byte, err error) {
 	b = make([]byte, n)
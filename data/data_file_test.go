@@ -3,6 +3,7 @@ package data
 import (
 	"io"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -103,9 +104,10 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 
 	// 3. 从新的偏移量开始，写入第二条记录
 	rec2 := &LogRecord{
-		Key:   []byte("name"),
-		Value: []byte("new-value"),
-		Type:  LogRecordNormal,
+		Key:       []byte("name"),
+		Value:     []byte("new-value"),
+		Type:      LogRecordNormal,
+		Timestamp: 1700000000123456789,
 	}
 	encRec2, size2 := EncodeLogRecord(rec2)
 	err = dataFile.Write(encRec2)
@@ -132,3 +134,52 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	assert.Equal(t, rec3.Key, readRec3.Key)
 	assert.Empty(t, readRec3.Value)
 }
+
+func TestDataFile_DecodeLogRecord(t *testing.T) {
+	dataFile, err := OpenDataFile(t.TempDir(), 1)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	rec1 := &LogRecord{Key: []byte("k1"), Value: []byte("v1"), Type: LogRecordNormal}
+	rec2 := &LogRecord{Key: []byte("k2"), Value: []byte("value-2"), Type: LogRecordNormal}
+	encRec1, size1 := EncodeLogRecord(rec1)
+	encRec2, size2 := EncodeLogRecord(rec2)
+	assert.Nil(t, dataFile.Write(encRec1))
+	assert.Nil(t, dataFile.Write(encRec2))
+
+	// 一次读取两条记录，再从内存中逐条解码
+	buf, err := dataFile.ReadBytes(0, size1+size2)
+	assert.Nil(t, err)
+	readRec1, readSize1, err := dataFile.DecodeLogRecord(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, rec1, readRec1)
+	assert.Equal(t, size1, readSize1)
+	readRec2, readSize2, err := dataFile.DecodeLogRecord(buf[size1:])
+	assert.Nil(t, err)
+	assert.Equal(t, rec2, readRec2)
+	assert.Equal(t, size2, readSize2)
+
+	// 不完整或者被篡改的记录
+	_, _, err = dataFile.DecodeLogRecord(buf[:size1-1])
+	assert.Equal(t, ErrInvalidCRC, err)
+	buf[size1-1] ^= 0xff
+	_, _, err = dataFile.DecodeLogRecord(buf)
+	assert.Equal(t, ErrInvalidCRC, err)
+}
+
+func TestDataFile_Pin(t *testing.T) {
+	dataFile, err := OpenDataFile(t.TempDir(), 1)
+	assert.Nil(t, err)
+
+	// 固定的文件在释放之前不会被关闭
+	dataFile.Pin()
+	done := make(chan error, 1)
+	go func() { done <- dataFile.Close() }()
+	select {
+	case <-done:
+		t.Fatal("close should wait for unpin")
+	case <-time.After(50 * time.Millisecond):
+	}
+	dataFile.Unpin()
+	assert.Nil(t, <-done)
+}
@@ -0,0 +1,49 @@
+package data
+
+import (
+	"crypto/sha256"
+	"errors"
+)
+
+var (
+	ErrInvalidDedupRef = errors.New("invalid deduplicated value reference, log record maybe corrupted")
+)
+
+// DedupHashSize 去重使用的 value 哈希长度
+const DedupHashSize = sha256.Size
+
+// DedupHash 计算 value 的哈希，作为去重之后共享 value 的 key
+func DedupHash(value []byte) []byte {
+	sum := sha256.Sum256(value)
+	return sum[:]
+}
+
+// DecodeHintRecordPos 解码 hint 记录中的位置信息，引用去重 value 的记录需要先去掉末尾的哈希
+func DecodeHintRecordPos(hintRecord *LogRecord) *LogRecordPos {
+	if hash := DedupRefHash(hintRecord); hash != nil {
+		return DecodeLogRecordPos(hintRecord.Value[:len(hintRecord.Value)-DedupHashSize])
+	}
+	return DecodeLogRecordPos(hintRecord.Value)
+}
+
+// DedupRefHash 获取 hint 记录中引用的去重 value 的哈希，没有引用时返回 nil
+func DedupRefHash(hintRecord *LogRecord) []byte {
+	if hintRecord.Flags&FlagDedupRef == 0 || len(hintRecord.Value) < DedupHashSize {
+		return nil
+	}
+	return hintRecord.Value[len(hintRecord.Value)-DedupHashSize:]
+}
+
+// EncodeDedupRef 编码引用去重 value 的记录中的 value：value 的哈希以及共享 value 记录的位置
+// 读取时直接根据位置读取共享的 value，不依赖可能已经被并发的写入清除的引用计数
+func EncodeDedupRef(hash []byte, pos *LogRecordPos) []byte {
+	return append(append([]byte{}, hash...), EncodeLogRecordPos(pos)...)
+}
+
+// DecodeDedupRef 解码引用去重 value 的记录中的 value，返回 value 的哈希以及共享 value 记录的位置
+func DecodeDedupRef(value []byte) ([]byte, *LogRecordPos, error) {
+	if len(value) <= DedupHashSize {
+		return nil, nil, ErrInvalidDedupRef
+	}
+	return value[:DedupHashSize], DecodeLogRecordPos(value[DedupHashSize:]), nil
+}
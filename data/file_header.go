@@ -0,0 +1,174 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+var (
+	ErrInvalidFileHeader     = errors.New("invalid file header, the file maybe corrupted")
+	ErrUnsupportedFileFormat = errors.New("unsupported file format, the file maybe written by a newer version")
+)
+
+// 文件的格式版本，记录在文件头中
+const (
+	// FileFormatLegacy 最初的格式，文件没有文件头，记录的 header 为 | crc | type | keySize | valueSize |
+	FileFormatLegacy uint64 = iota
+
+	// FileFormatV1 文件以文件头开始，记录的 header 中增加了标志位、写入时间、上一个版本的位置和版本号
+	FileFormatV1
+)
+
+// CurrentFileFormat 新文件使用的格式版本
+const CurrentFileFormat = FileFormatV1
+
+// TimestampUnknown 写入时间未知，最初格式的记录中没有写入时间，读取时使用这个值
+// merge 和 blob 回收重写这些记录时保留这个值，不会被当作新写入的记录填充当前时间；它小于所有已知的写入时间
+const TimestampUnknown int64 = math.MinInt64
+
+// 最初格式的 header 的最大长度
+// crc type keySize valueSize
+// 4 +  1  +  5   +   5 = 15
+const legacyLogRecordHeaderSize = crc32.Size + 1 + binary.MaxVarintLen32*2
+
+// FileHeader 文件头，是每个文件的第一条记录，类型为 LogRecordFileHeader
+type FileHeader struct {
+	Format   uint64 // 文件的格式版本
+	KeyId    uint32 // 加密使用的密钥 id
+	keyCheck []byte // 用于校验密钥的密文，为空表示文件没有加密
+}
+
+// Encrypted 文件是否加密
+func (h *FileHeader) Encrypted() bool {
+	return len(h.keyCheck) > 0
+}
+
+// EncodeFileHeader 编码文件头，包含格式版本，文件加密时还包含密钥 id 和用于校验密钥的密文，c 为 nil 表示文件不加密
+// | format | keyId | keyCheck |
+func EncodeFileHeader(c *Cipher) ([]byte, error) {
+	var keyId uint32
+	var check []byte
+	if c != nil {
+		var err error
+		if check, err = c.seal(keyCheckPlaintext, nil); err != nil {
+			return nil, err
+		}
+		keyId = c.KeyId
+	}
+	buf := make([]byte, binary.MaxVarintLen64+binary.MaxVarintLen32+len(check))
+	index := binary.PutUvarint(buf, CurrentFileFormat)
+	index += binary.PutUvarint(buf[index:], uint64(keyId))
+	index += copy(buf[index:], check)
+	return buf[:index], nil
+}
+
+// DecodeFileHeader 解码文件头
+func DecodeFileHeader(buf []byte) (*FileHeader, error) {
+	format, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, ErrInvalidFileHeader
+	}
+	keyId, m := binary.Uvarint(buf[n:])
+	if m <= 0 {
+		return nil, ErrInvalidFileHeader
+	}
+	return &FileHeader{Format: format, KeyId: uint32(keyId), keyCheck: buf[n+m:]}, nil
+}
+
+// WriteFileHeader 在新文件的开头写入文件头，c 不为 nil 时之后写入的记录都会被加密
+func (df *DataFile) WriteFileHeader(c *Cipher) error {
+	value, err := EncodeFileHeader(c)
+	if err != nil {
+		return err
+	}
+	encHeader, _ := EncodeLogRecord(&LogRecord{Type: LogRecordFileHeader, Value: value})
+	if err := df.Write(encHeader); err != nil {
+		return err
+	}
+	df.header, _ = DecodeFileHeader(value)
+	df.format = CurrentFileFormat
+	df.cipher = c
+	return nil
+}
+
+// FileHeader 返回文件头，文件没有文件头时返回 nil
+func (df *DataFile) FileHeader() *FileHeader {
+	return df.header
+}
+
+// Format 返回文件的格式版本
+func (df *DataFile) Format() uint64 {
+	return df.format
+}
+
+// 打开已有数据的文件时读取文件头，确定文件的格式版本
+// 第一条记录不是文件头的文件是以最初的格式写入的，最初格式的记录类型不会和文件头的类型相同
+func (df *DataFile) loadFileHeader() error {
+	typeBuf := make([]byte, crc32.Size+1)
+	if _, err := df.IoManager.Read(typeBuf, 0); err != nil {
+		if err == io.EOF {
+			df.format = FileFormatLegacy
+			return nil
+		}
+		return err
+	}
+	if typeBuf[crc32.Size] != LogRecordFileHeader {
+		df.format = FileFormatLegacy
+		return nil
+	}
+
+	record, _, err := df.ReadLogRecord(0)
+	if err != nil {
+		// 文件头没有写完整，文件中没有有效的记录
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+	header, err := DecodeFileHeader(record.Value)
+	if err != nil {
+		return err
+	}
+	if header.Format > CurrentFileFormat {
+		return fmt.Errorf("%w: format version %d", ErrUnsupportedFileFormat, header.Format)
+	}
+	df.format = header.Format
+	df.header = header
+	return nil
+}
+
+// 根据文件的格式版本解码 header
+func decodeHeader(format uint64, buf []byte) (*logRecordHeader, int64) {
+	if format == FileFormatLegacy {
+		return decodeLegacyLogRecordHeader(buf)
+	}
+	return decodeLogRecordHeader(buf)
+}
+
+// 解码最初格式的 header，没有标志位、写入时间、上一个版本的位置和版本号，写入时间为 TimestampUnknown
+func decodeLegacyLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
+	if len(buf) <= crc32.Size {
+		return nil, 0
+	}
+
+	header := &logRecordHeader{
+		crc:        binary.LittleEndian.Uint32(buf[:crc32.Size]),
+		recordType: buf[crc32.Size],
+		timestamp:  TimestampUnknown,
+	}
+
+	var index = crc32.Size + 1
+	keySize, n := binary.Varint(buf[index:])
+	header.keySize = keySize
+	index += n
+
+	valueSize, n := binary.Varint(buf[index:])
+	header.valueSize = valueSize
+	index += n
+
+	return header, int64(index)
+}
@@ -0,0 +1,113 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 按照最初的格式编码记录，header 中只有 crc、type、keySize 和 valueSize
+func encodeLegacyLogRecord(logRecord *LogRecord) []byte {
+	buf := make([]byte, legacyLogRecordHeaderSize+len(logRecord.Key)+len(logRecord.Value))
+	buf[crc32.Size] = logRecord.Type
+	index := crc32.Size + 1
+	index += binary.PutVarint(buf[index:], int64(len(logRecord.Key)))
+	index += binary.PutVarint(buf[index:], int64(len(logRecord.Value)))
+	index += copy(buf[index:], logRecord.Key)
+	index += copy(buf[index:], logRecord.Value)
+	binary.LittleEndian.PutUint32(buf, crc32.ChecksumIEEE(buf[crc32.Size:index]))
+	return buf[:index]
+}
+
+func TestFileHeader_Format(t *testing.T) {
+	dirPath := t.TempDir()
+	dataFile, err := OpenDataFile(dirPath, 1)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.WriteFileHeader(nil))
+	writeTestRecords(t, dataFile, 3)
+	assert.Nil(t, dataFile.Close())
+
+	dataFile, err = OpenDataFile(dirPath, 1)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+	assert.Equal(t, CurrentFileFormat, dataFile.Format())
+	assert.NotNil(t, dataFile.FileHeader())
+	assert.False(t, dataFile.FileHeader().Encrypted())
+	assert.Nil(t, dataFile.Cipher())
+}
+
+func TestFileHeader_UnsupportedFormat(t *testing.T) {
+	dirPath := t.TempDir()
+	dataFile, err := OpenDataFile(dirPath, 1)
+	assert.Nil(t, err)
+	value := binary.AppendUvarint(nil, CurrentFileFormat+1)
+	value = binary.AppendUvarint(value, 0)
+	enc, _ := EncodeLogRecord(&LogRecord{Type: LogRecordFileHeader, Value: value})
+	assert.Nil(t, dataFile.Write(enc))
+	assert.Nil(t, dataFile.Close())
+
+	_, err = OpenDataFile(dirPath, 1)
+	assert.ErrorIs(t, err, ErrUnsupportedFileFormat)
+}
+
+func TestDataFile_ReadLegacyFile(t *testing.T) {
+	dirPath := t.TempDir()
+	records := []*LogRecord{
+		{Key: []byte("name"), Value: []byte("bitcask-go"), Type: LogRecordNormal},
+		{Key: []byte("name"), Type: LogRecordDeleted},
+		{Key: []byte("txn-fin"), Type: LogRecordTxnFinished},
+		{Key: []byte("big"), Value: bytes.Repeat([]byte("v"), 300), Type: LogRecordNormal},
+	}
+	var buf []byte
+	for _, rec := range records {
+		buf = append(buf, encodeLegacyLogRecord(rec)...)
+	}
+	fileName := GetDataFileName(dirPath, 1)
+	assert.Nil(t, os.WriteFile(fileName, buf, 0644))
+
+	dataFile, err := OpenDataFile(dirPath, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, FileFormatLegacy, dataFile.Format())
+	assert.Nil(t, dataFile.FileHeader())
+
+	check := func(dataFile *DataFile) {
+		var offset int64
+		for _, rec := range records {
+			readRec, size, err := dataFile.ReadLogRecord(offset)
+			assert.Nil(t, err)
+			assert.Equal(t, rec.Type, readRec.Type)
+			assert.Equal(t, rec.Key, readRec.Key)
+			assert.Equal(t, len(rec.Value), len(readRec.Value))
+			assert.Equal(t, TimestampUnknown, readRec.Timestamp)
+			assert.Equal(t, uint64(0), readRec.Version)
+
+			decoded, decodedSize, err := dataFile.DecodeLogRecord(buf[offset:])
+			assert.Nil(t, err)
+			assert.Equal(t, size, decodedSize)
+			assert.Equal(t, readRec.Key, decoded.Key)
+			offset += size
+		}
+		_, _, err := dataFile.ReadLogRecord(offset)
+		assert.Equal(t, io.EOF, err)
+	}
+	check(dataFile)
+
+	// 封存之后仍然按照最初的格式读取
+	assert.Nil(t, dataFile.WriteFooter())
+	assert.Nil(t, dataFile.Close())
+	dataFile, err = OpenReadOnlyDataFile(dirPath, 1)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+	assert.True(t, dataFile.Sealed())
+	assert.Equal(t, FileFormatLegacy, dataFile.Format())
+	check(dataFile)
+
+	footer, err := VerifyDataFile(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(records)), footer.Records)
+}
@@ -0,0 +1,257 @@
+package data
+
+import (
+	"bitcask-kv-go/fio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+var (
+	ErrInvalidFooter  = errors.New("invalid data file footer, the file maybe corrupted")
+	ErrFooterMismatch = errors.New("the data file does not match its footer, the file maybe corrupted")
+)
+
+// 数据文件封存之后在末尾追加的 footer，长度固定
+// | magic (4字节) | 记录数量 (8字节) | 数据长度 (8字节) | 校验值 (4字节) | footer 的 crc (4字节) |
+const FileFooterSize = 28
+
+const fileFooterMagic uint32 = 0xb17ca5f0
+
+// 每次计算校验值时读取的数据长度
+const checksumReadSize = 64 * 1024
+
+// FileFooter 封存的数据文件的 footer
+type FileFooter struct {
+	Records  int64  // 文件中的记录数量，包括文件头
+	Length   int64  // footer 之前的数据长度
+	Checksum uint32 // footer 之前所有数据的 crc32 校验值
+}
+
+func encodeFileFooter(footer *FileFooter) []byte {
+	buf := make([]byte, FileFooterSize)
+	binary.LittleEndian.PutUint32(buf[0:], fileFooterMagic)
+	binary.LittleEndian.PutUint64(buf[4:], uint64(footer.Records))
+	binary.LittleEndian.PutUint64(buf[12:], uint64(footer.Length))
+	binary.LittleEndian.PutUint32(buf[20:], footer.Checksum)
+	binary.LittleEndian.PutUint32(buf[24:], crc32.ChecksumIEEE(buf[:24]))
+	return buf
+}
+
+// 读取并校验文件末尾的 footer，magic、crc 和长度都匹配时才认为文件已经封存，否则返回 nil
+// 末尾的数据带有 magic 但是没有通过校验时 damaged 为 true，可能是被损坏的 footer，也可能只是最后一条记录的数据恰好以 magic 结尾
+func readFileFooter(ioManager fio.IOManager) (footer *FileFooter, damaged bool, err error) {
+	size, err := ioManager.Size()
+	if err != nil {
+		return nil, false, err
+	}
+	if size < FileFooterSize {
+		return nil, false, nil
+	}
+	buf := make([]byte, FileFooterSize)
+	if _, err := ioManager.Read(buf, size-FileFooterSize); err != nil {
+		return nil, false, err
+	}
+	if binary.LittleEndian.Uint32(buf[0:]) != fileFooterMagic {
+		return nil, false, nil
+	}
+	footer = &FileFooter{
+		Records:  int64(binary.LittleEndian.Uint64(buf[4:])),
+		Length:   int64(binary.LittleEndian.Uint64(buf[12:])),
+		Checksum: binary.LittleEndian.Uint32(buf[20:]),
+	}
+	if binary.LittleEndian.Uint32(buf[24:]) != crc32.ChecksumIEEE(buf[:24]) || footer.Length != size-FileFooterSize {
+		return nil, true, nil
+	}
+	return footer, false, nil
+}
+
+// LoadFooter 读取并校验文件末尾的 footer，只读取 footer 本身，不会校验整个文件
+// footer 没有通过校验时扫描整个文件：记录恰好结束在 footer 之前，说明只有 footer 被损坏，按照扫描的结果重新生成；否则认为文件没有封存
+func (df *DataFile) LoadFooter() error {
+	footer, damaged, err := readFileFooter(df.IoManager)
+	if err != nil {
+		return err
+	}
+	if damaged {
+		if footer, err = recoverFileFooter(df.IoManager, df.format); err != nil {
+			return err
+		}
+	}
+	df.footer = footer
+	return nil
+}
+
+// 扫描整个文件重新生成 footer，记录没有恰好结束在 footer 之前时返回 nil
+func recoverFileFooter(ioManager fio.IOManager, format uint64) (*FileFooter, error) {
+	size, err := ioManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	length := size - FileFooterSize
+	records, end, _ := scanRecords(ioManager, format, size, true)
+	if end != length {
+		return nil, nil
+	}
+	checksum, err := fileChecksum(ioManager, length)
+	if err != nil {
+		return nil, err
+	}
+	return &FileFooter{Records: records, Length: length, Checksum: checksum}, nil
+}
+
+// Sealed 文件是否已经封存
+func (df *DataFile) Sealed() bool {
+	return df.footer != nil
+}
+
+// 文件中数据部分的长度，封存的文件不包括末尾的 footer
+func (df *DataFile) dataSize() (int64, error) {
+	if df.footer != nil {
+		return df.footer.Length, nil
+	}
+	return df.IoManager.Size()
+}
+
+// WriteFooter 封存文件，在文件末尾写入 footer 并持久化，之后不能再写入记录
+func (df *DataFile) WriteFooter() error {
+	if df.footer != nil {
+		return nil
+	}
+	// 打开时已有数据的文件需要重新统计
+	if !df.statsDone {
+		records, checksum, err := scanDataFile(df.IoManager, df.format, df.WriteOff, false)
+		if err != nil {
+			return err
+		}
+		df.records, df.checksum, df.statsDone = records, checksum, true
+	}
+
+	footer := &FileFooter{Records: df.records, Length: df.WriteOff, Checksum: df.checksum}
+	n, err := df.IoManager.Write(encodeFileFooter(footer))
+	if err != nil {
+		return err
+	}
+	df.WriteOff += int64(n)
+	if err := df.IoManager.Sync(); err != nil {
+		return err
+	}
+	df.footer = footer
+	return nil
+}
+
+// 统计文件中前 length 字节的记录数量和校验值，checkCRC 为 true 时校验每条记录的 crc
+// 记录不需要解密和解压，没有密钥也可以校验
+func scanDataFile(ioManager fio.IOManager, format uint64, length int64, checkCRC bool) (int64, uint32, error) {
+	records, _, err := scanRecords(ioManager, format, length, checkCRC)
+	if err != nil {
+		return 0, 0, err
+	}
+	checksum, err := fileChecksum(ioManager, length)
+	if err != nil {
+		return 0, 0, err
+	}
+	return records, checksum, nil
+}
+
+// 逐条解析文件中前 length 字节的记录，format 为文件的格式版本，返回完整的记录数量以及最后一条完整记录的结束位置
+func scanRecords(ioManager fio.IOManager, format uint64, length int64, checkCRC bool) (int64, int64, error) {
+	var records int64
+	var offset int64
+	for offset < length {
+		headerBytes := min(int64(maxLogRecordHeaderSize), length-offset)
+		headerBuf := make([]byte, headerBytes)
+		if _, err := ioManager.Read(headerBuf, offset); err != nil {
+			return records, offset, err
+		}
+		header, headerSize := decodeHeader(format, headerBuf)
+		// 和 ReadLogRecord 一致，全为 0 的 header 表示文件末尾的填充字节
+		if header == nil || headerSize == 0 ||
+			(header.keySize == 0 && header.valueSize == 0 && header.recordType != LogRecordDeleted) {
+			break
+		}
+		recordSize := headerSize + header.keySize + header.valueSize
+		if header.keySize < 0 || header.valueSize < 0 || offset+recordSize > length {
+			return records, offset, fmt.Errorf("%w: truncated record at offset %d", ErrInvalidCRC, offset)
+		}
+		if checkCRC {
+			kvBuf := make([]byte, header.keySize+header.valueSize)
+			if _, err := ioManager.Read(kvBuf, offset+headerSize); err != nil {
+				return records, offset, err
+			}
+			crc := crc32.Update(crc32.ChecksumIEEE(headerBuf[crc32.Size:headerSize]), crc32.IEEETable, kvBuf)
+			if crc != header.crc {
+				return records, offset, fmt.Errorf("%w: at offset %d", ErrInvalidCRC, offset)
+			}
+		}
+		records++
+		offset += recordSize
+	}
+	return records, offset, nil
+}
+
+// 计算文件中前 length 字节的 crc32 校验值
+func fileChecksum(ioManager fio.IOManager, length int64) (uint32, error) {
+	var checksum uint32
+	buf := make([]byte, checksumReadSize)
+	for offset := int64(0); offset < length; {
+		n := min(int64(len(buf)), length-offset)
+		if _, err := ioManager.Read(buf[:n], offset); err != nil && err != io.EOF {
+			return 0, err
+		}
+		checksum = crc32.Update(checksum, crc32.IEEETable, buf[:n])
+		offset += n
+	}
+	return checksum, nil
+}
+
+// VerifyDataFile 完整地校验一个数据文件：逐条校验记录的 crc，并与 footer 中记录的数量、长度和整个文件的校验值进行比较
+// 返回文件的 footer，文件没有封存（例如活跃文件）时只校验记录，返回的 footer 为 nil
+// 和打开数据库时不同，footer 被损坏时返回 ErrInvalidFooter
+func VerifyDataFile(fileName string) (*FileFooter, error) {
+	ioManager, err := fio.NewReadOnlyFileIOManager(fileName)
+	if err != nil {
+		return nil, err
+	}
+	// 根据文件头确定文件的格式版本
+	dataFile, err := newDataFileWithIO(ioManager, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer dataFile.Close()
+
+	footer, damaged, err := readFileFooter(ioManager)
+	if err != nil {
+		return nil, err
+	}
+	length, err := ioManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	if footer != nil {
+		length = footer.Length
+	}
+
+	records, end, err := scanRecords(ioManager, dataFile.format, length, true)
+	// 记录恰好结束在末尾的 footer 之前，说明末尾是被损坏的 footer
+	if damaged && end == length-FileFooterSize {
+		return nil, ErrInvalidFooter
+	}
+	if err != nil {
+		return nil, err
+	}
+	if footer == nil {
+		return nil, nil
+	}
+	checksum, err := fileChecksum(ioManager, length)
+	if err != nil {
+		return nil, err
+	}
+	if records != footer.Records || checksum != footer.Checksum {
+		return nil, fmt.Errorf("%w: %d records with checksum %08x, footer has %d records with checksum %08x",
+			ErrFooterMismatch, records, checksum, footer.Records, footer.Checksum)
+	}
+	return footer, nil
+}
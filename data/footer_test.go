@@ -0,0 +1,176 @@
+package data
+
+import (
+	"bitcask-kv-go/fio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestRecords(t *testing.T, dataFile *DataFile, n int) []int64 {
+	t.Helper()
+	// 新文件和数据库中一样先写入文件头
+	if dataFile.WriteOff == 0 {
+		assert.Nil(t, dataFile.WriteFileHeader(nil))
+	}
+	offsets := make([]int64, 0, n)
+	for i := 0; i < n; i++ {
+		offsets = append(offsets, dataFile.WriteOff)
+		enc, _ := EncodeLogRecord(&LogRecord{Key: []byte{'k', byte(i)}, Value: []byte("value"), Type: LogRecordNormal})
+		assert.Nil(t, dataFile.Write(enc))
+	}
+	return offsets
+}
+
+func TestDataFile_WriteFooter(t *testing.T) {
+	dirPath := t.TempDir()
+	dataFile, err := OpenDataFile(dirPath, 1)
+	assert.Nil(t, err)
+	offsets := writeTestRecords(t, dataFile, 10)
+	length := dataFile.WriteOff
+
+	assert.False(t, dataFile.Sealed())
+	assert.Nil(t, dataFile.WriteFooter())
+	assert.True(t, dataFile.Sealed())
+	assert.Equal(t, length+FileFooterSize, dataFile.WriteOff)
+	assert.Nil(t, dataFile.Close())
+
+	// 只读打开，footer 不会被当作记录读取
+	dataFile, err = OpenReadOnlyDataFile(dirPath, 1)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+	assert.True(t, dataFile.Sealed())
+	for _, offset := range offsets {
+		_, _, err := dataFile.ReadLogRecord(offset)
+		assert.Nil(t, err)
+	}
+	_, _, err = dataFile.ReadLogRecord(length)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, fio.ErrReadOnlyFile, dataFile.Write([]byte("x")))
+
+	footer, err := VerifyDataFile(GetDataFileName(dirPath, 1))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(11), footer.Records)
+	assert.Equal(t, length, footer.Length)
+}
+
+func TestDataFile_WriteFooterAfterReopen(t *testing.T) {
+	dirPath := t.TempDir()
+	dataFile, err := OpenDataFile(dirPath, 1)
+	assert.Nil(t, err)
+	writeTestRecords(t, dataFile, 5)
+	assert.Nil(t, dataFile.Close())
+
+	// 重新打开之后继续写入，封存时重新统计已有的数据
+	dataFile, err = OpenDataFile(dirPath, 1)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.LoadFooter())
+	assert.False(t, dataFile.Sealed())
+	writeTestRecords(t, dataFile, 3)
+	assert.Nil(t, dataFile.WriteFooter())
+	assert.Nil(t, dataFile.Close())
+
+	footer, err := VerifyDataFile(GetDataFileName(dirPath, 1))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(9), footer.Records)
+
+	// 没有封存的文件只校验记录
+	dataFile, err = OpenDataFile(dirPath, 2)
+	assert.Nil(t, err)
+	writeTestRecords(t, dataFile, 3)
+	assert.Nil(t, dataFile.Close())
+	footer, err = VerifyDataFile(GetDataFileName(dirPath, 2))
+	assert.Nil(t, err)
+	assert.Nil(t, footer)
+}
+
+func TestVerifyDataFile_Corrupted(t *testing.T) {
+	dirPath := t.TempDir()
+	dataFile, err := OpenDataFile(dirPath, 1)
+	assert.Nil(t, err)
+	writeTestRecords(t, dataFile, 10)
+	assert.Nil(t, dataFile.WriteFooter())
+	size := dataFile.WriteOff
+	assert.Nil(t, dataFile.Close())
+	fileName := GetDataFileName(dirPath, 1)
+
+	flipByte := func(offset int64) {
+		fd, err := os.OpenFile(fileName, os.O_RDWR, 0)
+		assert.Nil(t, err)
+		defer fd.Close()
+		b := make([]byte, 1)
+		_, err = fd.ReadAt(b, offset)
+		assert.Nil(t, err)
+		b[0] ^= 0xff
+		_, err = fd.WriteAt(b, offset)
+		assert.Nil(t, err)
+	}
+
+	// 记录中的数据被篡改
+	flipByte(size - FileFooterSize - 1)
+	_, err = VerifyDataFile(fileName)
+	assert.True(t, errors.Is(err, ErrInvalidCRC))
+	flipByte(size - FileFooterSize - 1)
+	_, err = VerifyDataFile(fileName)
+	assert.Nil(t, err)
+
+	// footer 中的校验值被篡改，打开文件时扫描整个文件重新生成 footer，只有校验工具返回错误
+	flipByte(size - 8)
+	dataFile, err = OpenReadOnlyDataFile(dirPath, 1)
+	assert.Nil(t, err)
+	assert.True(t, dataFile.Sealed())
+	assert.Equal(t, size-FileFooterSize, dataFile.footer.Length)
+	assert.Equal(t, int64(11), dataFile.footer.Records)
+	_, _, err = dataFile.ReadLogRecord(size - FileFooterSize)
+	assert.Equal(t, io.EOF, err)
+	assert.Nil(t, dataFile.Close())
+	_, err = VerifyDataFile(fileName)
+	assert.Equal(t, ErrInvalidFooter, err)
+}
+
+func TestDataFile_LoadFooterMagicInValue(t *testing.T) {
+	dirPath := t.TempDir()
+	dataFile, err := OpenDataFile(dirPath, 1)
+	assert.Nil(t, err)
+	writeTestRecords(t, dataFile, 3)
+
+	// 最后一条记录的 value 恰好以 footer 的 magic 结尾
+	value := make([]byte, FileFooterSize)
+	binary.LittleEndian.PutUint32(value, fileFooterMagic)
+	enc, _ := EncodeLogRecord(&LogRecord{Key: []byte("magic"), Value: value, Type: LogRecordNormal})
+	assert.Nil(t, dataFile.Write(enc))
+	size := dataFile.WriteOff
+	assert.Nil(t, dataFile.Close())
+
+	// 文件没有封存，所有的记录都可以读取
+	dataFile, err = OpenDataFile(dirPath, 1)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+	assert.Nil(t, dataFile.LoadFooter())
+	assert.False(t, dataFile.Sealed())
+	rec, _, err := dataFile.ReadLogRecord(size - int64(len(enc)))
+	assert.Nil(t, err)
+	assert.Equal(t, value, rec.Value)
+
+	footer, err := VerifyDataFile(GetDataFileName(dirPath, 1))
+	assert.Nil(t, err)
+	assert.Nil(t, footer)
+}
+
+func TestVerifyDataFile_Mismatch(t *testing.T) {
+	dirPath := t.TempDir()
+	dataFile, err := OpenDataFile(dirPath, 1)
+	assert.Nil(t, err)
+	writeTestRecords(t, dataFile, 4)
+	// 写入错误的统计信息，模拟 footer 和数据不一致
+	dataFile.records++
+	assert.Nil(t, dataFile.WriteFooter())
+	assert.Nil(t, dataFile.Close())
+
+	_, err = VerifyDataFile(GetDataFileName(dirPath, 1))
+	assert.True(t, errors.Is(err, ErrFooterMismatch))
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"encoding/binary"
 	"hash/crc32"
+	"math"
 )
 
 type LogRecordType = byte
@@ -11,33 +12,72 @@ const (
 	LogRecordNormal LogRecordType = iota
 	LogRecordDeleted
 	LogRecordTxnFinished
+	// 文件头，记录加密文件使用的密钥 id
+	LogRecordFileHeader
+	// 去重之后共享的 value，key 为 value 的哈希
+	LogRecordDedupValue
+	// 范围删除标记，key 为范围的起点，value 为范围的终点（不包含），value 为空时表示没有终点
+	LogRecordRangeDeleted
+	// 流式写入的 value 的一个分块，不进入索引，由带有 FlagStreamRef 的记录引用
+	LogRecordValueChunk
 )
 
-// crc type keySize valueSize
-// 4 +  1  +  5   +   5 = 15
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5
+// LogRecord 标志位，低 16 位由引擎使用
+const (
+	// 记录中带有同一个 key 上一个版本的位置信息
+	FlagHasPrev uint32 = 1 << iota
+	// value 使用 flate 压缩
+	FlagCompressFlate
+	// value 使用 gzip 压缩
+	FlagCompressGzip
+	// key 和 value 经过加密
+	FlagEncrypted
+	// value 存储在 blob 文件中，记录中只保存 blob 的位置
+	FlagBlobRef
+	// value 经过去重，记录中只保存共享 value 的哈希
+	FlagDedupRef
+	// 记录中带有 key 的版本号
+	FlagHasVersion
+	// value 通过流式写入，按分块存储，记录中只保存所有分块的位置
+	FlagStreamRef
+)
+
+// crc type flags timestamp keySize valueSize prevFid prevOffset version
+// 4 +  1  +  5  +   10    +  5    +   10   +   5   +   10     +  10 = 60
+// valueSize 按照 64 位预留空间，value 的长度可以超过 4GB
+const maxLogRecordHeaderSize = crc32.Size + 1 + binary.MaxVarintLen32*3 + binary.MaxVarintLen64*4
 
 // LogRecord 写入到数据文件的记录
 // 数据文件中的数据是追加写入的，类似日志的格式
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType
+	Key       []byte
+	Value     []byte
+	Type      LogRecordType
+	Flags     uint32        // 标志位，低 16 位由引擎内部使用，高 16 位预留给用户
+	Timestamp int64         // 写入时间，UnixNano，以最初的格式写入的记录为 TimestampUnknown
+	Prev      *LogRecordPos // 同一个 key 的上一个版本，仅在开启历史版本时记录
+	Version   uint64        // key 的版本号，每次写入时递增，为 0 时不记录
 }
 
 // LogRecord 的头部信息
-// | crc (4字节) | type (1字节) | keySize (变长) | valueSize (变长) | key (N字节) | value (M字节)
+// | crc (4字节) | type (1字节) | flags (变长) | timestamp (变长) | keySize (变长) | valueSize (变长) | [prev (变长)] | [version (变长)] | key (N字节) | value (M字节)
 type logRecordHeader struct {
 	crc        uint32        // crc 校验值
 	recordType LogRecordType // 标识 LogRecord 的类型
+	flags      uint32        // 标志位
+	timestamp  int64         // 写入时间
 	keySize    int64         // key 的长度
 	valueSize  int64         // value 的长度
+	prev       *LogRecordPos // 上一个版本的位置
+	version    uint64        // key 的版本号
 }
 
 // 数据内存索引，主要是描述数据在磁盘上的位置
 type LogRecordPos struct {
-	Fid    uint32 // 文件 id，表示将数据存储到了哪个文件当中
-	Offset int64  // 数据起始位置，表示数据在数据文件中的偏移量
+	Fid     uint32 // 文件 id，表示将数据存储到了哪个文件当中
+	Offset  int64  // 数据起始位置，表示数据在数据文件中的偏移量
+	Size    uint32 // 数据在数据文件中占用的大小，旧版本写入的位置信息中没有记录，为 0，超过 4GB 的记录同样为 0
+	Version uint64 // 数据对应的 key 的版本号，旧版本写入的数据为 0
 }
 
 // 暂存的事务相关的数据
@@ -47,21 +87,17 @@ type TransactionRecord struct {
 }
 
 //	 对 LogRecord 进行编码，返回字节数组及长度
-//		+-------------+-------------+-------------+--------------+-------------+--------------+
-//		| crc 校验值  |  type 类型   |    key size |   value size |      key    |      value   |
-//		+-------------+-------------+-------------+--------------+-------------+--------------+
-//		    4字节          1字节        变长（最大5）   变长（最大5）     变长           变长
+//		+-------------+-------------+-------------+--------------+-------------+--------------+-------------+--------------+
+//		| crc 校验值  |  type 类型   |    flags    |   timestamp  |    key size |   value size |      key    |      value   |
+//		+-------------+-------------+-------------+--------------+-------------+--------------+-------------+--------------+
+//		    4字节          1字节       变长（最大5）  变长（最大10）  变长（最大5）   变长（最大10）    变长           变长
+//
+// 如果记录带有上一个版本的位置，则在 value size 之后追加 prev 的 fid 和 offset，并设置 FlagHasPrev 标志位
+// 如果记录带有版本号，则继续追加 version，并设置 FlagHasVersion 标志位
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	// 初始化一个 header 部分的字节数组
 	header := make([]byte, maxLogRecordHeaderSize)
-
-	// 第五个字节存储 Type
-	header[crc32.Size] = logRecord.Type
-	var index = crc32.Size + 1 // 4 + 1 = 5
-	// 5 字节之后，存储的是 key 和 value 的长度信息
-	// 使用变长类型，节省空间
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
+	var index = crc32.Size + encodeLogRecordHeader(header[crc32.Size:], logRecord, len(logRecord.Value))
 
 	var size = index + len(logRecord.Key) + len(logRecord.Value)
 	encBytes := make([]byte, size)
@@ -79,6 +115,35 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	return encBytes, int64(size)
 }
 
+// 编码 header 中 crc 之后的部分，返回编码之后的长度，valueSize 为写入文件时 value 的长度
+func encodeLogRecordHeader(buf []byte, logRecord *LogRecord, valueSize int) int {
+	flags := logRecord.Flags &^ (FlagHasPrev | FlagHasVersion)
+	if logRecord.Prev != nil {
+		flags |= FlagHasPrev
+	}
+	if logRecord.Version != 0 {
+		flags |= FlagHasVersion
+	}
+
+	// 第一个字节存储 Type
+	buf[0] = logRecord.Type
+	var index = 1
+	// 之后依次存储标志位、写入时间以及 key 和 value 的长度信息
+	// 使用变长类型，节省空间
+	index += binary.PutUvarint(buf[index:], uint64(flags))
+	index += binary.PutVarint(buf[index:], logRecord.Timestamp)
+	index += binary.PutVarint(buf[index:], int64(len(logRecord.Key)))
+	index += binary.PutVarint(buf[index:], int64(valueSize))
+	if logRecord.Prev != nil {
+		index += binary.PutVarint(buf[index:], int64(logRecord.Prev.Fid))
+		index += binary.PutVarint(buf[index:], logRecord.Prev.Offset)
+	}
+	if logRecord.Version != 0 {
+		index += binary.PutUvarint(buf[index:], logRecord.Version)
+	}
+	return index
+}
+
 // 对字节数组中的 Header 信息进行解码
 func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 	if len(buf) <= crc32.Size {
@@ -91,6 +156,15 @@ func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 	}
 
 	var index = crc32.Size + 1
+	// 取出标志位和写入时间
+	flags, n := binary.Uvarint(buf[index:])
+	header.flags = uint32(flags)
+	index += n
+
+	timestamp, n := binary.Varint(buf[index:])
+	header.timestamp = timestamp
+	index += n
+
 	// 取出实际的 key size
 	keySize, n := binary.Varint(buf[index:])
 	header.keySize = keySize
@@ -101,6 +175,24 @@ func decodeLogRecordHeader(buf []byte) (*logRecordHeader, int64) {
 	header.valueSize = valueSize
 	index += n
 
+	// 取出上一个版本的位置
+	if header.flags&FlagHasPrev != 0 {
+		prevFid, n := binary.Varint(buf[index:])
+		index += n
+		prevOffset, n := binary.Varint(buf[index:])
+		index += n
+		header.prev = &LogRecordPos{Fid: uint32(prevFid), Offset: prevOffset}
+		header.flags &^= FlagHasPrev
+	}
+
+	// 取出版本号
+	if header.flags&FlagHasVersion != 0 {
+		version, n := binary.Uvarint(buf[index:])
+		index += n
+		header.version = version
+		header.flags &^= FlagHasVersion
+	}
+
 	return header, int64(index)
 }
 
@@ -119,18 +211,38 @@ func getLogRecordCRC(lr *LogRecord, header []byte) uint32 {
 
 // 对位置信息进行编码
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64)
+	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64*2)
 	var index = 0
 	index += binary.PutVarint(buf[index:], int64(pos.Fid))
 	index += binary.PutVarint(buf[index:], pos.Offset)
+	index += binary.PutVarint(buf[index:], int64(pos.Size))
+	index += binary.PutUvarint(buf[index:], pos.Version)
 	return buf[:index]
 }
 
-// 解码 LogRecordPos
+// 解码 LogRecordPos，兼容没有记录大小和版本号的旧格式
 func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	var index = 0
 	fileId, n := binary.Varint(buf[index:])
 	index += n
-	offset, _ := binary.Varint(buf[index:])
-	return &LogRecordPos{Fid: uint32(fileId), Offset: offset}
+	offset, n := binary.Varint(buf[index:])
+	index += n
+	pos := &LogRecordPos{Fid: uint32(fileId), Offset: offset}
+	if index < len(buf) {
+		size, n := binary.Varint(buf[index:])
+		index += n
+		pos.Size = uint32(size)
+	}
+	if index < len(buf) {
+		pos.Version, _ = binary.Uvarint(buf[index:])
+	}
+	return pos
+}
+
+// RecordPosSize 将记录的大小转换为 LogRecordPos 中的 Size，超过 uint32 范围时返回 0，按照未知大小处理
+func RecordPosSize(size int64) uint32 {
+	if size > math.MaxUint32 {
+		return 0
+	}
+	return uint32(size)
 }
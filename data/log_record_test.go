@@ -23,6 +23,8 @@ func TestEncodeDecodeLogRecord(t *testing.T) {
 
 		// 3. 验证头部信息是否匹配
 		assert.Equal(t, rec.Type, header.recordType)
+		assert.Equal(t, rec.Flags, header.flags)
+		assert.Equal(t, rec.Timestamp, header.timestamp)
 		assert.Equal(t, int64(len(rec.Key)), header.keySize)
 		assert.Equal(t, int64(len(rec.Value)), header.valueSize)
 
@@ -52,6 +54,17 @@ func TestEncodeDecodeLogRecord(t *testing.T) {
 		testRoundTrip(t, rec)
 	})
 
+	t.Run("record with timestamp and flags", func(t *testing.T) {
+		rec := &LogRecord{
+			Key:       []byte("name"),
+			Value:     []byte("bitcask-go"),
+			Type:      LogRecordNormal,
+			Flags:     1 << 16,
+			Timestamp: 1700000000123456789,
+		}
+		testRoundTrip(t, rec)
+	})
+
 	t.Run("deleted record", func(t *testing.T) {
 		rec := &LogRecord{
 			Key:   []byte("name"),
@@ -61,3 +74,24 @@ func TestEncodeDecodeLogRecord(t *testing.T) {
 		testRoundTrip(t, rec)
 	})
 }
+
+func TestEncodeDecodeLogRecordPos(t *testing.T) {
+	pos := DecodeLogRecordPos(EncodeLogRecordPos(&LogRecordPos{Fid: 3, Offset: 100, Size: 42, Version: 7}))
+	assert.Equal(t, &LogRecordPos{Fid: 3, Offset: 100, Size: 42, Version: 7}, pos)
+
+	// 旧格式只有文件 id 和偏移
+	pos = DecodeLogRecordPos([]byte{0x02, 0x80, 0x01})
+	assert.Equal(t, &LogRecordPos{Fid: 1, Offset: 64}, pos)
+}
+
+func TestEncodeLogRecord_Version(t *testing.T) {
+	encBytes, _ := EncodeLogRecord(&LogRecord{Key: []byte("key"), Value: []byte("value"), Version: 300})
+	header, _ := decodeLogRecordHeader(encBytes)
+	assert.Equal(t, uint64(300), header.version)
+	assert.Equal(t, uint32(0), header.flags)
+
+	// 没有版本号时不写入
+	encBytes, _ = EncodeLogRecord(&LogRecord{Key: []byte("key"), Value: []byte("value")})
+	header, _ = decodeLogRecordHeader(encBytes)
+	assert.Equal(t, uint64(0), header.version)
+}
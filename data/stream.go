@@ -0,0 +1,72 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrInvalidStreamManifest = errors.New("invalid stream manifest, log record maybe corrupted")
+)
+
+// StreamManifest 流式写入的 value 的清单，按顺序记录每个分块的位置
+type StreamManifest struct {
+	Size   uint64          // value 的总长度，可以超过 4GB
+	Chunks []*LogRecordPos // 每个分块记录的位置
+}
+
+// EncodeStreamManifest 对清单进行编码
+// | size (变长) | 分块数量 (变长) | [fid (变长) | offset (变长) | 记录大小 (变长)] ...
+func EncodeStreamManifest(manifest *StreamManifest) []byte {
+	buf := make([]byte, binary.MaxVarintLen64*2+len(manifest.Chunks)*(binary.MaxVarintLen32*2+binary.MaxVarintLen64))
+	var index = 0
+	index += binary.PutUvarint(buf[index:], manifest.Size)
+	index += binary.PutUvarint(buf[index:], uint64(len(manifest.Chunks)))
+	for _, chunk := range manifest.Chunks {
+		index += binary.PutUvarint(buf[index:], uint64(chunk.Fid))
+		index += binary.PutVarint(buf[index:], chunk.Offset)
+		index += binary.PutUvarint(buf[index:], uint64(chunk.Size))
+	}
+	return buf[:index]
+}
+
+// DecodeStreamManifest 解码清单
+func DecodeStreamManifest(buf []byte) (*StreamManifest, error) {
+	var index = 0
+	next := func() (uint64, bool) {
+		v, n := binary.Uvarint(buf[index:])
+		if n <= 0 {
+			return 0, false
+		}
+		index += n
+		return v, true
+	}
+
+	size, ok := next()
+	if !ok {
+		return nil, ErrInvalidStreamManifest
+	}
+	count, ok := next()
+	// 每个分块至少占用 3 个字节
+	if !ok || count > uint64(len(buf)-index)/3 {
+		return nil, ErrInvalidStreamManifest
+	}
+	manifest := &StreamManifest{Size: size, Chunks: make([]*LogRecordPos, 0, count)}
+	for i := uint64(0); i < count; i++ {
+		fid, ok := next()
+		if !ok {
+			return nil, ErrInvalidStreamManifest
+		}
+		offset, n := binary.Varint(buf[index:])
+		if n <= 0 {
+			return nil, ErrInvalidStreamManifest
+		}
+		index += n
+		chunkSize, ok := next()
+		if !ok {
+			return nil, ErrInvalidStreamManifest
+		}
+		manifest.Chunks = append(manifest.Chunks, &LogRecordPos{Fid: uint32(fid), Offset: offset, Size: uint32(chunkSize)})
+	}
+	return manifest, nil
+}
@@ -0,0 +1,40 @@
+package data
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecodeStreamManifest(t *testing.T) {
+	// 超过 4GB 的 value
+	manifest := &StreamManifest{
+		Size: 5 * 1024 * 1024 * 1024,
+		Chunks: []*LogRecordPos{
+			{Fid: 0, Offset: 0, Size: 1024},
+			{Fid: 1, Offset: 1 << 40, Size: math.MaxUint32},
+		},
+	}
+	decoded, err := DecodeStreamManifest(EncodeStreamManifest(manifest))
+	assert.Nil(t, err)
+	assert.Equal(t, manifest, decoded)
+
+	empty, err := DecodeStreamManifest(EncodeStreamManifest(&StreamManifest{}))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), empty.Size)
+	assert.Empty(t, empty.Chunks)
+
+	// 被截断的清单
+	buf := EncodeStreamManifest(manifest)
+	for _, n := range []int{0, 3, len(buf) - 1} {
+		_, err = DecodeStreamManifest(buf[:n])
+		assert.Equal(t, ErrInvalidStreamManifest, err)
+	}
+}
+
+func TestRecordPosSize(t *testing.T) {
+	assert.Equal(t, uint32(100), RecordPosSize(100))
+	assert.Equal(t, uint32(math.MaxUint32), RecordPosSize(math.MaxUint32))
+	assert.Equal(t, uint32(0), RecordPosSize(math.MaxUint32+1))
+}
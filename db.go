@@ -2,7 +2,11 @@ package bitcask_kv_go
 
 import (
 	"bitcask-kv-go/data"
+	"bitcask-kv-go/fio"
 	"bitcask-kv-go/index"
+	"bitcask-kv-go/utils"
+	"bytes"
+	"compress/flate"
 	"io"
 	"log"
 	"os"
@@ -11,6 +15,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // bitcask 存储引擎实例
@@ -22,7 +27,61 @@ type DB struct {
 	olderFiles map[uint32]*data.DataFile // 旧的数据文件，只能用于读
 	index      index.Indexer             // 内存索引
 	seqNo      uint64                    // 事务序列号，全局递增
+	version    uint64                    // 最近一次分配的版本号，全局递增，写入和删除都会分配新的版本号
 	isMerging  bool                      // 是否正在 merge
+
+	deletedHeads  map[string]*data.LogRecordPos // 已删除 key 的删除记录位置，作为历史版本链的起点，仅在开启历史版本时使用
+	relocations   map[string]*data.LogRecordPos // merge 之后需要重定位的历史版本链，key 对应 merge 后最新版本的位置
+	mergeBoundary uint32                        // 最近一次 merge 时未参与 merge 的最小文件 id
+
+	streamWriters int // 正在进行的流式写入数量，流式写入期间不能 merge
+	rangeDeleters int // 正在从索引中删除 key 的范围删除数量，删除期间不能 merge
+
+	valueCache *valueCache // value 缓存，没有开启时为 nil
+
+	fileHandles *fio.FileHandleCache // 旧数据文件的文件句柄缓存，没有限制打开的文件数量时为 nil
+
+	compressStat compressStat // 压缩统计信息，自打开数据库开始计算
+
+	activeBlobFile *data.DataFile            // 当前活跃的 blob 文件
+	blobFiles      map[uint32]*data.DataFile // 所有的 blob 文件，包括活跃的 blob 文件
+	blobPins       *sync.WaitGroup           // 当前回收周期中正在进行的不持有锁的读取，回收 blob 文件之前需要等待读取结束
+
+	dedupValues map[string]*dedupValue // 去重之后共享的 value，key 为 value 的哈希
+	dedupRefs   map[string]string      // 引用了共享 value 的 key 及其引用的 value 哈希
+	// 开始 merge 时未参与 merge 的最小文件 id，新的引用不能指向参与 merge 的文件中的共享 value
+	dedupBoundary uint32
+
+	pendingSeals []uint32 // 低内存模式下等待生成 key 文件的数据文件 id
+
+	replayFrom *indexCheckpoint // 持久化索引的检查点，打开数据库时只需要加载检查点之后的数据
+}
+
+// 压缩统计信息
+type compressStat struct {
+	records         int64 // 压缩过的记录数量
+	rawBytes        int64 // 压缩前的 value 大小
+	compressedBytes int64 // 压缩后的 value 大小
+}
+
+// Stat 存储引擎统计信息
+type Stat struct {
+	KeyNum      uint  // key 的总数量
+	DataFileNum uint  // 数据文件的数量
+	DiskSize    int64 // 数据目录所占磁盘空间大小
+	BlobFileNum uint  // blob 文件的数量
+
+	CompressedRecords    int64   // 自打开数据库以来压缩写入的记录数量
+	RawValueBytes        int64   // 压缩记录在压缩前的 value 总大小
+	CompressedValueBytes int64   // 压缩记录在压缩后的 value 总大小
+	CompressionRatio     float64 // 压缩比，即压缩后大小 / 压缩前大小，没有压缩过的记录时为 0
+
+	DedupValues     uint  // 去重之后共享的 value 数量
+	DedupSavedBytes int64 // 共享 value 节省的存储空间
+
+	ValueCacheHits   uint64 // value 缓存的命中次数
+	ValueCacheMisses uint64 // value 缓存的未命中次数
+	ValueCacheBytes  int64  // value 缓存当前占用的内存大小
 }
 
 // 打开 bitcask 存储引擎实例
@@ -38,12 +97,28 @@ func Open(options Options) (*DB, error) {
 		}
 	}
 
+	// 初始化内存索引
+	indexer, err := newIndexer(options)
+	if err != nil {
+		return nil, err
+	}
+
 	// 初始化 DB
 	db := &DB{
-		options:    options,
-		mu:         new(sync.RWMutex),
-		olderFiles: make(map[uint32]*data.DataFile),
-		index:      index.NewIndexer(options.IndexType),
+		options:      options,
+		mu:           new(sync.RWMutex),
+		olderFiles:   make(map[uint32]*data.DataFile),
+		index:        indexer,
+		deletedHeads: make(map[string]*data.LogRecordPos),
+		relocations:  make(map[string]*data.LogRecordPos),
+		blobFiles:    make(map[uint32]*data.DataFile),
+		blobPins:     new(sync.WaitGroup),
+		dedupValues:  make(map[string]*dedupValue),
+		dedupRefs:    make(map[string]string),
+		valueCache:   newValueCache(options.ValueCacheSize),
+	}
+	if options.MaxOpenFiles > 0 {
+		db.fileHandles = fio.NewFileHandleCache(options.MaxOpenFiles)
 	}
 
 	// 加载 merge 数据目录
@@ -56,16 +131,33 @@ func Open(options Options) (*DB, error) {
 		return nil, err
 	}
 
-	// 从 hint 索引文件中加载索引
-	if err := db.loadIndexFromHintFile(); err != nil {
+	// 加载 blob 文件
+	if err := db.loadBlobFiles(); err != nil {
+		return nil, err
+	}
+
+	// 加载持久化索引的检查点
+	if err := db.loadIndexCheckpoint(); err != nil {
 		return nil, err
 	}
 
+	// 从 hint 索引文件中加载索引，低内存模式使用 key 文件代替 hint 文件，持久化索引只需要加载检查点之后的数据
+	if !options.LowMemory && db.replayFrom == nil {
+		if err := db.loadIndexFromHintFile(); err != nil {
+			return nil, err
+		}
+	}
+
 	// 从数据文件中加载索引
 	if err := db.loadIndexFromDataFiles(); err != nil {
 		return nil, err
 	}
 
+	// 持久化加载之后的索引
+	if err := db.commitIndexLocked(true); err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
 
@@ -74,6 +166,11 @@ func (db *DB) Close() error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	// 持久化索引需要在关闭数据文件之前提交
+	if err := db.commitIndexLocked(true); err != nil {
+		return err
+	}
+
 	// 关闭活跃文件
 	if db.activeFile != nil {
 		if err := db.activeFile.Close(); err != nil {
@@ -86,7 +183,49 @@ func (db *DB) Close() error {
 			log.Printf("Failed to close older file %d: %v", fid, err)
 		}
 	}
-	return nil
+	// 关闭 blob 文件
+	for fid, file := range db.blobFiles {
+		if err := file.Close(); err != nil {
+			log.Printf("Failed to close blob file %d: %v", fid, err)
+		}
+	}
+	// 关闭索引中打开的文件
+	return closeIndexer(db.index)
+}
+
+// Stat 返回数据库的统计信息
+func (db *DB) Stat() (*Stat, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var dataFiles = uint(len(db.olderFiles))
+	if db.activeFile != nil {
+		dataFiles += 1
+	}
+
+	dirSize, err := utils.DirSize(db.options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	stat := &Stat{
+		KeyNum:               uint(db.index.Size()),
+		DataFileNum:          dataFiles,
+		DiskSize:             dirSize,
+		BlobFileNum:          uint(len(db.blobFiles)),
+		CompressedRecords:    db.compressStat.records,
+		RawValueBytes:        db.compressStat.rawBytes,
+		CompressedValueBytes: db.compressStat.compressedBytes,
+		DedupValues:          uint(len(db.dedupValues)),
+		DedupSavedBytes:      db.dedupSavedBytesLocked(),
+	}
+	if stat.RawValueBytes > 0 {
+		stat.CompressionRatio = float64(stat.CompressedValueBytes) / float64(stat.RawValueBytes)
+	}
+	if db.valueCache != nil {
+		stat.ValueCacheHits, stat.ValueCacheMisses, stat.ValueCacheBytes = db.valueCache.stat()
+	}
+	return stat, nil
 }
 
 // 持久化数据文件
@@ -97,7 +236,10 @@ func (db *DB) Sync() error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	return db.activeFile.Sync()
+	if err := db.activeFile.Sync(); err != nil {
+		return err
+	}
+	return db.commitIndexLocked(true)
 }
 
 func checkOptions(options *Options) error {
@@ -107,6 +249,42 @@ func checkOptions(options *Options) error {
 	if options.DataFileSize <= 0 {
 		return ErrDataFileSizeInvalid
 	}
+	if options.HistoryRetention < 0 {
+		return ErrHistoryRetentionInvalid
+	}
+	// 没有从 DefaultOptions 构造的配置可能没有设置 blob 文件的大小，使用默认值
+	if options.BlobFileSize == 0 {
+		options.BlobFileSize = DefaultOptions.BlobFileSize
+	}
+	if options.BlobThreshold < 0 || options.BlobFileSize < 0 {
+		return ErrBlobOptionsInvalid
+	}
+	if options.Dedup && options.DedupThreshold <= 0 {
+		return ErrDedupThresholdInvalid
+	}
+	if options.LowMemory && (options.KeyFileBlockSize <= 0 || options.KeepHistory || options.Dedup) {
+		return ErrLowMemoryOptionsInvalid
+	}
+	if options.IndexType == BPTree && !options.LowMemory && (options.KeepHistory || options.Dedup) {
+		return ErrBPTreeOptionsInvalid
+	}
+	if options.Compression > GzipCompression ||
+		options.CompressionLevel < flate.HuffmanOnly || options.CompressionLevel > flate.BestCompression {
+		return ErrCompressionInvalid
+	}
+	// 没有设置分块大小时使用默认值，不使用流式写入的数据库不需要关心这个配置
+	if options.StreamChunkSize == 0 {
+		options.StreamChunkSize = DefaultOptions.StreamChunkSize
+	}
+	if options.StreamChunkSize < 0 {
+		return ErrStreamChunkSizeInvalid
+	}
+	if options.ValueCacheSize < 0 {
+		return ErrValueCacheSizeInvalid
+	}
+	if options.MaxOpenFiles < 0 {
+		return ErrMaxOpenFilesInvalid
+	}
 	return nil
 }
 
@@ -115,18 +293,39 @@ func (db *DB) Put(key, value []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
-
-	// 构造 LogRecord 结构体
-	logRecord := &data.LogRecord{
-		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Value: value,
-		Type:  data.LogRecordNormal,
+	if err := db.checkKeySize(key); err != nil {
+		return err
 	}
 
 	// 加锁，保证写入和更新索引的原子性
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	return db.putLocked(key, value)
+}
+
+// 写入 Key/Value 数据，调用方需要先检查 key 的有效性
+// 在访问此方法前必须持有互斥锁
+func (db *DB) putLocked(key, value []byte) error {
+	// 构造 LogRecord 结构体，版本号在上一个版本的基础上递增
+	logRecord := &data.LogRecord{
+		Key:     logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:   value,
+		Type:    data.LogRecordNormal,
+		Version: db.nextVersionLocked(),
+	}
+
+	// 开启历史版本时，记录指向上一个版本
+	if db.options.KeepHistory {
+		logRecord.Prev = db.historyHeadLocked(key)
+	}
+
+	// 根据配置对 value 去重
+	logRecord, err := db.dedupLogRecordLocked(logRecord, nil)
+	if err != nil {
+		return err
+	}
+
 	// 追加写入到当前活跃数据文件
 	pos, err := db.appendLogRecordLocked(logRecord)
 	if err != nil {
@@ -134,10 +333,42 @@ func (db *DB) Put(key, value []byte) error {
 	}
 
 	// 更新内存索引
-	if ok := db.index.Put(key, pos); !ok {
+	if ok := db.updateIndexLocked(key, logRecord, pos); !ok {
 		return ErrIndexUpdateFailed
 	}
 
+	return db.finishWriteLocked()
+}
+
+// 从索引中获取 key 的位置，key 不存在时返回 nil
+// 需要读取磁盘的索引（低内存模式的分层索引、B+ 树索引）读取失败时返回错误，而不是视为 key 不存在
+func (db *DB) getIndexPos(key []byte) (*data.LogRecordPos, error) {
+	if getter, ok := db.index.(index.ErrGetter); ok {
+		return getter.GetErr(key)
+	}
+	return db.index.Get(key), nil
+}
+
+// 分配下一次写入的版本号，版本号全局递增，同一个 key 的版本号在删除之后重新写入时也不会重复
+// 在访问此方法前必须持有互斥锁
+func (db *DB) nextVersionLocked() uint64 {
+	db.version++
+	return db.version
+}
+
+// 加载索引时根据读取到的版本号更新当前的版本号
+// 在访问此方法前必须持有互斥锁
+func (db *DB) updateVersionLocked(version uint64) {
+	if version > db.version {
+		db.version = version
+	}
+}
+
+// 检查索引是否可以存储该 key，B+ 树索引限制了 key 的最大长度
+func (db *DB) checkKeySize(key []byte) error {
+	if db.options.IndexType == BPTree && !db.options.LowMemory && len(key) > index.BPTreeMaxKeySize {
+		return ErrKeyIsTooLarge
+	}
 	return nil
 }
 
@@ -148,17 +379,73 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 		return nil, ErrKeyIsEmpty
 	}
 
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-
 	// 从内存索引中获取 LogRecordPos 位置
-	pos := db.index.Get(key)
+	db.mu.RLock()
+	pos, err := db.getIndexPos(key)
+	blobPins := db.pinBlobsLocked()
+	db.mu.RUnlock()
+	defer blobPins.Done()
+	if err != nil {
+		return nil, err
+	}
 	if pos == nil {
 		return nil, ErrKeyNotFound
 	}
 
-	// 从数据文件中获取 value
-	return db.getValueByPosition(pos)
+	// 从数据文件中获取 value，读取文件时不持有锁
+	return db.getValueByPositionUnlocked(pos)
+}
+
+// RecordMeta 数据记录的元信息
+type RecordMeta struct {
+	Timestamp time.Time // 写入时间，以最初的格式写入的记录写入时间未知，为零值
+	ValueSize int64     // value 的长度
+	Fid       uint32    // 所在数据文件 id
+	Offset    int64     // 在数据文件中的偏移
+	Version   uint64    // key 的版本号，每次写入都比之前的版本更大，删除之后重新写入也不会重复
+}
+
+// GetWithMeta 根据 key 读取数据，同时返回该数据的元信息
+func (db *DB) GetWithMeta(key []byte) ([]byte, *RecordMeta, error) {
+	if len(key) == 0 {
+		return nil, nil, ErrKeyIsEmpty
+	}
+
+	db.mu.RLock()
+	pos, err := db.getIndexPos(key)
+	blobPins := db.pinBlobsLocked()
+	db.mu.RUnlock()
+	defer blobPins.Done()
+	if err != nil {
+		return nil, nil, err
+	}
+	if pos == nil {
+		return nil, nil, ErrKeyNotFound
+	}
+
+	logRecord, err := db.getLogRecordByPositionUnlocked(pos)
+	if err != nil {
+		return nil, nil, err
+	}
+	return logRecord.Value, newRecordMeta(logRecord, pos), nil
+}
+
+func newRecordMeta(logRecord *data.LogRecord, pos *data.LogRecordPos) *RecordMeta {
+	return &RecordMeta{
+		Timestamp: recordTime(logRecord.Timestamp),
+		ValueSize: int64(len(logRecord.Value)),
+		Fid:       pos.Fid,
+		Offset:    pos.Offset,
+		Version:   pos.Version,
+	}
+}
+
+// 记录的写入时间，写入时间未知时返回零值，通过 time.Time.IsZero 判断
+func recordTime(timestamp int64) time.Time {
+	if timestamp == data.TimestampUnknown {
+		return time.Time{}
+	}
+	return time.Unix(0, timestamp)
 }
 
 // 根据 key 删除对应的数据
@@ -172,27 +459,55 @@ func (db *DB) Delete(key []byte) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	return db.deleteLocked(key)
+}
+
+// 删除 key 对应的数据，调用方需要先检查 key 的有效性
+// 在访问此方法前必须持有互斥锁
+func (db *DB) deleteLocked(key []byte) error {
 	// 2. 检查 key 是否存在，如果不存在，直接返回
-	if pos := db.index.Get(key); pos == nil {
+	oldPos, err := db.getIndexPos(key)
+	if err != nil {
+		return err
+	}
+	if oldPos == nil {
 		return nil
 	}
 
-	// 3. 构造 LogRecord，标记为删除类型
+	// 3. 构造 LogRecord，标记为删除类型，删除记录同样占用一个版本号
 	logRecord := &data.LogRecord{
-		Key:  logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Type: data.LogRecordDeleted,
+		Key:     logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Type:    data.LogRecordDeleted,
+		Version: db.nextVersionLocked(),
+	}
+	if db.options.KeepHistory {
+		logRecord.Prev = oldPos
 	}
 
 	// 4. 将删除记录追加写入到数据文件
-	_, err := db.appendLogRecordLocked(logRecord)
+	pos, err := db.appendLogRecordLocked(logRecord)
 	if err != nil {
 		return err
 	}
 	// 5. 从内存索引中删除 key，并返回结果
-	if ok := db.index.Delete(key); !ok {
+	if ok := db.updateIndexLocked(key, logRecord, pos); !ok {
 		return ErrIndexUpdateFailed
 	}
-	return nil
+	return db.finishWriteLocked()
+}
+
+// 根据写入的记录更新内存索引，同时维护历史版本链的起点和共享 value 的引用计数
+// 在访问此方法前必须持有互斥锁
+func (db *DB) updateIndexLocked(key []byte, logRecord *data.LogRecord, pos *data.LogRecordPos) bool {
+	var ok bool
+	if logRecord.Type == data.LogRecordDeleted {
+		ok = db.index.Delete(key)
+	} else {
+		ok = db.index.Put(key, pos)
+	}
+	db.updateHistoryHeadLocked(key, logRecord.Type, pos)
+	db.updateDedupRefLocked(key, logRecord)
+	return ok
 }
 
 // 获取数据库中所有的 key
@@ -208,32 +523,77 @@ func (db *DB) ListKeys() [][]byte {
 }
 
 // Fold 获取所有的数据，并执行用户指定的操作，函数返回 false 时终止遍历
+// 遍历期间不持有数据库的锁，写操作不会被阻塞，fn 中也可以读写数据库，但是不能调用 GCBlobs，回收 blob 文件需要等待遍历结束
 func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 	db.mu.RLock()
-	defer db.mu.RUnlock()
-
 	iterator := db.index.Iterator(false)
+	blobPins := db.pinBlobsLocked()
+	db.mu.RUnlock()
+	defer blobPins.Done()
+	defer iterator.Close()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		value, err := db.getValueByPosition(iterator.Value())
+		value, err := db.getValueByPositionUnlocked(iterator.Value())
 		if err != nil {
 			return err
 		}
 		if !fn(iterator.Key(), value) {
-			break
+			return nil
 		}
 	}
+	if errIter, ok := iterator.(index.ErrIterator); ok {
+		return errIter.Err()
+	}
 	return nil
 }
 
 // 根据索引信息获取对应的 value
 func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
-	// 根据文件id 找到对应的数据文件
-	var dataFile *data.DataFile
-	if db.activeFile.FileId == logRecordPos.Fid {
-		dataFile = db.activeFile
-	} else {
-		dataFile = db.olderFiles[logRecordPos.Fid]
+	// 缓存中的 value 是共享的，返回拷贝
+	if value, ok := db.getCachedValue(logRecordPos); ok {
+		return bytes.Clone(value), nil
 	}
+	logRecord, err := db.getLogRecordByPosition(logRecordPos)
+	if err != nil {
+		return nil, err
+	}
+	db.cacheValue(logRecordPos, logRecord.Value)
+	return logRecord.Value, nil
+}
+
+// 根据索引信息获取对应的 LogRecord
+func (db *DB) getLogRecordByPosition(logRecordPos *data.LogRecordPos) (*data.LogRecord, error) {
+	logRecord, err := db.readLogRecord(logRecordPos)
+	if err != nil {
+		return nil, err
+	}
+
+	// 判断 LogRecord 类型是否为删除类型
+	if logRecord.Type == data.LogRecordDeleted {
+		return nil, ErrKeyNotFound
+	}
+
+	if err := db.resolveValueLocked(logRecord); err != nil {
+		return nil, err
+	}
+	return logRecord, nil
+}
+
+// 读取记录实际的 value，value 可能经过去重或者存储在 blob 文件中
+// 在访问此方法前必须持有锁
+func (db *DB) resolveValueLocked(logRecord *data.LogRecord) error {
+	if err := db.resolveDedupLocked(logRecord); err != nil {
+		return err
+	}
+	if err := db.resolveBlobLocked(logRecord); err != nil {
+		return err
+	}
+	return db.resolveStreamLocked(logRecord)
+}
+
+// 根据位置信息读取原始的 LogRecord，不区分记录类型
+func (db *DB) readLogRecord(logRecordPos *data.LogRecordPos) (*data.LogRecord, error) {
+	// 根据文件id 找到对应的数据文件
+	dataFile := db.getDataFileLocked(logRecordPos.Fid)
 	// 判断数据文件是否存在
 	if dataFile == nil {
 		return nil, ErrDataFileNotFound
@@ -241,16 +601,105 @@ func (db *DB) getValueByPosition(logRecordPos *data.LogRecordPos) ([]byte, error
 
 	// 根据偏移读取对应的数据
 	logRecord, _, err := dataFile.ReadLogRecord(logRecordPos.Offset)
+	return logRecord, err
+}
+
+// 根据文件 id 找到对应的数据文件，不存在时返回 nil
+// 在访问此方法前必须持有锁
+func (db *DB) getDataFileLocked(fid uint32) *data.DataFile {
+	if db.activeFile != nil && db.activeFile.FileId == fid {
+		return db.activeFile
+	}
+	return db.olderFiles[fid]
+}
+
+// 根据索引信息获取对应的 value，调用方不能持有锁
+// 只在查找数据文件时短暂地持有读锁，读取文件和校验 crc 时不持有锁，避免写操作等待磁盘读取
+func (db *DB) getValueByPositionUnlocked(logRecordPos *data.LogRecordPos) ([]byte, error) {
+	// 缓存中的 value 是共享的，返回拷贝
+	if value, ok := db.getCachedValue(logRecordPos); ok {
+		return bytes.Clone(value), nil
+	}
+	logRecord, err := db.getLogRecordByPositionUnlocked(logRecordPos)
 	if err != nil {
 		return nil, err
 	}
+	db.cacheValue(logRecordPos, logRecord.Value)
+	return logRecord.Value, nil
+}
 
-	// 判断 LogRecord 类型是否为删除类型
+// 根据索引信息获取对应的 LogRecord，调用方不能持有锁
+func (db *DB) getLogRecordByPositionUnlocked(logRecordPos *data.LogRecordPos) (*data.LogRecord, error) {
+	logRecord, err := db.readLogRecordUnlocked(logRecordPos)
+	if err != nil {
+		return nil, err
+	}
 	if logRecord.Type == data.LogRecordDeleted {
 		return nil, ErrKeyNotFound
 	}
+	if err := db.resolveValueUnlocked(logRecord); err != nil {
+		return nil, err
+	}
+	return logRecord, nil
+}
 
-	return logRecord.Value, nil
+// 根据位置信息读取原始的 LogRecord，不区分记录类型，调用方不能持有锁
+func (db *DB) readLogRecordUnlocked(logRecordPos *data.LogRecordPos) (*data.LogRecord, error) {
+	dataFile, err := db.pinDataFile(logRecordPos.Fid)
+	if err != nil {
+		return nil, err
+	}
+	defer dataFile.Unpin()
+
+	logRecord, _, err := dataFile.ReadLogRecord(logRecordPos.Offset)
+	return logRecord, err
+}
+
+// 在读锁内找到数据文件并固定，之后可以不持有锁读取，读取结束之后需要调用 Unpin
+// 封存和关闭文件时会等待固定的读取结束；merge 不会删除正在使用的数据文件，因此索引中的位置在锁外仍然有效
+func (db *DB) pinDataFile(fid uint32) (*data.DataFile, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	dataFile := db.getDataFileLocked(fid)
+	if dataFile == nil {
+		return nil, ErrDataFileNotFound
+	}
+	dataFile.Pin()
+	return dataFile, nil
+}
+
+// 读取记录实际的 value，调用方不能持有锁
+// 去重、blob 和流式写入的 value 只在查找文件时短暂地持有读锁，读取文件时不持有锁
+// 读取 blob 之前需要通过 pinBlobsLocked 固定回收周期，保证 blob 文件不会在读取之前被回收
+func (db *DB) resolveValueUnlocked(logRecord *data.LogRecord) error {
+	if logRecord.Flags&data.FlagDedupRef != 0 {
+		_, pos, err := data.DecodeDedupRef(logRecord.Value)
+		if err != nil {
+			return err
+		}
+		valueRecord, err := db.readLogRecordUnlocked(pos)
+		if err != nil {
+			return err
+		}
+		if err := setDedupValue(logRecord, valueRecord); err != nil {
+			return err
+		}
+	}
+	if err := db.resolveBlobUnlocked(logRecord); err != nil {
+		return err
+	}
+	return db.resolveStreamUnlocked(logRecord)
+}
+
+// 固定当前的 blob 回收周期，读取结束之后需要调用返回值的 Done
+// 回收 blob 文件时先把其中有效的 value 重写到新的位置，再切换到新的周期，等待之前的周期中的读取全部结束之后才删除文件
+// 在查找索引的同一个临界区内固定周期，之后读取到的 blob 引用在调用 Done 之前一直有效
+// 在访问此方法前必须持有锁
+func (db *DB) pinBlobsLocked() *sync.WaitGroup {
+	pins := db.blobPins
+	pins.Add(1)
+	return pins
 }
 
 // 追加写数据到活跃文件中（内部实现，调用前需要持有锁）
@@ -262,21 +711,43 @@ func (db *DB) appendLogRecordLocked(logRecord *data.LogRecord) (*data.LogRecordP
 			return nil, err
 		}
 	}
+	// 记录写入时间，merge 重写的记录保留原有的时间
+	if logRecord.Timestamp == 0 {
+		logRecord.Timestamp = time.Now().UnixNano()
+	}
+	// 较大的 value 写入到单独的 blob 文件中，数据文件中只保存 blob 的位置
+	if db.options.BlobThreshold > 0 && logRecord.Type == data.LogRecordNormal &&
+		logRecord.Flags&(data.FlagBlobRef|data.FlagDedupRef|data.FlagStreamRef) == 0 && len(logRecord.Value) >= db.options.BlobThreshold {
+		realKey, _ := parseLogRecordKey(logRecord.Key)
+		blobPos, err := db.appendBlobLocked(realKey, logRecord.Value, logRecord.Timestamp)
+		if err != nil {
+			return nil, err
+		}
+		ref := *logRecord
+		ref.Value = data.EncodeLogRecordPos(blobPos)
+		ref.Flags |= data.FlagBlobRef
+		logRecord = &ref
+	}
+	// 根据配置压缩 value
+	logRecord, err := db.compressLogRecordLocked(logRecord)
+	if err != nil {
+		return nil, err
+	}
 	// 写入数据编码
-	encRecord, size := data.EncodeLogRecord(logRecord)
+	encRecord, size, err := db.activeFile.EncodeLogRecord(logRecord)
+	if err != nil {
+		return nil, err
+	}
 
 	// 如果写入的数据已经到达了活跃文件的阈值，则关闭活跃文件，并打开新的文件
-	if db.activeFile.WriteOff+size > db.options.DataFileSize {
-		// 先持久化数据文件，保证已有的数据持久到磁盘当中
-		if err := db.activeFile.Sync(); err != nil {
+	// 以旧格式写入的活跃文件不能追加新格式的记录，也需要切换到新的文件
+	if db.activeFile.WriteOff+size > db.options.DataFileSize || db.activeFile.Format() != data.CurrentFileFormat {
+		if err := db.rotateActiveFileLocked(); err != nil {
 			return nil, err
 		}
-
-		// 当前活跃文件转换为旧的数据文件
-		db.olderFiles[db.activeFile.FileId] = db.activeFile
-
-		// 打开新的数据文件
-		if err := db.setActiveDataFile(); err != nil {
+		// 新的活跃文件可能使用了不同的密钥，需要重新编码
+		encRecord, _, err = db.activeFile.EncodeLogRecord(logRecord)
+		if err != nil {
 			return nil, err
 		}
 	}
@@ -295,10 +766,59 @@ func (db *DB) appendLogRecordLocked(logRecord *data.LogRecord) (*data.LogRecordP
 	}
 
 	// 构造内存索引信息
-	pos := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff}
+	pos := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff, Size: data.RecordPosSize(int64(len(encRecord))), Version: logRecord.Version}
 	return pos, nil
 }
 
+// 根据配置压缩 value，返回压缩之后的 LogRecord
+// 在访问此方法前必须持有互斥锁
+func (db *DB) compressLogRecordLocked(logRecord *data.LogRecord) (*data.LogRecord, error) {
+	if db.options.Compression == NoCompression ||
+		(logRecord.Type != data.LogRecordNormal && logRecord.Type != data.LogRecordDedupValue && logRecord.Type != data.LogRecordValueChunk) ||
+		logRecord.Flags&(data.FlagBlobRef|data.FlagDedupRef|data.FlagStreamRef) != 0 ||
+		len(logRecord.Value) == 0 || len(logRecord.Value) < db.options.CompressionThreshold {
+		return logRecord, nil
+	}
+	compressed, err := data.CompressLogRecord(logRecord, db.options.Compression, db.options.CompressionLevel)
+	if err != nil {
+		return nil, err
+	}
+	if compressed != logRecord {
+		db.compressStat.records++
+		db.compressStat.rawBytes += int64(len(logRecord.Value))
+		db.compressStat.compressedBytes += int64(len(compressed.Value))
+	}
+	return compressed, nil
+}
+
+// 将当前活跃文件转换为旧的数据文件，并打开新的活跃文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) rotateActiveFileLocked() error {
+	// 先持久化数据文件，保证已有的数据持久到磁盘当中
+	if err := db.activeFile.Sync(); err != nil {
+		return err
+	}
+
+	// 封存当前活跃文件，写入 footer 之后以只读方式重新打开
+	// 不持有锁的读取可能正在读取活跃文件，需要等待读取结束
+	db.activeFile.WaitUnpinned()
+	if err := db.activeFile.WriteFooter(); err != nil {
+		return err
+	}
+	if err := db.reopenReadOnly(db.activeFile); err != nil {
+		return err
+	}
+
+	// 当前活跃文件转换为旧的数据文件，低内存模式下写操作完成之后再为其生成 key 文件
+	db.olderFiles[db.activeFile.FileId] = db.activeFile
+	if db.options.LowMemory {
+		db.pendingSeals = append(db.pendingSeals, db.activeFile.FileId)
+	}
+
+	// 打开新的数据文件
+	return db.setActiveDataFile()
+}
+
 // 设置当前活跃文件
 // 在访问此方法前必须持有互斥锁
 func (db *DB) setActiveDataFile() error {
@@ -307,7 +827,7 @@ func (db *DB) setActiveDataFile() error {
 		initialFileId = db.activeFile.FileId + 1
 	}
 	// 打开新的数据文件
-	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId)
+	dataFile, err := db.openDataFile(db.options.DirPath, initialFileId)
 	if err != nil {
 		return err
 	}
@@ -315,6 +835,24 @@ func (db *DB) setActiveDataFile() error {
 	return nil
 }
 
+// 封存之后的数据文件以只读方式重新打开，设置了 MaxOpenFiles 时改为通过文件句柄缓存在读取时才打开
+func (db *DB) reopenReadOnly(dataFile *data.DataFile) error {
+	if err := dataFile.IoManager.Close(); err != nil {
+		return err
+	}
+	fileName := data.GetDataFileName(db.options.DirPath, dataFile.FileId)
+	if db.fileHandles != nil {
+		dataFile.IoManager = fio.NewCachedFileIOManager(fileName, dataFile.WriteOff, db.fileHandles)
+		return nil
+	}
+	ioManager, err := fio.NewReadOnlyFileIOManager(fileName)
+	if err != nil {
+		return err
+	}
+	dataFile.IoManager = ioManager
+	return nil
+}
+
 // 从磁盘中加载数据文件
 func (db *DB) loadDataFiles() error {
 	dirEntries, err := os.ReadDir(db.options.DirPath)
@@ -342,14 +880,34 @@ func (db *DB) loadDataFiles() error {
 
 	// 遍历每个文件id，打开对应的数据文件
 	for i, fid := range fileIds {
-		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid))
+		if i < len(fileIds)-1 { // 旧的数据文件以只读方式打开
+			dataFile, err := db.openOlderDataFile(uint32(fid))
+			if err != nil {
+				return err
+			}
+			db.olderFiles[uint32(fid)] = dataFile
+			continue
+		}
+
+		// 最后一个，id是最大的，说明是当前活跃文件
+		dataFile, err := db.openDataFile(db.options.DirPath, uint32(fid))
 		if err != nil {
 			return err
 		}
-		if i == len(fileIds)-1 { // 最后一个，id是最大的，说明是当前活跃文件
-			db.activeFile = dataFile
-		} else { // 说明是旧的数据文件
+		if err := dataFile.LoadFooter(); err != nil {
+			_ = dataFile.Close()
+			return err
+		}
+		db.activeFile = dataFile
+		// 上次封存活跃文件之后还没有打开新的活跃文件，封存的文件作为旧的数据文件
+		if dataFile.Sealed() {
+			if err := db.reopenReadOnly(dataFile); err != nil {
+				return err
+			}
 			db.olderFiles[uint32(fid)] = dataFile
+			if err := db.setActiveDataFile(); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
@@ -367,22 +925,25 @@ func (db *DB) loadIndexFromDataFiles() error {
 	hasMerge, nonMergeFileId := false, uint32(0)
 	mergeFinFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
 	if _, err := os.Stat(mergeFinFileName); err == nil {
-		fid, err := db.getNonMergeFileId(db.options.DirPath)
+		fid, version, err := db.getNonMergeFileId(db.options.DirPath)
 		if err != nil {
 			return err
 		}
 		hasMerge = true
 		nonMergeFileId = fid
+		db.mergeBoundary = fid
+		db.updateVersionLocked(version)
 	}
 
-	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
-		var ok bool
-		if typ == data.LogRecordDeleted {
-			ok = db.index.Delete(key)
-		} else {
-			ok = db.index.Put(key, pos)
+	updateIndex := func(key []byte, logRecord *data.LogRecord, pos *data.LogRecordPos) {
+		// merge 之前写入的记录，指向的上一个版本已经被 merge 重写，需要重定位到 merge 后的最新版本
+		if db.options.KeepHistory && hasMerge && logRecord.Prev != nil && logRecord.Prev.Fid < nonMergeFileId {
+			if _, ok := db.relocations[string(key)]; !ok {
+				db.relocations[string(key)] = db.historyHeadLocked(key)
+			}
 		}
-		if !ok {
+
+		if ok := db.updateIndexLocked(key, logRecord, pos); !ok {
 			panic("failed to update index at startup")
 		}
 	}
@@ -390,14 +951,32 @@ func (db *DB) loadIndexFromDataFiles() error {
 	// 暂存事务数据
 	transactionRecords := make(map[uint64][]*data.TransactionRecord)
 	var currentSeqNo = nonTransactionSeqNo
+	if db.replayFrom != nil {
+		currentSeqNo = db.replayFrom.seqNo
+		db.updateVersionLocked(db.replayFrom.version)
+	}
 
 	// 遍历所有的文件id，处理文件中的记录
 	for _, fid := range db.fileIds {
 		var fileId = uint32(fid)
 		// 如果比最近未参与 merge 的文件 id 更小，则说明已经从 Hint 文件中加载索引了
-		if hasMerge && fileId < nonMergeFileId {
+		if hasMerge && fileId < nonMergeFileId && !db.options.LowMemory {
 			continue
 		}
+		// 持久化索引已经包含了检查点之前的数据
+		if db.replayFrom != nil && fileId < db.replayFrom.fid {
+			continue
+		}
+		// 低内存模式下，已封存的数据文件直接加载 key 文件
+		if db.options.LowMemory && fileId != db.activeFile.FileId {
+			loaded, err := db.loadKeyFileLocked(fileId)
+			if err != nil {
+				return err
+			}
+			if loaded {
+				continue
+			}
+		}
 
 		var dataFile *data.DataFile
 		if fileId == db.activeFile.FileId {
@@ -407,6 +986,9 @@ func (db *DB) loadIndexFromDataFiles() error {
 		}
 
 		var offset int64 = 0
+		if db.replayFrom != nil && fileId == db.replayFrom.fid {
+			offset = db.replayFrom.offset
+		}
 		for {
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
 			if err != nil {
@@ -415,21 +997,45 @@ func (db *DB) loadIndexFromDataFiles() error {
 				}
 				return err
 			}
+			// 跳过文件头
+			if logRecord.Type == data.LogRecordFileHeader {
+				offset += size
+				continue
+			}
+			// 流式写入的分块由清单记录引用，不进入索引
+			if logRecord.Type == data.LogRecordValueChunk {
+				offset += size
+				continue
+			}
 
 			// 构造内存索引并保存
-			logRecordPos := &data.LogRecordPos{Fid: fileId, Offset: offset}
+			logRecordPos := &data.LogRecordPos{Fid: fileId, Offset: offset, Size: data.RecordPosSize(size), Version: logRecord.Version}
+			// 删除记录也带有版本号，保证删除之后重新写入的版本号不会重复
+			db.updateVersionLocked(logRecord.Version)
 
 			// 解析 key，拿到事务序列号
 			realKey, seqNo := parseLogRecordKey(logRecord.Key)
-			if seqNo == nonTransactionSeqNo {
+			if logRecord.Type == data.LogRecordDedupValue {
+				// 共享的 value 不进入索引，引用计数由引用它的 key 维护
+				db.putDedupValueLocked(realKey, logRecordPos, int64(len(logRecord.Value)))
+			} else if logRecord.Type == data.LogRecordRangeDeleted {
+				// 范围删除标记只影响在它之前写入的 key
+				db.deleteRangeFromIndexLocked(realKey, rangeDeleteEnd(logRecord), logRecordPos)
+			} else if seqNo == nonTransactionSeqNo {
 				// 非事务操作，直接更新内存索引
-				updateIndex(realKey, logRecord.Type, logRecordPos)
+				updateIndex(realKey, logRecord, logRecordPos)
 			} else {
 				// 事务完成，对应的 seq no 的数据可以更新到内存索引中
 				if logRecord.Type == data.LogRecordTxnFinished {
+					// 和提交事务时一样，先预留事务中对共享 value 的引用，再更新索引
+					reserved := make(dedupReservation)
+					for _, txnRecord := range transactionRecords[seqNo] {
+						db.reserveDedupLocked(txnRecord.Record, reserved)
+					}
 					for _, txnRecord := range transactionRecords[seqNo] {
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
+						updateIndex(txnRecord.Record.Key, txnRecord.Record, txnRecord.Pos)
 					}
+					db.releaseDedupLocked(reserved)
 					delete(transactionRecords, seqNo)
 				} else {
 					logRecord.Key = realKey
@@ -448,6 +1054,16 @@ func (db *DB) loadIndexFromDataFiles() error {
 			// 递增 offset，下一次从新的位置开始读取
 			offset += size
 		}
+
+		// 低内存模式下，没有 key 文件的旧数据文件读取完之后生成 key 文件，跨越文件的事务完成之后才能封存
+		if db.options.LowMemory && fileId != db.activeFile.FileId {
+			db.pendingSeals = append(db.pendingSeals, fileId)
+			if len(transactionRecords) == 0 {
+				if err := db.sealFilesLocked(); err != nil {
+					return err
+				}
+			}
+		}
 	}
 	// 更新事务序列号
 	db.seqNo = currentSeqNo
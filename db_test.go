@@ -1,10 +1,20 @@
 package bitcask_kv_go
 
 import (
+	"bitcask-kv-go/data"
+	"bitcask-kv-go/fio"
 	"bitcask-kv-go/utils"
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -162,6 +172,38 @@ func TestDB_CRUD(t *testing.T) {
 	})
 }
 
+func TestDB_GetWithMeta(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+
+	_, _, err := db.GetWithMeta(nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	_, _, err = db.GetWithMeta([]byte("non-existent-key"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	before := time.Now()
+	assert.Nil(t, db.Put([]byte("key-1"), []byte("value-1")))
+	after := time.Now()
+
+	val, meta, err := db.GetWithMeta([]byte("key-1"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-1"), val)
+	assert.Equal(t, int64(len("value-1")), meta.ValueSize)
+	assert.Equal(t, db.activeFile.FileId, meta.Fid)
+	// 第一条记录写在文件头之后
+	assert.Greater(t, meta.Offset, int64(0))
+	assert.False(t, meta.Timestamp.Before(before.Truncate(0)))
+	assert.False(t, meta.Timestamp.After(after))
+
+	// 覆盖写之后时间和位置都会更新
+	assert.Nil(t, db.Put([]byte("key-1"), []byte("value-2")))
+	_, meta2, err := db.GetWithMeta([]byte("key-1"))
+	assert.Nil(t, err)
+	assert.Greater(t, meta2.Offset, meta.Offset)
+	assert.False(t, meta2.Timestamp.Before(meta.Timestamp))
+}
+
 func TestDB_Restart(t *testing.T) {
 	opts := DefaultOptions
 	dir := t.TempDir()
@@ -196,6 +238,44 @@ func TestDB_Restart(t *testing.T) {
 	assert.Equal(t, ErrKeyNotFound, err)
 }
 
+func TestDB_IndexTypes(t *testing.T) {
+	for _, indexType := range []IndexerType{SkipList, Hash} {
+		t.Run(fmt.Sprintf("index type %d", indexType), func(t *testing.T) {
+			opts := DefaultOptions
+			opts.DirPath = t.TempDir()
+			opts.IndexType = indexType
+			db, err := Open(opts)
+			assert.Nil(t, err)
+
+			for i := 0; i < 100; i++ {
+				assert.Nil(t, db.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%d", i))))
+			}
+			assert.Nil(t, db.Delete([]byte("key-050")))
+			assert.Nil(t, db.Close())
+
+			db, err = Open(opts)
+			assert.Nil(t, err)
+			defer func() { _ = db.Close() }()
+
+			val, err := db.Get([]byte("key-010"))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("value-10"), val)
+			_, err = db.Get([]byte("key-050"))
+			assert.Equal(t, ErrKeyNotFound, err)
+			assert.Equal(t, 99, len(db.ListKeys()))
+
+			// 前缀迭代返回有序的结果
+			var keys []string
+			iter := db.NewIterator(IteratorOptions{Prefix: []byte("key-05")})
+			for ; iter.Valid(); iter.Next() {
+				keys = append(keys, string(iter.Key()))
+			}
+			iter.Close()
+			assert.Equal(t, []string{"key-051", "key-052", "key-053", "key-054", "key-055", "key-056", "key-057", "key-058", "key-059"}, keys)
+		})
+	}
+}
+
 func TestDB_ListKeys(t *testing.T) {
 	db := initDB(t)
 	defer db.Close()
@@ -314,6 +394,88 @@ func TestDB_Sync(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestDB_Stat(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(20)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(99), stat.KeyNum)
+	assert.Equal(t, uint(1), stat.DataFileNum)
+	assert.Greater(t, stat.DiskSize, int64(0))
+	assert.Equal(t, int64(0), stat.CompressedRecords)
+}
+
+func TestDB_Compression(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+
+	// 先写入未压缩的数据
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	plainValue := bytes.Repeat([]byte(`{"plain":true}`), 50)
+	assert.Nil(t, db.Put([]byte("plain"), plainValue))
+	assert.Nil(t, db.Close())
+
+	// 开启压缩后，新旧记录共存于同一个文件中
+	opts.Compression = GzipCompression
+	opts.CompressionThreshold = 64
+	db, err = Open(opts)
+	assert.Nil(t, err)
+
+	jsonValue := bytes.Repeat([]byte(`{"name":"bitcask","tags":["kv","log"]}`), 50)
+	assert.Nil(t, db.Put([]byte("json"), jsonValue))
+	assert.Nil(t, db.Put([]byte("small"), []byte("tiny")))
+
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), stat.CompressedRecords)
+	assert.Equal(t, int64(len(jsonValue)), stat.RawValueBytes)
+	assert.Less(t, stat.CompressionRatio, 0.5)
+
+	check := func(db *DB) {
+		val, err := db.Get([]byte("json"))
+		assert.Nil(t, err)
+		assert.Equal(t, jsonValue, val)
+		val, err = db.Get([]byte("plain"))
+		assert.Nil(t, err)
+		assert.Equal(t, plainValue, val)
+		val, err = db.Get([]byte("small"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("tiny"), val)
+
+		iter := db.NewIterator(DefaultIteratorOptions)
+		defer iter.Close()
+		iter.Seek([]byte("json"))
+		val, err = iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, jsonValue, val)
+	}
+	check(db)
+
+	// merge 之后重启，数据依然可以正常读取
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	opts.Compression = FlateCompression
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	check(db)
+
+	t.Run("invalid options", func(t *testing.T) {
+		invalid := opts
+		invalid.CompressionLevel = 100
+		_, err := Open(invalid)
+		assert.Equal(t, ErrCompressionInvalid, err)
+	})
+}
+
 // TestDB_ConcurrentChaos 是一个更混乱的并发测试，所有操作同时进行。
 // 主要目的是在 -race 标志下检测数据竞争。
 func TestDB_ConcurrentChaos(t *testing.T) {
@@ -353,3 +515,483 @@ func TestDB_ConcurrentChaos(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestDB_MaxOpenFiles(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.MaxOpenFiles = -1
+	_, err := Open(opts)
+	assert.Equal(t, ErrMaxOpenFilesInvalid, err)
+
+	opts.MaxOpenFiles = 3
+	opts.DataFileSize = 8 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	const keyCount = 2000
+	for i := 0; i < keyCount; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Greater(t, len(db.olderFiles), 10)
+	assert.Equal(t, 0, db.fileHandles.OpenFiles())
+
+	// 并发读写时只会打开有限数量的旧数据文件
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < keyCount; i += 8 {
+				val, err := db.Get(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				assert.Equal(t, utils.GetTestKey(i), val)
+			}
+		}(g)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := keyCount; i < keyCount+500; i++ {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+		}
+	}()
+	wg.Wait()
+	assert.LessOrEqual(t, db.fileHandles.OpenFiles(), 3)
+
+	// merge 之后删除旧的数据文件，重新打开的数据库同样可以读取
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer func() { _ = db.Close() }()
+	for i := 0; i < keyCount+500; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	assert.LessOrEqual(t, db.fileHandles.OpenFiles(), 3)
+}
+
+func TestDB_SealedDataFiles(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.DataFileSize = 8 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	const keyCount = 1000
+	for i := 0; i < keyCount; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Greater(t, len(db.olderFiles), 1)
+	// 写满的文件封存之后以只读方式打开，并且可以完整校验
+	for fid, dataFile := range db.olderFiles {
+		assert.True(t, dataFile.Sealed())
+		assert.Equal(t, fio.ErrReadOnlyFile, dataFile.Write([]byte("x")))
+		footer, err := data.VerifyDataFile(data.GetDataFileName(opts.DirPath, fid))
+		assert.Nil(t, err)
+		assert.NotNil(t, footer)
+	}
+	assert.False(t, db.activeFile.Sealed())
+
+	// 模拟写入 footer 之后、创建新的活跃文件之前崩溃
+	activeFid := db.activeFile.FileId
+	assert.Nil(t, db.activeFile.WriteFooter())
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.True(t, db.olderFiles[activeFid].Sealed())
+	assert.Equal(t, activeFid+1, db.activeFile.FileId)
+	for i := 0; i < keyCount; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+
+	// merge 生成的数据文件同样是封存的
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer func() { _ = db.Close() }()
+	for _, dataFile := range db.olderFiles {
+		assert.True(t, dataFile.Sealed())
+	}
+	for i := 0; i < keyCount; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+}
+
+// 读取时阻塞的 IO，用于模拟较慢的磁盘读取
+type slowReadIO struct {
+	fio.IOManager
+	started chan struct{}
+	release chan struct{}
+}
+
+func newSlowReadIO(ioManager fio.IOManager) *slowReadIO {
+	return &slowReadIO{IOManager: ioManager, started: make(chan struct{}, 16), release: make(chan struct{})}
+}
+
+func (s *slowReadIO) Read(b []byte, offset int64) (int, error) {
+	select {
+	case s.started <- struct{}{}:
+	default:
+	}
+	<-s.release
+	return s.IOManager.Read(b, offset)
+}
+
+func TestDB_ReadWithoutLock(t *testing.T) {
+	db := initDB(t)
+	assert.Nil(t, db.Put([]byte("k1"), []byte("v1")))
+
+	getAsync := func(key []byte) chan []byte {
+		result := make(chan []byte, 1)
+		go func() {
+			val, err := db.Get(key)
+			assert.Nil(t, err)
+			result <- val
+		}()
+		return result
+	}
+	assertBlocked := func(done chan error) {
+		select {
+		case <-done:
+			t.Fatal("should wait for the pinned read")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	slow := newSlowReadIO(db.activeFile.IoManager)
+	db.activeFile.IoManager = slow
+	result := getAsync([]byte("k1"))
+	<-slow.started
+
+	// 读取文件期间不持有锁，写操作不会被阻塞
+	assert.Nil(t, db.Put([]byte("k2"), []byte("v2")))
+	assert.Nil(t, db.Delete([]byte("k2")))
+
+	// 封存活跃文件需要等待读取结束
+	mergeDone := make(chan error, 1)
+	go func() { mergeDone <- db.Merge() }()
+	assertBlocked(mergeDone)
+	close(slow.release)
+	assert.Nil(t, <-mergeDone)
+	assert.Equal(t, []byte("v1"), <-result)
+
+	// 关闭数据库同样需要等待读取结束
+	assert.Nil(t, db.Put([]byte("k3"), []byte("v3")))
+	slow = newSlowReadIO(db.activeFile.IoManager)
+	db.activeFile.IoManager = slow
+	result = getAsync([]byte("k3"))
+	<-slow.started
+	closeDone := make(chan error, 1)
+	go func() { closeDone <- db.Close() }()
+	assertBlocked(closeDone)
+	close(slow.release)
+	assert.Nil(t, <-closeDone)
+	assert.Equal(t, []byte("v3"), <-result)
+}
+
+// 只阻塞 limit 之前的读取，用于让记录本身直接读取，只阻塞读取它引用的数据
+type slowBeforeIO struct {
+	*slowReadIO
+	limit int64
+}
+
+func (s *slowBeforeIO) Read(b []byte, offset int64) (int, error) {
+	if offset >= s.limit {
+		return s.IOManager.Read(b, offset)
+	}
+	return s.slowReadIO.Read(b, offset)
+}
+
+func TestDB_ResolveWithoutLock(t *testing.T) {
+	value := bytes.Repeat([]byte("shared-value"), 200)
+	rotate := func(t *testing.T, db *DB) {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		assert.Nil(t, db.rotateActiveFileLocked())
+	}
+	slowOlderFile := func(db *DB) *slowReadIO {
+		slow := newSlowReadIO(db.olderFiles[0].IoManager)
+		db.olderFiles[0].IoManager = slow
+		return slow
+	}
+	slowBlobFile := func(db *DB) *slowReadIO {
+		slow := newSlowReadIO(db.blobFiles[0].IoManager)
+		db.blobFiles[0].IoManager = slow
+		return slow
+	}
+	readAll := func(db *DB, key []byte) ([]byte, error) {
+		reader, err := db.GetReader(key)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	}
+
+	tests := []struct {
+		name    string
+		options func(opts *Options)
+		// 写入数据，把被引用的数据所在文件的 IO 替换为阻塞的 IO
+		prepare func(t *testing.T, db *DB) *slowReadIO
+		read    func(db *DB) ([]byte, error)
+	}{
+		{
+			name:    "blob",
+			options: func(opts *Options) { opts.BlobThreshold = 1024 },
+			prepare: func(t *testing.T, db *DB) *slowReadIO {
+				assert.Nil(t, db.Put([]byte("key"), value))
+				return slowBlobFile(db)
+			},
+			read: func(db *DB) ([]byte, error) { return db.Get([]byte("key")) },
+		},
+		{
+			name:    "blob reader",
+			options: func(opts *Options) { opts.BlobThreshold = 1024 },
+			prepare: func(t *testing.T, db *DB) *slowReadIO {
+				assert.Nil(t, db.Put([]byte("key"), value))
+				return slowBlobFile(db)
+			},
+			read: func(db *DB) ([]byte, error) { return readAll(db, []byte("key")) },
+		},
+		{
+			name:    "dedup",
+			options: func(opts *Options) { opts.Dedup, opts.DedupThreshold = true, 64 },
+			prepare: func(t *testing.T, db *DB) *slowReadIO {
+				assert.Nil(t, db.Put([]byte("a"), value))
+				rotate(t, db)
+				assert.Nil(t, db.Put([]byte("key"), value))
+				return slowOlderFile(db)
+			},
+			read: func(db *DB) ([]byte, error) { return db.Get([]byte("key")) },
+		},
+		{
+			name:    "history",
+			options: func(opts *Options) { opts.KeepHistory = true },
+			prepare: func(t *testing.T, db *DB) *slowReadIO {
+				assert.Nil(t, db.Put([]byte("key"), value))
+				rotate(t, db)
+				assert.Nil(t, db.Put([]byte("key"), []byte("new-value")))
+				return slowOlderFile(db)
+			},
+			read: func(db *DB) ([]byte, error) { return db.GetAtSeq([]byte("key"), 1) },
+		},
+		{
+			name:    "stream",
+			options: func(opts *Options) { opts.StreamChunkSize = 1000 },
+			prepare: func(t *testing.T, db *DB) *slowReadIO {
+				assert.Nil(t, db.PutReader([]byte("key"), bytes.NewReader(value), int64(len(value))))
+				// 清单记录直接读取，只阻塞读取分块
+				manifestPos := db.index.Get([]byte("key"))
+				slow := &slowBeforeIO{slowReadIO: newSlowReadIO(db.activeFile.IoManager), limit: manifestPos.Offset}
+				db.activeFile.IoManager = slow
+				return slow.slowReadIO
+			},
+			read: func(db *DB) ([]byte, error) { return db.Get([]byte("key")) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions
+			opts.DirPath = t.TempDir()
+			tt.options(&opts)
+			db, err := Open(opts)
+			assert.Nil(t, err)
+			defer db.Close()
+
+			slow := tt.prepare(t, db)
+			result := make(chan []byte, 1)
+			go func() {
+				val, err := tt.read(db)
+				assert.Nil(t, err)
+				result <- val
+			}()
+			<-slow.started
+
+			// 读取被引用的数据期间不持有锁，写操作不会被阻塞
+			putDone := make(chan error, 1)
+			go func() { putDone <- db.Put([]byte("other"), []byte("value")) }()
+			select {
+			case err := <-putDone:
+				assert.Nil(t, err)
+			case <-time.After(time.Second):
+				t.Error("put should not wait for the read")
+			}
+			close(slow.release)
+			assert.Equal(t, value, <-result)
+		})
+	}
+}
+
+func TestDB_FoldWithoutLock(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	// fn 执行时不持有锁，可以在遍历过程中写入
+	err := db.Fold(func(key []byte, value []byte) bool {
+		assert.Equal(t, key, value)
+		assert.Nil(t, db.Put(append([]byte("copy-"), key...), value))
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 20, len(db.ListKeys()))
+}
+
+func TestDB_MergeClosesFiles(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("listing open files requires /proc")
+	}
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.DataFileSize = 8 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() { _ = db.Close() }()
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+
+	// merge 使用的临时实例和文件在 merge 结束之后全部关闭
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, db.Merge())
+	}
+	mergePath := db.getMergePath()
+	entries, err := os.ReadDir("/proc/self/fd")
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		target, err := os.Readlink(filepath.Join("/proc/self/fd", entry.Name()))
+		if err == nil {
+			assert.False(t, strings.HasPrefix(target, mergePath), "file %s is still open", target)
+		}
+	}
+}
+
+func TestDB_OpenValueLikeFooter(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	// 活跃文件的最后 28 个字节看起来像是 footer 的开头
+	value := make([]byte, data.FileFooterSize)
+	copy(value, []byte{0xf0, 0xa5, 0x7c, 0xb1})
+	assert.Nil(t, db.Put([]byte("k"), value))
+	assert.Nil(t, db.Close())
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer func() { _ = db.Close() }()
+	val, err := db.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+	assert.False(t, db.activeFile.Sealed())
+}
+
+// 按照最初的格式编码记录，header 中只有 crc、type、keySize 和 valueSize
+func encodeLegacyLogRecord(logRecord *data.LogRecord) []byte {
+	buf := make([]byte, crc32.Size+1+binary.MaxVarintLen32*2+len(logRecord.Key)+len(logRecord.Value))
+	buf[crc32.Size] = logRecord.Type
+	index := crc32.Size + 1
+	index += binary.PutVarint(buf[index:], int64(len(logRecord.Key)))
+	index += binary.PutVarint(buf[index:], int64(len(logRecord.Value)))
+	index += copy(buf[index:], logRecord.Key)
+	index += copy(buf[index:], logRecord.Value)
+	binary.LittleEndian.PutUint32(buf, crc32.ChecksumIEEE(buf[crc32.Size:index]))
+	return buf[:index]
+}
+
+func writeLegacyFile(t *testing.T, fileName string, records ...*data.LogRecord) {
+	t.Helper()
+	var buf []byte
+	for _, rec := range records {
+		buf = append(buf, encodeLegacyLogRecord(rec)...)
+	}
+	assert.Nil(t, os.WriteFile(fileName, buf, 0644))
+}
+
+// 最初的格式写入的数据目录：merge 之后的数据文件和 hint 文件、merge 完成标识，以及活跃的数据文件
+func TestDB_OpenLegacyFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeLegacyFile(t, data.GetDataFileName(dir, 0),
+		&data.LogRecord{Key: logRecordKeyWithSeq([]byte("merged"), nonTransactionSeqNo), Value: []byte("merged-value")})
+	writeLegacyFile(t, filepath.Join(dir, data.HintFileName),
+		&data.LogRecord{Key: []byte("merged"), Value: legacyLogRecordPos(0, 0)})
+	writeLegacyFile(t, filepath.Join(dir, data.MergeFinishedFileName),
+		&data.LogRecord{Key: []byte(mergeFinishedKey), Value: []byte("1")})
+	writeLegacyFile(t, data.GetDataFileName(dir, 1),
+		&data.LogRecord{Key: logRecordKeyWithSeq([]byte("key-1"), nonTransactionSeqNo), Value: []byte("value-1")},
+		&data.LogRecord{Key: logRecordKeyWithSeq([]byte("key-2"), nonTransactionSeqNo), Value: []byte("value-2")},
+		&data.LogRecord{Key: logRecordKeyWithSeq([]byte("key-2"), nonTransactionSeqNo), Type: data.LogRecordDeleted},
+		&data.LogRecord{Key: logRecordKeyWithSeq([]byte("key-3"), 1), Value: []byte("value-3")},
+		&data.LogRecord{Key: logRecordKeyWithSeq(txnFinKey, 1), Type: data.LogRecordTxnFinished},
+	)
+
+	opts := DefaultOptions
+	opts.DirPath = dir
+	opts.KeepHistory = true
+	check := func(db *DB) {
+		for key, value := range map[string]string{"merged": "merged-value", "key-1": "value-1", "key-3": "value-3", "key-4": "value-4"} {
+			val, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte(value), val)
+		}
+		_, err := db.Get([]byte("key-2"))
+		assert.Equal(t, ErrKeyNotFound, err)
+
+		// 最初格式的记录写入时间未知，merge 重写之后仍然未知
+		_, meta, err := db.GetWithMeta([]byte("key-1"))
+		assert.Nil(t, err)
+		assert.True(t, meta.Timestamp.IsZero())
+		_, meta, err = db.GetWithMeta([]byte("key-4"))
+		assert.Nil(t, err)
+		assert.False(t, meta.Timestamp.IsZero())
+		versions, err := db.History([]byte("key-1"), 0)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(versions))
+		assert.True(t, versions[0].Timestamp.IsZero())
+		// 写入时间未知的版本视为在所有时间点之前写入
+		val, err := db.GetAt([]byte("key-1"), time.Unix(0, 0))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("value-1"), val)
+	}
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, data.FileFormatLegacy, db.activeFile.Format())
+	// 新的记录写入新格式的文件，不会追加到旧格式的活跃文件中
+	assert.Nil(t, db.Put([]byte("key-4"), []byte("value-4")))
+	assert.Equal(t, uint32(2), db.activeFile.FileId)
+	assert.Equal(t, data.CurrentFileFormat, db.activeFile.Format())
+	check(db)
+	assert.Nil(t, db.Close())
+
+	// 旧格式的文件封存之后仍然可以读取
+	_, err = data.VerifyDataFile(data.GetDataFileName(dir, 1))
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db)
+
+	// merge 之后所有的数据都以新的格式重写
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	check(db)
+}
+
+// 最初的格式编码的位置信息，只有 fid 和 offset
+func legacyLogRecordPos(fid uint32, offset int64) []byte {
+	buf := binary.AppendVarint(nil, int64(fid))
+	return binary.AppendVarint(buf, offset)
+}
@@ -0,0 +1,169 @@
+package bitcask_kv_go
+
+import "bitcask-kv-go/data"
+
+// 去重之后共享的 value
+type dedupValue struct {
+	pos  *data.LogRecordPos // value 记录在数据文件中的位置
+	size int64              // value 的长度
+	refs int64              // 引用该 value 的 key 数量
+}
+
+// 批量写入时预留的共享 value 引用，key 为 value 的哈希，value 为预留的引用数量
+// 索引在所有记录写入之后才更新，预留的引用保证同一批次中相同的 value 只写入一次，
+// 并且更新索引的过程中，批次中其他 key 仍然要引用的 value 不会因为引用计数暂时降为 0 而被删除
+type dedupReservation map[string]int64
+
+// 根据配置对 value 去重，返回只保存 value 哈希和共享 value 位置的引用记录
+// 相同的 value 如果已经被其他 key 引用，则直接共享，否则先写入一条共享的 value 记录
+// 批量写入时 reserved 不为 nil，共享的 value 会被预留，更新索引之后需要调用 releaseDedupLocked 释放
+// 在访问此方法前必须持有互斥锁
+func (db *DB) dedupLogRecordLocked(logRecord *data.LogRecord, reserved dedupReservation) (*data.LogRecord, error) {
+	if !db.options.Dedup || logRecord.Type != data.LogRecordNormal ||
+		len(logRecord.Value) < db.options.DedupThreshold {
+		return logRecord, nil
+	}
+
+	hash := data.DedupHash(logRecord.Value)
+	// 没有被引用的 value 可能会在 merge 时被清理，因此需要重新写入
+	// 正在 merge 或者等待重新打开时应用 merge 结果的文件中的 value 也需要重新写入，否则引用中记录的位置会失效
+	entry := db.dedupValues[string(hash)]
+	if entry == nil || entry.refs <= 0 || entry.pos.Fid < db.dedupBoundary {
+		pos, err := db.appendLogRecordLocked(&data.LogRecord{
+			Key:   logRecordKeyWithSeq(hash, nonTransactionSeqNo),
+			Value: logRecord.Value,
+			Type:  data.LogRecordDedupValue,
+		})
+		if err != nil {
+			return nil, err
+		}
+		db.putDedupValueLocked(hash, pos, int64(len(logRecord.Value)))
+		entry = db.dedupValues[string(hash)]
+	}
+
+	ref := *logRecord
+	ref.Value = data.EncodeDedupRef(hash, entry.pos)
+	ref.Flags |= data.FlagDedupRef
+	if reserved != nil {
+		db.reserveDedupLocked(&ref, reserved)
+	}
+	return &ref, nil
+}
+
+// 为引用共享 value 的记录预留一个引用
+// 在访问此方法前必须持有互斥锁
+func (db *DB) reserveDedupLocked(logRecord *data.LogRecord, reserved dedupReservation) {
+	hash := dedupRefHash(logRecord)
+	if hash == nil {
+		return
+	}
+	if entry := db.dedupValues[string(hash)]; entry != nil {
+		entry.refs++
+		reserved[string(hash)]++
+	}
+}
+
+// 释放批量写入时预留的引用，引用计数降为 0 的 value 不再记录
+// 在访问此方法前必须持有互斥锁
+func (db *DB) releaseDedupLocked(reserved dedupReservation) {
+	for hash, n := range reserved {
+		entry := db.dedupValues[hash]
+		if entry == nil {
+			continue
+		}
+		entry.refs -= n
+		if entry.refs <= 0 {
+			delete(db.dedupValues, hash)
+		}
+	}
+}
+
+// 记录共享 value 的位置，已有的引用计数保持不变
+// 在访问此方法前必须持有互斥锁
+func (db *DB) putDedupValueLocked(hash []byte, pos *data.LogRecordPos, size int64) {
+	if entry := db.dedupValues[string(hash)]; entry != nil {
+		entry.pos = pos
+		entry.size = size
+		return
+	}
+	db.dedupValues[string(hash)] = &dedupValue{pos: pos, size: size}
+}
+
+// 更新 key 对共享 value 的引用，logRecord 为 key 最新写入的记录
+// 引用计数降为 0 的 value 不再记录，merge 时会被清理
+// 在访问此方法前必须持有互斥锁
+func (db *DB) updateDedupRefLocked(key []byte, logRecord *data.LogRecord) {
+	// 先增加新的引用，避免 key 重复写入相同的 value 时计数先降为 0
+	newHash := dedupRefHash(logRecord)
+	if newHash != nil {
+		if entry := db.dedupValues[string(newHash)]; entry != nil {
+			entry.refs++
+		} else {
+			newHash = nil
+		}
+	}
+
+	if oldHash, ok := db.dedupRefs[string(key)]; ok {
+		if entry := db.dedupValues[oldHash]; entry != nil {
+			entry.refs--
+			if entry.refs <= 0 {
+				delete(db.dedupValues, oldHash)
+			}
+		}
+		delete(db.dedupRefs, string(key))
+	}
+
+	if newHash != nil {
+		db.dedupRefs[string(key)] = string(newHash)
+	}
+}
+
+// 引用共享 value 的记录中 value 的哈希，不是引用记录时返回 nil
+// hint 文件中加载的引用只有哈希，没有共享 value 的位置
+func dedupRefHash(logRecord *data.LogRecord) []byte {
+	if logRecord.Type != data.LogRecordNormal || logRecord.Flags&data.FlagDedupRef == 0 ||
+		len(logRecord.Value) < data.DedupHashSize {
+		return nil
+	}
+	return logRecord.Value[:data.DedupHashSize]
+}
+
+// 如果 value 经过去重，则根据引用中记录的位置读取共享的 value
+// 共享的 value 在 merge 之前一直保留在数据文件中，key 被覆盖之后，之前打开的迭代器仍然可以读取
+// 在访问此方法前必须持有锁
+func (db *DB) resolveDedupLocked(logRecord *data.LogRecord) error {
+	if logRecord.Flags&data.FlagDedupRef == 0 {
+		return nil
+	}
+	_, pos, err := data.DecodeDedupRef(logRecord.Value)
+	if err != nil {
+		return err
+	}
+	valueRecord, err := db.readLogRecord(pos)
+	if err != nil {
+		return err
+	}
+	return setDedupValue(logRecord, valueRecord)
+}
+
+// 使用读取到的共享 value 替换引用记录中的 value
+func setDedupValue(logRecord, valueRecord *data.LogRecord) error {
+	if valueRecord.Type != data.LogRecordDedupValue {
+		return ErrDedupValueNotFound
+	}
+	logRecord.Value = valueRecord.Value
+	logRecord.Flags &^= data.FlagDedupRef
+	return nil
+}
+
+// 统计去重节省的空间，即被多个 key 共享的 value 少存储的字节数
+// 在访问此方法前必须持有锁
+func (db *DB) dedupSavedBytesLocked() int64 {
+	var saved int64
+	for _, entry := range db.dedupValues {
+		if entry.refs > 1 {
+			saved += (entry.refs - 1) * entry.size
+		}
+	}
+	return saved
+}
@@ -0,0 +1,242 @@
+package bitcask_kv_go
+
+import (
+	"bitcask-kv-go/data"
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func initDedupDB(t *testing.T) (*DB, Options) {
+	t.Helper()
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.Dedup = true
+	opts.DedupThreshold = 64
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	return db, opts
+}
+
+func TestDB_Dedup(t *testing.T) {
+	db, opts := initDedupDB(t)
+
+	config := bytes.Repeat([]byte(`{"default":"config"}`), 100)
+	assert.Nil(t, db.Put([]byte("a"), config))
+	sizeAfterFirst := db.activeFile.WriteOff
+	assert.Nil(t, db.Put([]byte("b"), config))
+	assert.Nil(t, db.Put([]byte("c"), config))
+	assert.Nil(t, db.Put([]byte("small"), []byte("value")))
+
+	// 相同的 value 只存储一份
+	assert.Less(t, db.activeFile.WriteOff-sizeAfterFirst, int64(len(config)))
+
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), stat.DedupValues)
+	assert.Equal(t, int64(2*len(config)), stat.DedupSavedBytes)
+
+	check := func(db *DB) {
+		for _, key := range []string{"a", "b", "c"} {
+			val, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, config, val)
+		}
+		val, err := db.Get([]byte("small"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("value"), val)
+	}
+	check(db)
+
+	// 重启之后引用计数依然正确
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	check(db2)
+	stat, err = db2.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2*len(config)), stat.DedupSavedBytes)
+
+	// 覆盖和删除会减少引用计数
+	assert.Nil(t, db2.Put([]byte("a"), []byte("new")))
+	assert.Nil(t, db2.Delete([]byte("b")))
+	stat, err = db2.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), stat.DedupValues)
+	assert.Equal(t, int64(0), stat.DedupSavedBytes)
+	assert.Nil(t, db2.Close())
+}
+
+func TestDB_DedupWriteBatch(t *testing.T) {
+	db, _ := initDedupDB(t)
+	defer db.Close()
+
+	value := bytes.Repeat([]byte("shared"), 20)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("a"), value))
+	assert.Nil(t, wb.Put([]byte("b"), value))
+	assert.Nil(t, wb.Commit())
+
+	for _, key := range []string{"a", "b"} {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(value)), stat.DedupSavedBytes)
+}
+
+func TestDB_DedupWriteBatchSameValue(t *testing.T) {
+	db, opts := initDedupDB(t)
+
+	// 同一个批次中相同的 value 只写入一次
+	value := bytes.Repeat([]byte("large"), 100)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("a"), value))
+	assert.Nil(t, wb.Put([]byte("b"), value))
+	assert.Nil(t, wb.Commit())
+	assert.Less(t, db.activeFile.WriteOff, int64(2*len(value)))
+
+	var dedupRecords int
+	var offset int64
+	for {
+		logRecord, size, err := db.activeFile.ReadLogRecord(offset)
+		if err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		if logRecord.Type == data.LogRecordDedupValue {
+			dedupRecords++
+		}
+		offset += size
+	}
+	assert.Equal(t, 1, dedupRecords)
+
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), stat.DedupValues)
+	assert.Equal(t, int64(len(value)), stat.DedupSavedBytes)
+
+	// 批次中一个 key 不再引用某个 value，另一个 key 开始引用它，更新索引的顺序不影响引用计数
+	other := bytes.Repeat([]byte("other"), 100)
+	for i := 0; i < 10; i++ {
+		wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+		assert.Nil(t, wb.Put([]byte("a"), other))
+		assert.Nil(t, wb.Put([]byte("b"), other))
+		assert.Nil(t, wb.Put([]byte(fmt.Sprintf("c-%d", i)), value))
+		assert.Nil(t, wb.Commit())
+
+		wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+		assert.Nil(t, wb.Put([]byte("a"), value))
+		assert.Nil(t, wb.Put([]byte("b"), value))
+		assert.Nil(t, wb.Delete([]byte(fmt.Sprintf("c-%d", i))))
+		assert.Nil(t, wb.Commit())
+	}
+
+	check := func(db *DB) {
+		for _, key := range []string{"a", "b"} {
+			val, err := db.Get([]byte(key))
+			assert.Nil(t, err)
+			assert.Equal(t, value, val)
+		}
+		stat, err := db.Stat()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(len(value)), stat.DedupSavedBytes)
+	}
+	check(db)
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	check(db)
+}
+
+func TestDB_DedupMerge(t *testing.T) {
+	db, opts := initDedupDB(t)
+
+	shared := bytes.Repeat([]byte("shared"), 20)
+	orphan := bytes.Repeat([]byte("orphan"), 20)
+	assert.Nil(t, db.Put([]byte("a"), shared))
+	assert.Nil(t, db.Put([]byte("b"), shared))
+	assert.Nil(t, db.Put([]byte("c"), orphan))
+	assert.Nil(t, db.Delete([]byte("c")))
+
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer db2.Close()
+
+	// 没有被引用的 value 在 merge 时被清理
+	assert.Equal(t, 1, len(db2.dedupValues))
+	for _, key := range []string{"a", "b"} {
+		val, err := db2.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, shared, val)
+	}
+	stat, err := db2.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(shared)), stat.DedupSavedBytes)
+
+	// merge 之后重新写入已被清理的 value
+	assert.Nil(t, db2.Put([]byte("c"), orphan))
+	val, err := db2.Get([]byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, orphan, val)
+}
+
+func TestDB_DedupStaleIterator(t *testing.T) {
+	db, _ := initDedupDB(t)
+	defer db.Close()
+
+	shared := bytes.Repeat([]byte("shared"), 20)
+	assert.Nil(t, db.Put([]byte("a"), shared))
+	assert.Nil(t, db.Put([]byte("b"), shared))
+
+	it := db.NewIterator(DefaultIteratorOptions)
+	defer it.Close()
+	// 迭代器打开之后覆盖所有的引用，共享 value 的引用计数降为 0
+	assert.Nil(t, db.Put([]byte("a"), []byte("new-a")))
+	assert.Nil(t, db.Put([]byte("b"), []byte("new-b")))
+	assert.Equal(t, 0, len(db.dedupValues))
+
+	// 共享的 value 在 merge 之前仍然在数据文件中，之前打开的迭代器可以读取
+	var count int
+	for it.Rewind(); it.Valid(); it.Next() {
+		val, err := it.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, shared, val)
+		count++
+	}
+	assert.Equal(t, 2, count)
+}
+
+func TestDB_DedupAfterMerge(t *testing.T) {
+	db, opts := initDedupDB(t)
+
+	shared := bytes.Repeat([]byte("shared"), 20)
+	// 被删除的 key 在 merge 时被清理，共享 value 在 merge 之后的位置和之前不同
+	assert.Nil(t, db.Put([]byte("deleted"), []byte("value")))
+	assert.Nil(t, db.Put([]byte("a"), shared))
+	assert.Nil(t, db.Delete([]byte("deleted")))
+	assert.Nil(t, db.Merge())
+
+	// merge 的结果在重新打开时才生效，新的引用不能指向参与 merge 的文件中的 value
+	assert.Nil(t, db.Put([]byte("b"), shared))
+	assert.Nil(t, db.Close())
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	for _, key := range []string{"a", "b"} {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, shared, val)
+	}
+}
@@ -0,0 +1,126 @@
+package bitcask_kv_go
+
+import "bitcask-kv-go/data"
+
+// KeyProvider 提供数据加密使用的密钥
+type KeyProvider interface {
+	// CurrentKeyId 当前用于加密新文件的密钥 id
+	CurrentKeyId() uint32
+
+	// Key 根据密钥 id 获取对应的密钥，长度为 16、24 或 32 字节，分别对应 AES-128、AES-192、AES-256
+	Key(keyId uint32) ([]byte, error)
+}
+
+// 打开数据文件，并根据文件头初始化加解密方式，新文件写入文件头
+func (db *DB) openDataFile(dirPath string, fileId uint32) (*data.DataFile, error) {
+	dataFile, err := data.OpenDataFile(dirPath, fileId)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.initFileHeader(dataFile); err != nil {
+		_ = dataFile.Close()
+		return nil, err
+	}
+	return dataFile, nil
+}
+
+// 以只读方式打开已经封存的旧数据文件，并校验文件末尾的 footer
+func (db *DB) openOlderDataFile(fileId uint32) (*data.DataFile, error) {
+	dataFile, err := data.OpenReadOnlyDataFile(db.options.DirPath, fileId)
+	if err != nil {
+		return nil, err
+	}
+	// 空文件没有文件头，也不需要解密
+	if dataFile.WriteOff > 0 {
+		if err := db.initFileHeader(dataFile); err != nil {
+			_ = dataFile.Close()
+			return nil, err
+		}
+	}
+	if db.fileHandles != nil {
+		if err := db.reopenReadOnly(dataFile); err != nil {
+			return nil, err
+		}
+	}
+	return dataFile, nil
+}
+
+// 初始化文件头和文件的加解密方式
+// 已有的文件根据文件头中的密钥 id 获取密钥，新文件写入带有格式版本的文件头，开启加密时使用当前的密钥
+func (db *DB) initFileHeader(dataFile *data.DataFile) error {
+	provider := db.options.Encryption.KeyProvider
+
+	// 已经有数据的文件，打开时已经读取了文件头
+	if dataFile.WriteOff > 0 {
+		header := dataFile.FileHeader()
+		// 没有文件头或者文件头中没有密钥，说明是未加密的文件
+		if header == nil || !header.Encrypted() {
+			return nil
+		}
+		if provider == nil {
+			return data.ErrEncryptionKeyRequired
+		}
+		c, err := newCipher(provider, header.KeyId)
+		if err != nil {
+			return err
+		}
+		if err := data.VerifyFileHeader(c, header); err != nil {
+			return err
+		}
+		dataFile.SetCipher(c)
+		return nil
+	}
+
+	// 新文件，没有开启加密时文件头中只有格式版本
+	var c *data.Cipher
+	if provider != nil {
+		var err error
+		if c, err = newCipher(provider, provider.CurrentKeyId()); err != nil {
+			return err
+		}
+	}
+	return dataFile.WriteFileHeader(c)
+}
+
+func newCipher(provider KeyProvider, keyId uint32) (*data.Cipher, error) {
+	key, err := provider.Key(keyId)
+	if err != nil {
+		return nil, err
+	}
+	return data.NewCipher(keyId, key)
+}
+
+// RotateEncryptionKey 切换到 KeyProvider 当前的密钥
+// 当前活跃的数据文件和 blob 文件会被转换为旧文件，之后的写入使用新的密钥
+// 旧的数据文件和 key 文件在下一次 merge 时使用新的密钥重写，旧的 blob 文件在下一次 GCBlobs 时重写
+func (db *DB) RotateEncryptionKey() error {
+	if db.options.Encryption.KeyProvider == nil {
+		return ErrEncryptionNotEnabled
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.activeBlobFile != nil && db.needsRekey(db.activeBlobFile) {
+		if err := db.setActiveBlobFileLocked(); err != nil {
+			return err
+		}
+	}
+	if db.activeFile == nil || !db.needsRekey(db.activeFile) {
+		return nil
+	}
+	if err := db.rotateActiveFileLocked(); err != nil {
+		return err
+	}
+	return db.finishWriteLocked()
+}
+
+// 判断文件是否需要使用当前的密钥重写，没有加密或者使用旧密钥加密的文件都需要重写
+func (db *DB) needsRekey(dataFile *data.DataFile) bool {
+	provider := db.options.Encryption.KeyProvider
+	if provider == nil {
+		return false
+	}
+	c := dataFile.Cipher()
+	return c == nil || c.KeyId != provider.CurrentKeyId()
+}
@@ -0,0 +1,187 @@
+package bitcask_kv_go
+
+import (
+	"bitcask-kv-go/data"
+	"bitcask-kv-go/utils"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 测试使用的密钥提供者
+type testKeyProvider struct {
+	current uint32
+	keys    map[uint32][]byte
+}
+
+func (p *testKeyProvider) CurrentKeyId() uint32 {
+	return p.current
+}
+
+func (p *testKeyProvider) Key(keyId uint32) ([]byte, error) {
+	key, ok := p.keys[keyId]
+	if !ok {
+		return nil, errors.New("key not found")
+	}
+	return key, nil
+}
+
+func TestDB_Encryption(t *testing.T) {
+	provider := &testKeyProvider{
+		current: 1,
+		keys:    map[uint32][]byte{1: bytes.Repeat([]byte("a"), 32)},
+	}
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.Encryption.KeyProvider = provider
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	secret := []byte("top-secret-value")
+	assert.Nil(t, db.Put([]byte("secret-key"), secret))
+	assert.Nil(t, db.Close())
+
+	// 数据文件中不能出现明文
+	raw, err := os.ReadFile(data.GetDataFileName(opts.DirPath, 0))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(raw, secret))
+	assert.False(t, bytes.Contains(raw, []byte("secret-key")))
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	val, err := db.Get([]byte("secret-key"))
+	assert.Nil(t, err)
+	assert.Equal(t, secret, val)
+	assert.Nil(t, db.Close())
+
+	t.Run("wrong key", func(t *testing.T) {
+		wrongOpts := opts
+		wrongOpts.Encryption.KeyProvider = &testKeyProvider{
+			current: 1,
+			keys:    map[uint32][]byte{1: bytes.Repeat([]byte("b"), 32)},
+		}
+		_, err := Open(wrongOpts)
+		assert.Equal(t, data.ErrDecryptFailed, err)
+	})
+
+	t.Run("missing key provider", func(t *testing.T) {
+		plainOpts := opts
+		plainOpts.Encryption.KeyProvider = nil
+		_, err := Open(plainOpts)
+		assert.Equal(t, data.ErrEncryptionKeyRequired, err)
+	})
+}
+
+func TestDB_RotateEncryptionKey(t *testing.T) {
+	provider := &testKeyProvider{
+		current: 1,
+		keys: map[uint32][]byte{
+			1: bytes.Repeat([]byte("a"), 32),
+			2: bytes.Repeat([]byte("b"), 16),
+		},
+	}
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.Encryption.KeyProvider = provider
+
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("value-1")))
+	}
+
+	// 在线切换密钥
+	provider.current = 2
+	assert.Nil(t, db.RotateEncryptionKey())
+	assert.Equal(t, uint32(2), db.activeFile.Cipher().KeyId)
+	for i := 5; i < 15; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("value-2")))
+	}
+
+	// merge 之后旧文件使用新的密钥重写，旧密钥可以下线
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	delete(provider.keys, 1)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+	for i := 0; i < 15; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		if i < 5 {
+			assert.Equal(t, []byte("value-1"), val)
+		} else {
+			assert.Equal(t, []byte("value-2"), val)
+		}
+	}
+
+	// hint 文件同样被加密
+	raw, err := os.ReadFile(filepath.Join(opts.DirPath, data.HintFileName))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(raw, utils.GetTestKey(0)))
+
+	t.Run("blob and key files", func(t *testing.T) {
+		provider := &testKeyProvider{
+			current: 1,
+			keys: map[uint32][]byte{
+				1: bytes.Repeat([]byte("a"), 32),
+				2: bytes.Repeat([]byte("b"), 16),
+			},
+		}
+		opts := DefaultOptions
+		opts.DirPath = t.TempDir()
+		opts.DataFileSize = 4 * 1024
+		opts.LowMemory = true
+		opts.BlobThreshold = 1024
+		opts.BlobFileSize = 32 * 1024
+		opts.Encryption.KeyProvider = provider
+
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		values := make(map[int][]byte)
+		for i := 0; i < 100; i++ {
+			values[i] = utils.RandomValue(64)
+			if i%5 == 0 {
+				values[i] = utils.RandomValue(2048)
+			}
+			assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+		}
+		assert.Greater(t, len(db.olderFiles), 1)
+		assert.Greater(t, len(db.blobFiles), 1)
+
+		// 切换密钥之后，活跃的 blob 文件同样使用新的密钥
+		provider.current = 2
+		assert.Nil(t, db.RotateEncryptionKey())
+		assert.Equal(t, uint32(2), db.activeBlobFile.Cipher().KeyId)
+
+		// merge 重写数据文件和 key 文件，GCBlobs 重写 blob 文件，之后旧密钥可以下线
+		assert.Nil(t, db.Merge())
+		assert.Nil(t, db.GCBlobs(1))
+		assert.Nil(t, db.Close())
+		delete(provider.keys, 1)
+
+		db, err = Open(opts)
+		assert.Nil(t, err)
+		defer db.Close()
+		for fid, blobFile := range db.blobFiles {
+			assert.Equal(t, uint32(2), blobFile.Cipher().KeyId, "blob file %d", fid)
+		}
+		for i := 0; i < 100; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, values[i], val)
+		}
+		assert.Equal(t, 100, len(db.ListKeys()))
+	})
+
+	t.Run("not enabled", func(t *testing.T) {
+		plainDB := initDB(t)
+		defer plainDB.Close()
+		assert.Equal(t, ErrEncryptionNotEnabled, plainDB.RotateEncryptionKey())
+	})
+}
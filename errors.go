@@ -3,13 +3,37 @@ package bitcask_kv_go
 import "errors"
 
 var (
-	ErrKeyIsEmpty             = errors.New("the key is empty")
-	ErrIndexUpdateFailed      = errors.New("failed to update index")
-	ErrKeyNotFound            = errors.New("key not found in database")
-	ErrDataFileNotFound       = errors.New("data file is not found")
-	ErrDataDirectoryCorrupted = errors.New("the database directory maybe corrupted")
-	ErrDatabaseDirPathIsEmpty = errors.New("database dir path is empty")
-	ErrDataFileSizeInvalid    = errors.New("database data file size must be greater than 0")
-	ErrExceedMaxBatchNum      = errors.New("exceed the max batch num")
-	ErrMergeIsProgress        = errors.New("merge is in progress, try again later")
+	ErrKeyIsEmpty              = errors.New("the key is empty")
+	ErrIndexUpdateFailed       = errors.New("failed to update index")
+	ErrKeyNotFound             = errors.New("key not found in database")
+	ErrDataFileNotFound        = errors.New("data file is not found")
+	ErrDataDirectoryCorrupted  = errors.New("the database directory maybe corrupted")
+	ErrDatabaseDirPathIsEmpty  = errors.New("database dir path is empty")
+	ErrDataFileSizeInvalid     = errors.New("database data file size must be greater than 0")
+	ErrExceedMaxBatchNum       = errors.New("exceed the max batch num")
+	ErrMergeIsProgress         = errors.New("merge is in progress, try again later")
+	ErrHistoryNotEnabled       = errors.New("key history is not enabled")
+	ErrHistoryRetentionInvalid = errors.New("history retention must not be negative")
+	ErrCompressionInvalid      = errors.New("invalid compression type or level")
+	ErrEncryptionNotEnabled    = errors.New("encryption is not enabled")
+	ErrBlobOptionsInvalid      = errors.New("blob threshold must not be negative and blob file size must be greater than 0")
+	ErrDiscardRatioInvalid     = errors.New("discard ratio must be in (0, 1]")
+	ErrDedupThresholdInvalid   = errors.New("dedup threshold must be greater than 0")
+	ErrDedupValueNotFound      = errors.New("the deduplicated value is not found")
+	ErrLowMemoryOptionsInvalid = errors.New("low memory mode requires a positive key file block size and does not support history or dedup")
+	ErrBPTreeOptionsInvalid    = errors.New("the b+ tree index does not support history or dedup")
+	ErrKeyIsTooLarge           = errors.New("the key is too large for the index")
+	ErrScanCursorInvalid       = errors.New("invalid scan cursor")
+	ErrScanLimitInvalid        = errors.New("scan limit must be greater than 0")
+	ErrKeyOnlyIterator         = errors.New("the iterator only contains keys")
+	ErrRangeDeleteInProgress   = errors.New("range delete is in progress, try again later")
+	ErrValueIsNotNumber        = errors.New("the value is not an encoded number")
+	ErrIncrOverflow            = errors.New("increment would overflow int64")
+	ErrIncrNotCommitted        = errors.New("the increment has not been committed")
+	ErrStreamChunkSizeInvalid  = errors.New("stream chunk size must be greater than 0")
+	ErrStreamSizeInvalid       = errors.New("stream size must not be negative")
+	ErrStreamWriteInProgress   = errors.New("stream write is in progress, try again later")
+	ErrStreamReaderClosed      = errors.New("the stream reader is closed")
+	ErrValueCacheSizeInvalid   = errors.New("value cache size must not be negative")
+	ErrMaxOpenFilesInvalid     = errors.New("max open files must not be negative")
 )
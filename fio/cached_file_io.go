@@ -0,0 +1,159 @@
+package fio
+
+import (
+	"container/list"
+	"errors"
+	"os"
+	"sync"
+)
+
+var (
+	ErrReadOnlyFile = errors.New("the file is read only")
+	ErrFileClosed   = errors.New("the file is closed")
+)
+
+// FileHandleCache 文件句柄缓存，限制同时打开的文件数量
+// 文件在读取时才打开，超出数量限制时关闭最久没有使用的文件；正在读取的文件不会被关闭，此时打开的文件数量可能暂时超出限制
+type FileHandleCache struct {
+	mu       sync.Mutex
+	capacity int
+	lru      *list.List // 队首为最近使用的文件，元素为 *fileHandle
+	handles  map[*CachedFileIO]*list.Element
+}
+
+// 打开的文件句柄
+type fileHandle struct {
+	owner   *CachedFileIO
+	fd      *os.File
+	refs    int  // 正在使用该句柄的读取数量
+	evicted bool // 是否已经从缓存中移除，最后一个读取结束之后关闭
+}
+
+// NewFileHandleCache 初始化文件句柄缓存，capacity 为最多同时打开的文件数量
+func NewFileHandleCache(capacity int) *FileHandleCache {
+	return &FileHandleCache{
+		capacity: capacity,
+		lru:      list.New(),
+		handles:  make(map[*CachedFileIO]*list.Element),
+	}
+}
+
+// OpenFiles 当前打开的文件数量
+func (c *FileHandleCache) OpenFiles() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// 获取文件的句柄，文件没有打开时以只读方式打开，使用完之后需要调用 release
+func (c *FileHandleCache) acquire(f *CachedFileIO) (*fileHandle, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if f.closed {
+		return nil, ErrFileClosed
+	}
+	if elem, ok := c.handles[f]; ok {
+		c.lru.MoveToFront(elem)
+		handle := elem.Value.(*fileHandle)
+		handle.refs++
+		return handle, nil
+	}
+
+	// 只读打开，文件已经被删除时返回错误，而不是创建一个空文件
+	fd, err := os.Open(f.fileName)
+	if err != nil {
+		return nil, err
+	}
+	handle := &fileHandle{owner: f, fd: fd, refs: 1}
+	c.handles[f] = c.lru.PushFront(handle)
+	c.evict()
+	return handle, nil
+}
+
+func (c *FileHandleCache) release(handle *fileHandle) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	handle.refs--
+	if handle.evicted && handle.refs == 0 {
+		return handle.fd.Close()
+	}
+	return nil
+}
+
+// 关闭超出数量限制的文件，跳过正在读取的文件
+func (c *FileHandleCache) evict() {
+	for elem := c.lru.Back(); elem != nil && c.lru.Len() > c.capacity; {
+		prev := elem.Prev()
+		if handle := elem.Value.(*fileHandle); handle.refs == 0 {
+			c.removeLocked(elem)
+		}
+		elem = prev
+	}
+}
+
+// 从缓存中移除文件句柄，没有正在进行的读取时直接关闭
+func (c *FileHandleCache) removeLocked(elem *list.Element) {
+	handle := elem.Value.(*fileHandle)
+	c.lru.Remove(elem)
+	delete(c.handles, handle.owner)
+	handle.evicted = true
+	if handle.refs == 0 {
+		_ = handle.fd.Close()
+	}
+}
+
+// 关闭文件，释放文件句柄
+func (c *FileHandleCache) close(f *CachedFileIO) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f.closed = true
+	if elem, ok := c.handles[f]; ok {
+		c.removeLocked(elem)
+	}
+}
+
+// CachedFileIO 通过文件句柄缓存读取的只读文件 IO，用于已经写满的旧数据文件
+type CachedFileIO struct {
+	fileName string
+	size     int64 // 文件大小，只读文件的大小不会改变
+	cache    *FileHandleCache
+	closed   bool // 由 cache 的锁保护
+}
+
+// NewCachedFileIOManager 初始化只读的文件 IO，文件在第一次读取时才打开
+func NewCachedFileIOManager(fileName string, size int64, cache *FileHandleCache) *CachedFileIO {
+	return &CachedFileIO{fileName: fileName, size: size, cache: cache}
+}
+
+func (fio *CachedFileIO) Read(bytes []byte, offset int64) (int, error) {
+	handle, err := fio.cache.acquire(fio)
+	if err != nil {
+		return 0, err
+	}
+	n, err := handle.fd.ReadAt(bytes, offset)
+	if releaseErr := fio.cache.release(handle); err == nil {
+		err = releaseErr
+	}
+	return n, err
+}
+
+func (fio *CachedFileIO) Write([]byte) (int, error) {
+	return 0, ErrReadOnlyFile
+}
+
+// Sync 只读文件不需要持久化
+func (fio *CachedFileIO) Sync() error {
+	return nil
+}
+
+func (fio *CachedFileIO) Close() error {
+	fio.cache.close(fio)
+	return nil
+}
+
+func (fio *CachedFileIO) Size() (int64, error) {
+	return fio.size, nil
+}
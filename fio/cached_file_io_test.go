@@ -0,0 +1,101 @@
+package fio
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newCachedTestFile(t *testing.T, dir string, name string, content string, cache *FileHandleCache) *CachedFileIO {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	assert.Nil(t, os.WriteFile(path, []byte(content), DataFilePerm))
+	return NewCachedFileIOManager(path, int64(len(content)), cache)
+}
+
+func TestCachedFileIO_Read(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewFileHandleCache(2)
+
+	files := make([]*CachedFileIO, 5)
+	for i := range files {
+		files[i] = newCachedTestFile(t, dir, fmt.Sprintf("%d.data", i), fmt.Sprintf("content-%d", i), cache)
+	}
+	// 读取之前不会打开文件
+	assert.Equal(t, 0, cache.OpenFiles())
+
+	for i, f := range files {
+		size, err := f.Size()
+		assert.Nil(t, err)
+		buf := make([]byte, size)
+		n, err := f.Read(buf, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, int(size), n)
+		assert.Equal(t, fmt.Sprintf("content-%d", i), string(buf))
+		assert.LessOrEqual(t, cache.OpenFiles(), 2)
+	}
+
+	// 只读文件不能写入
+	_, err := files[0].Write([]byte("x"))
+	assert.Equal(t, ErrReadOnlyFile, err)
+	assert.Nil(t, files[0].Sync())
+
+	// 关闭之后不能再读取
+	assert.Nil(t, files[4].Close())
+	_, err = files[4].Read(make([]byte, 1), 0)
+	assert.Equal(t, ErrFileClosed, err)
+	assert.Equal(t, 1, cache.OpenFiles())
+}
+
+func TestCachedFileIO_RemovedFile(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewFileHandleCache(1)
+	a := newCachedTestFile(t, dir, "a.data", "aaaa", cache)
+	b := newCachedTestFile(t, dir, "b.data", "bbbb", cache)
+
+	// a 被关闭之后删除，重新读取时返回错误而不是创建空文件
+	_, err := a.Read(make([]byte, 4), 0)
+	assert.Nil(t, err)
+	_, err = b.Read(make([]byte, 4), 0)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Remove(filepath.Join(dir, "a.data")))
+	_, err = a.Read(make([]byte, 4), 0)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "a.data"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCachedFileIO_ConcurrentRead(t *testing.T) {
+	dir := t.TempDir()
+	cache := NewFileHandleCache(3)
+	files := make([]*CachedFileIO, 10)
+	for i := range files {
+		files[i] = newCachedTestFile(t, dir, fmt.Sprintf("%d.data", i), fmt.Sprintf("%08d", i), cache)
+	}
+
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				idx := (g + i) % len(files)
+				buf := make([]byte, 8)
+				_, err := files[idx].Read(buf, 0)
+				assert.Nil(t, err)
+				assert.Equal(t, fmt.Sprintf("%08d", idx), string(buf))
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, cache.OpenFiles(), 3)
+
+	for _, f := range files {
+		assert.Nil(t, f.Close())
+	}
+	assert.Equal(t, 0, cache.OpenFiles())
+}
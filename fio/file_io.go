@@ -4,7 +4,8 @@ import "os"
 
 // 标准系统文件 IO
 type FileIO struct {
-	fd *os.File // 文件描述符
+	fd       *os.File // 文件描述符
+	readOnly bool     // 是否只读
 }
 
 // 初始化标准文件 IO
@@ -16,11 +17,23 @@ func NewFileIOManager(fileName string) (*FileIO, error) {
 	return &FileIO{fd: fd}, nil
 }
 
+// NewReadOnlyFileIOManager 以只读方式打开文件，文件不存在时返回错误
+func NewReadOnlyFileIOManager(fileName string) (*FileIO, error) {
+	fd, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &FileIO{fd: fd, readOnly: true}, nil
+}
+
 func (fio *FileIO) Read(bytes []byte, offset int64) (int, error) {
 	return fio.fd.ReadAt(bytes, offset)
 }
 
 func (fio *FileIO) Write(bytes []byte) (int, error) {
+	if fio.readOnly {
+		return 0, ErrReadOnlyFile
+	}
 	return fio.fd.Write(bytes)
 }
 
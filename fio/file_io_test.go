@@ -101,3 +101,26 @@ func TestFileIO_Close(t *testing.T) {
 	err = fio.Close()
 	assert.Nil(t, err)
 }
+
+func TestNewReadOnlyFileIOManager(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.data")
+	// 文件不存在时不会创建
+	_, err := NewReadOnlyFileIOManager(path)
+	assert.True(t, os.IsNotExist(err))
+
+	fio, err := NewFileIOManager(path)
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("bitcask kv"))
+	assert.Nil(t, err)
+	assert.Nil(t, fio.Close())
+
+	fio, err = NewReadOnlyFileIOManager(path)
+	assert.Nil(t, err)
+	defer fio.Close()
+	b := make([]byte, 7)
+	_, err = fio.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask"), b)
+	_, err = fio.Write([]byte("x"))
+	assert.Equal(t, ErrReadOnlyFile, err)
+}
@@ -0,0 +1,168 @@
+package bitcask_kv_go
+
+import (
+	"bitcask-kv-go/data"
+	"bytes"
+	"time"
+)
+
+// KeyVersion key 的一个历史版本
+type KeyVersion struct {
+	Value     []byte    // 该版本的 value，删除记录为空
+	Timestamp time.Time // 写入时间，以最初的格式写入的版本写入时间未知，为零值
+	Deleted   bool      // 是否为删除记录
+	Seq       uint64    // 写入时分配的版本号，可以作为 GetAtSeq 的参数
+}
+
+// History 获取 key 的历史版本，按写入时间从新到旧排列，limit <= 0 时返回所有保留的版本
+func (db *DB) History(key []byte, limit int) ([]*KeyVersion, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	if !db.options.KeepHistory {
+		return nil, ErrHistoryNotEnabled
+	}
+
+	var versions []*KeyVersion
+	err := db.walkHistory(key, func(logRecord *data.LogRecord) bool {
+		version := &KeyVersion{
+			Timestamp: recordTime(logRecord.Timestamp),
+			Deleted:   logRecord.Type == data.LogRecordDeleted,
+			Seq:       logRecord.Version,
+		}
+		if !version.Deleted {
+			version.Value = logRecord.Value
+		}
+		versions = append(versions, version)
+		return limit <= 0 || len(versions) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// GetAt 读取 key 在指定时间点的值，即该时间点之前写入的最后一个版本
+// 以最初的格式写入、写入时间未知的版本早于升级之后写入的所有版本，视为在所有时间点之前写入
+func (db *DB) GetAt(key []byte, at time.Time) ([]byte, error) {
+	return db.getAt(key, func(logRecord *data.LogRecord) bool {
+		return logRecord.Timestamp <= at.UnixNano()
+	})
+}
+
+// GetAtSeq 读取 key 在指定版本号时的值，即版本号小于等于 seq 的最后一个版本
+// 版本号在所有的 key 之间全局递增，可以通过 LastSeq 获取当前的版本号，之后使用它读取该时刻的一致的数据
+// 和 GetAt 相比不依赖系统时钟，同一时刻写入的多条记录也可以区分先后
+func (db *DB) GetAtSeq(key []byte, seq uint64) ([]byte, error) {
+	return db.getAt(key, func(logRecord *data.LogRecord) bool {
+		return logRecord.Version <= seq
+	})
+}
+
+// LastSeq 当前已经分配的最大的版本号
+func (db *DB) LastSeq() uint64 {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.version
+}
+
+// 沿着版本链找到第一个满足 match 的版本，返回其中的 value
+func (db *DB) getAt(key []byte, match func(logRecord *data.LogRecord) bool) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	if !db.options.KeepHistory {
+		return nil, ErrHistoryNotEnabled
+	}
+
+	var found *data.LogRecord
+	err := db.walkHistory(key, func(logRecord *data.LogRecord) bool {
+		if match(logRecord) {
+			found = logRecord
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil || found.Type == data.LogRecordDeleted {
+		return nil, ErrKeyNotFound
+	}
+	return found.Value, nil
+}
+
+// 从最新的版本开始沿着版本链遍历，fn 返回 false 时终止遍历，调用方不能持有锁
+// 只在取出版本链的起点时短暂地持有读锁，沿着版本链读取文件时不持有锁
+func (db *DB) walkHistory(key []byte, fn func(logRecord *data.LogRecord) bool) error {
+	db.mu.RLock()
+	pos := db.historyHeadLocked(key)
+	mergeBoundary := db.mergeBoundary
+	relocated, hasRelocation := db.relocations[string(key)]
+	blobPins := db.pinBlobsLocked()
+	db.mu.RUnlock()
+	defer blobPins.Done()
+
+	for pos != nil {
+		logRecord, err := db.readLogRecordUnlocked(pos)
+		if err != nil {
+			// 更早的版本所在的文件已经被 merge 清理
+			if err == ErrDataFileNotFound {
+				return nil
+			}
+			return err
+		}
+		// 范围删除标记中没有记录上一个版本，作为删除记录返回之后结束遍历
+		if logRecord.Type == data.LogRecordRangeDeleted {
+			logRecord.Type, logRecord.Value = data.LogRecordDeleted, nil
+			fn(logRecord)
+			return nil
+		}
+		// 版本链只会指向同一个 key 的记录，否则说明链已经失效
+		if realKey, _ := parseLogRecordKey(logRecord.Key); !bytes.Equal(realKey, key) {
+			return nil
+		}
+		// 读取共享或者存储在 blob 文件中的 value，这些 value 被回收之后更早的版本不再可读
+		if logRecord.Type != data.LogRecordDeleted {
+			if err := db.resolveValueUnlocked(logRecord); err != nil {
+				if err == ErrDataFileNotFound || err == ErrDedupValueNotFound {
+					return nil
+				}
+				return err
+			}
+		}
+		if !fn(logRecord) {
+			return nil
+		}
+
+		prev := logRecord.Prev
+		// 跨越 merge 边界的指针需要重定位到 merge 后的最新版本
+		if prev != nil && pos.Fid >= mergeBoundary && prev.Fid < mergeBoundary && hasRelocation {
+			prev = relocated
+		}
+		pos = prev
+	}
+	return nil
+}
+
+// 获取 key 最新版本的位置，包括已经被删除的 key
+// 在访问此方法前必须持有锁
+func (db *DB) historyHeadLocked(key []byte) *data.LogRecordPos {
+	if pos := db.index.Get(key); pos != nil {
+		return pos
+	}
+	return db.deletedHeads[string(key)]
+}
+
+// 写入新的版本后，更新已删除 key 的版本链起点
+// 在访问此方法前必须持有互斥锁
+func (db *DB) updateHistoryHeadLocked(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
+	if !db.options.KeepHistory {
+		return
+	}
+	if typ == data.LogRecordDeleted {
+		db.deletedHeads[string(key)] = pos
+	} else {
+		delete(db.deletedHeads, string(key))
+	}
+}
@@ -0,0 +1,185 @@
+package bitcask_kv_go
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func initHistoryDB(t *testing.T, retention time.Duration) (*DB, Options) {
+	t.Helper()
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.KeepHistory = true
+	opts.HistoryRetention = retention
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	return db, opts
+}
+
+func TestDB_History(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		db := initDB(t)
+		defer db.Close()
+
+		_, err := db.History([]byte("key"), 0)
+		assert.Equal(t, ErrHistoryNotEnabled, err)
+		_, err = db.GetAt([]byte("key"), time.Now())
+		assert.Equal(t, ErrHistoryNotEnabled, err)
+		_, err = db.GetAtSeq([]byte("key"), db.LastSeq())
+		assert.Equal(t, ErrHistoryNotEnabled, err)
+	})
+
+	db, opts := initHistoryDB(t, time.Hour)
+	key := []byte("key")
+
+	seq0 := db.LastSeq()
+	assert.Nil(t, db.Put(key, []byte("v1")))
+	t1, seq1 := time.Now(), db.LastSeq()
+	assert.Nil(t, db.Put([]byte("other"), []byte("other-value")))
+	assert.Nil(t, db.Put(key, []byte("v2")))
+	t2, seq2 := time.Now(), db.LastSeq()
+	assert.Nil(t, db.Delete(key))
+	t3, seq3 := time.Now(), db.LastSeq()
+	assert.Nil(t, db.Put(key, []byte("v3")))
+
+	check := func(t *testing.T, db *DB) {
+		versions, err := db.History(key, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, 4, len(versions))
+		assert.Equal(t, []byte("v3"), versions[0].Value)
+		assert.True(t, versions[1].Deleted)
+		assert.Equal(t, []byte("v2"), versions[2].Value)
+		assert.Equal(t, []byte("v1"), versions[3].Value)
+		assert.False(t, versions[0].Timestamp.Before(versions[3].Timestamp))
+
+		versions, err = db.History(key, 2)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(versions))
+
+		val, err := db.GetAt(key, t1)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v1"), val)
+		val, err = db.GetAt(key, t2)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v2"), val)
+		_, err = db.GetAt(key, t3)
+		assert.Equal(t, ErrKeyNotFound, err)
+		_, err = db.GetAt(key, t1.Add(-time.Hour))
+		assert.Equal(t, ErrKeyNotFound, err)
+
+		// 按照版本号读取
+		assert.Equal(t, seq3, versions[1].Seq)
+		val, err = db.GetAtSeq(key, seq1)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v1"), val)
+		// 写入 other 占用的版本号在 key 的两个版本之间
+		val, err = db.GetAtSeq(key, seq1+1)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v1"), val)
+		val, err = db.GetAtSeq(key, seq2)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v2"), val)
+		_, err = db.GetAtSeq(key, seq3)
+		assert.Equal(t, ErrKeyNotFound, err)
+		_, err = db.GetAtSeq(key, seq0)
+		assert.Equal(t, ErrKeyNotFound, err)
+		val, err = db.GetAtSeq(key, db.LastSeq())
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v3"), val)
+	}
+	check(t, db)
+
+	// 重启之后版本链仍然可用
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer db2.Close()
+	check(t, db2)
+}
+
+func TestDB_HistoryWriteBatch(t *testing.T) {
+	db, _ := initHistoryDB(t, time.Hour)
+	defer db.Close()
+
+	key := []byte("key")
+	assert.Nil(t, db.Put(key, []byte("v1")))
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(key, []byte("v2")))
+	assert.Nil(t, wb.Commit())
+
+	versions, err := db.History(key, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(versions))
+	assert.Equal(t, []byte("v2"), versions[0].Value)
+	assert.Equal(t, []byte("v1"), versions[1].Value)
+}
+
+func TestDB_HistoryMerge(t *testing.T) {
+	t.Run("keep history within retention", func(t *testing.T) {
+		db, opts := initHistoryDB(t, time.Hour)
+
+		key := []byte("key")
+		deletedKey := []byte("deleted-key")
+		assert.Nil(t, db.Put(key, []byte("v1")))
+		assert.Nil(t, db.Put(key, []byte("v2")))
+		assert.Nil(t, db.Put(deletedKey, []byte("v1")))
+		assert.Nil(t, db.Delete(deletedKey))
+
+		assert.Nil(t, db.Merge())
+		// merge 过程中写入的新版本指向 merge 之前的位置
+		assert.Nil(t, db.Put(key, []byte("v3")))
+		assert.Nil(t, db.Close())
+
+		db2, err := Open(opts)
+		assert.Nil(t, err)
+		defer db2.Close()
+
+		versions, err := db2.History(key, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(versions))
+		assert.Equal(t, []byte("v3"), versions[0].Value)
+		assert.Equal(t, []byte("v2"), versions[1].Value)
+		assert.Equal(t, []byte("v1"), versions[2].Value)
+
+		versions, err = db2.History(deletedKey, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(versions))
+		assert.True(t, versions[0].Deleted)
+
+		// 重新写入已删除的 key，版本链接上删除记录
+		assert.Nil(t, db2.Put(deletedKey, []byte("v2")))
+		versions, err = db2.History(deletedKey, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(versions))
+	})
+
+	t.Run("drop expired history", func(t *testing.T) {
+		db, opts := initHistoryDB(t, 0)
+
+		key := []byte("key")
+		assert.Nil(t, db.Put(key, []byte("v1")))
+		assert.Nil(t, db.Put(key, []byte("v2")))
+		assert.Nil(t, db.Put([]byte("deleted-key"), []byte("v1")))
+		assert.Nil(t, db.Delete([]byte("deleted-key")))
+
+		assert.Nil(t, db.Merge())
+		assert.Nil(t, db.Close())
+
+		db2, err := Open(opts)
+		assert.Nil(t, err)
+		defer db2.Close()
+
+		versions, err := db2.History(key, 0)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(versions))
+		assert.Equal(t, []byte("v2"), versions[0].Value)
+
+		versions, err = db2.History([]byte("deleted-key"), 0)
+		assert.Nil(t, err)
+		assert.Empty(t, versions)
+	})
+}
@@ -0,0 +1,943 @@
+package index
+
+import (
+	"bitcask-kv-go/data"
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const (
+	// BPlusTreeFileName B+ 树索引文件的名称
+	BPlusTreeFileName = "bptree-index"
+
+	// 页大小
+	bptreePageSize = 4096
+
+	// BPTreeMaxKeySize key 的最大长度，保证每个页至少可以存放三个 key
+	BPTreeMaxKeySize = 1024
+
+	// 页缓存中最多缓存的节点数量
+	bptreeCacheSize = 256
+
+	// 未提交的修改超过这个数量时，需要尽快提交
+	bptreeCommitThreshold = 1024
+
+	bptreeMagic = 0xb7ee1d0e
+)
+
+// 页类型
+const (
+	bptreeLeafPage byte = iota + 1
+	bptreeBranchPage
+	bptreeFreelistPage
+)
+
+var (
+	ErrBPlusTreeCorrupted = errors.New("the b+ tree index file is corrupted")
+)
+
+// 页头，类型 (1字节) + 数量 (2字节)
+const bptreePageHeaderSize = 3
+
+// 空闲页的页头，类型 (1字节) + 数量 (2字节) + 下一个空闲页 (8字节)
+const bptreeFreelistHeaderSize = bptreePageHeaderSize + 8
+
+// BPlusTree 持久化在磁盘上的 B+ 树索引
+// 修改时使用写时复制，被修改的节点写入新的页，提交时先写入所有修改过的页，再写入元信息页，
+// 两个元信息页交替使用，保证索引文件在任何时候崩溃都能恢复到最近一次提交的状态
+type BPlusTree struct {
+	lock  *sync.RWMutex
+	file  *os.File
+	cache *bptreeCache
+
+	txid       uint64 // 最近一次提交的事务 id
+	root       uint64 // 根节点所在的页，0 表示空树
+	highWater  uint64 // 文件中下一个未使用的页
+	size       int    // key 的数量
+	checkpoint []byte // 最近一次提交时由调用方记录的检查点
+
+	dirty         map[uint64]*bptreeNode // 上次提交之后修改过的节点
+	free          []uint64               // 可以复用的空闲页
+	pendingFree   []uint64               // 本次提交之后才能复用的页
+	freelistPages []uint64               // 最近一次提交的空闲页列表所在的页
+	version       uint64                 // 每次修改时递增，迭代器据此判断是否需要重新定位
+}
+
+// B+ 树的节点，叶子节点存储 key 和位置，分支节点存储每个子节点的最小 key 和子节点所在的页
+type bptreeNode struct {
+	pgid     uint64
+	leaf     bool
+	keys     [][]byte
+	poses    []*data.LogRecordPos
+	children []uint64
+}
+
+// 元信息页
+type bptreeMeta struct {
+	txid       uint64
+	root       uint64
+	highWater  uint64
+	freelist   uint64
+	size       uint64
+	checkpoint []byte
+}
+
+// 打开 B+ 树索引，索引文件不存在时会新建
+func NewBPlusTree(dirPath string) (*BPlusTree, error) {
+	file, err := os.OpenFile(filepath.Join(dirPath, BPlusTreeFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	bpt := &BPlusTree{
+		lock:      new(sync.RWMutex),
+		file:      file,
+		cache:     newBPTreeCache(bptreeCacheSize),
+		highWater: 2, // 前两个页为元信息页
+		dirty:     make(map[uint64]*bptreeNode),
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if stat.Size() == 0 {
+		return bpt, nil
+	}
+	if err := bpt.load(); err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return bpt, nil
+}
+
+// 读取最新的有效元信息页，并加载空闲页列表
+func (bpt *BPlusTree) load() error {
+	var meta *bptreeMeta
+	for slot := uint64(0); slot < 2; slot++ {
+		buf, err := bpt.readPage(slot)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if m := decodeBPTreeMeta(buf); m != nil && (meta == nil || m.txid > meta.txid) {
+			meta = m
+		}
+	}
+	if meta == nil {
+		return ErrBPlusTreeCorrupted
+	}
+	bpt.txid = meta.txid
+	bpt.root = meta.root
+	bpt.highWater = meta.highWater
+	bpt.size = int(meta.size)
+	bpt.checkpoint = meta.checkpoint
+
+	for pgid := meta.freelist; pgid != 0; {
+		buf, err := bpt.readPage(pgid)
+		if err != nil {
+			return err
+		}
+		if buf[0] != bptreeFreelistPage {
+			return ErrBPlusTreeCorrupted
+		}
+		count := int(binary.BigEndian.Uint16(buf[1:]))
+		for i := 0; i < count; i++ {
+			bpt.free = append(bpt.free, binary.BigEndian.Uint64(buf[bptreeFreelistHeaderSize+i*8:]))
+		}
+		bpt.freelistPages = append(bpt.freelistPages, pgid)
+		pgid = binary.BigEndian.Uint64(buf[bptreePageHeaderSize:])
+	}
+	return nil
+}
+
+func (bpt *BPlusTree) Put(key []byte, pos *data.LogRecordPos) bool {
+	if len(key) == 0 || len(key) > BPTreeMaxKeySize || pos == nil {
+		return false
+	}
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
+
+	key = append([]byte(nil), key...)
+	pos = &data.LogRecordPos{Fid: pos.Fid, Offset: pos.Offset, Size: pos.Size, Version: pos.Version}
+
+	// 空树，新建根节点
+	if bpt.root == 0 {
+		root := &bptreeNode{pgid: bpt.allocate(), leaf: true}
+		root.keys = [][]byte{key}
+		root.poses = []*data.LogRecordPos{pos}
+		bpt.dirty[root.pgid] = root
+		bpt.root = root.pgid
+		bpt.size++
+		bpt.version++
+		return true
+	}
+
+	path, err := bpt.searchPath(key)
+	if err != nil {
+		return false
+	}
+	bpt.copyPath(path)
+
+	leaf := path[len(path)-1]
+	idx, found := leaf.node.search(key)
+	if found {
+		leaf.node.poses[idx] = pos
+	} else {
+		leaf.node.keys = insertAt(leaf.node.keys, idx, key)
+		leaf.node.poses = insertAt(leaf.node.poses, idx, pos)
+		bpt.size++
+		bpt.splitPath(path)
+	}
+	bpt.version++
+	return true
+}
+
+// Get 读取节点失败时视为 key 不存在，需要区分时使用 GetErr
+func (bpt *BPlusTree) Get(key []byte) *data.LogRecordPos {
+	pos, _ := bpt.GetErr(key)
+	return pos
+}
+
+// GetErr 根据 key 取出对应的索引位置信息，读取节点失败时返回错误
+func (bpt *BPlusTree) GetErr(key []byte) (*data.LogRecordPos, error) {
+	if len(key) == 0 {
+		return nil, nil
+	}
+	bpt.lock.RLock()
+	defer bpt.lock.RUnlock()
+
+	if bpt.root == 0 {
+		return nil, nil
+	}
+	path, err := bpt.searchPath(key)
+	if err != nil {
+		return nil, err
+	}
+	leaf := path[len(path)-1].node
+	if idx, found := leaf.search(key); found {
+		return leaf.poses[idx], nil
+	}
+	return nil, nil
+}
+
+func (bpt *BPlusTree) Delete(key []byte) bool {
+	if len(key) == 0 {
+		return false
+	}
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
+
+	if bpt.root == 0 {
+		return false
+	}
+	path, err := bpt.searchPath(key)
+	if err != nil {
+		return false
+	}
+	if _, found := path[len(path)-1].node.search(key); !found {
+		return false
+	}
+	bpt.copyPath(path)
+
+	leaf := path[len(path)-1].node
+	idx, _ := leaf.search(key)
+	leaf.keys = removeAt(leaf.keys, idx)
+	leaf.poses = removeAt(leaf.poses, idx)
+	bpt.size--
+
+	// 节点为空时从父节点中移除，不合并未满的节点
+	for level := len(path) - 1; level > 0 && len(path[level].node.keys) == 0; level-- {
+		bpt.release(path[level].node.pgid)
+		parent := path[level-1]
+		parent.node.keys = removeAt(parent.node.keys, parent.idx)
+		parent.node.children = removeAt(parent.node.children, parent.idx)
+	}
+	// 根节点为空，或者只有一个子节点时，降低树的高度
+	for {
+		root, err := bpt.node(bpt.root)
+		if err != nil {
+			break
+		}
+		if root.leaf && len(root.keys) == 0 {
+			bpt.release(root.pgid)
+			bpt.root = 0
+		} else if !root.leaf && len(root.children) == 1 {
+			bpt.release(root.pgid)
+			bpt.root = root.children[0]
+			continue
+		}
+		break
+	}
+	bpt.version++
+	return true
+}
+
+func (bpt *BPlusTree) Size() int {
+	bpt.lock.RLock()
+	defer bpt.lock.RUnlock()
+	return bpt.size
+}
+
+func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
+	return &bptreeIterator{tree: bpt, reverse: reverse}
+}
+
+// Checkpoint 获取最近一次提交时记录的检查点
+func (bpt *BPlusTree) Checkpoint() []byte {
+	bpt.lock.RLock()
+	defer bpt.lock.RUnlock()
+	return bpt.checkpoint
+}
+
+// NeedCommit 未提交的修改是否已经较多，需要尽快提交
+func (bpt *BPlusTree) NeedCommit() bool {
+	bpt.lock.RLock()
+	defer bpt.lock.RUnlock()
+	return len(bpt.dirty) >= bptreeCommitThreshold
+}
+
+// Commit 持久化所有的修改，同时记录调用方的检查点，用于在重新打开时确定索引已经包含了哪些数据
+func (bpt *BPlusTree) Commit(checkpoint []byte) error {
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
+
+	if len(bpt.dirty) == 0 && len(bpt.pendingFree) == 0 && bytes.Equal(checkpoint, bpt.checkpoint) && bpt.txid > 0 {
+		return nil
+	}
+
+	// 写入所有修改过的节点
+	for pgid, node := range bpt.dirty {
+		if err := bpt.writePage(pgid, node.encode()); err != nil {
+			return err
+		}
+	}
+
+	// 写入新的空闲页列表，上一次提交的空闲页列表所在的页同样可以复用
+	freelistHead, free, freelistPages, err := bpt.writeFreelist()
+	if err != nil {
+		return err
+	}
+	if err := bpt.file.Sync(); err != nil {
+		return err
+	}
+
+	// 最后写入元信息页
+	meta := &bptreeMeta{
+		txid:       bpt.txid + 1,
+		root:       bpt.root,
+		highWater:  bpt.highWater,
+		freelist:   freelistHead,
+		size:       uint64(bpt.size),
+		checkpoint: append([]byte(nil), checkpoint...),
+	}
+	if err := bpt.writePage(meta.txid%2, meta.encode()); err != nil {
+		return err
+	}
+	if err := bpt.file.Sync(); err != nil {
+		return err
+	}
+
+	bpt.txid = meta.txid
+	bpt.checkpoint = meta.checkpoint
+	bpt.free = free
+	bpt.pendingFree = nil
+	bpt.freelistPages = freelistPages
+	for pgid, node := range bpt.dirty {
+		bpt.cache.put(pgid, node)
+	}
+	bpt.dirty = make(map[uint64]*bptreeNode)
+	return nil
+}
+
+// Close 关闭索引文件，未提交的修改会被丢弃
+func (bpt *BPlusTree) Close() error {
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
+	return bpt.file.Close()
+}
+
+// 写入空闲页列表，返回空闲页列表的第一个页、提交之后可以复用的页，以及空闲页列表所在的页
+// 空闲页列表优先存放在当前可以复用的页中，这些页没有被上一次提交的索引引用
+func (bpt *BPlusTree) writeFreelist() (uint64, []uint64, []uint64, error) {
+	const perPage = (bptreePageSize - bptreeFreelistHeaderSize) / 8
+	reusable := bpt.free
+	count := func() int {
+		return len(reusable) + len(bpt.pendingFree) + len(bpt.freelistPages)
+	}
+
+	// 计算需要的页数，用于存放空闲页列表的页不再是空闲页
+	var pages []uint64
+	for (count()+perPage-1)/perPage > len(pages) {
+		if n := len(reusable); n > 0 {
+			pages = append(pages, reusable[n-1])
+			reusable = reusable[:n-1]
+		} else {
+			pages = append(pages, bpt.highWater)
+			bpt.highWater++
+		}
+	}
+	free := make([]uint64, 0, count())
+	free = append(free, reusable...)
+	free = append(free, bpt.pendingFree...)
+	free = append(free, bpt.freelistPages...)
+
+	for i, pgid := range pages {
+		end := (i + 1) * perPage
+		if end > len(free) {
+			end = len(free)
+		}
+		ids := free[i*perPage : end]
+		buf := make([]byte, bptreePageSize)
+		buf[0] = bptreeFreelistPage
+		binary.BigEndian.PutUint16(buf[1:], uint16(len(ids)))
+		if i+1 < len(pages) {
+			binary.BigEndian.PutUint64(buf[bptreePageHeaderSize:], pages[i+1])
+		}
+		for j, id := range ids {
+			binary.BigEndian.PutUint64(buf[bptreeFreelistHeaderSize+j*8:], id)
+		}
+		if err := bpt.writePage(pgid, buf); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+	if len(pages) == 0 {
+		return 0, free, nil, nil
+	}
+	return pages[0], free, pages, nil
+}
+
+// 分配一个新的页
+func (bpt *BPlusTree) allocate() uint64 {
+	if n := len(bpt.free); n > 0 {
+		pgid := bpt.free[n-1]
+		bpt.free = bpt.free[:n-1]
+		return pgid
+	}
+	pgid := bpt.highWater
+	bpt.highWater++
+	return pgid
+}
+
+// 释放一个页，本次提交中新分配的页可以直接复用，已提交的页需要等到提交之后才能复用
+func (bpt *BPlusTree) release(pgid uint64) {
+	if _, ok := bpt.dirty[pgid]; ok {
+		delete(bpt.dirty, pgid)
+		bpt.free = append(bpt.free, pgid)
+		return
+	}
+	bpt.cache.remove(pgid)
+	bpt.pendingFree = append(bpt.pendingFree, pgid)
+}
+
+// 根据页 id 获取节点，依次从修改过的节点、缓存和磁盘中查找
+func (bpt *BPlusTree) node(pgid uint64) (*bptreeNode, error) {
+	if node, ok := bpt.dirty[pgid]; ok {
+		return node, nil
+	}
+	if node := bpt.cache.get(pgid); node != nil {
+		return node, nil
+	}
+	buf, err := bpt.readPage(pgid)
+	if err != nil {
+		return nil, err
+	}
+	node, err := decodeBPTreeNode(pgid, buf)
+	if err != nil {
+		return nil, err
+	}
+	bpt.cache.put(pgid, node)
+	return node, nil
+}
+
+// 查找路径上的一个节点，idx 为下一层子节点的下标
+type bptreePathElem struct {
+	node *bptreeNode
+	idx  int
+}
+
+// 从根节点查找 key 所在的叶子节点，返回经过的所有节点
+func (bpt *BPlusTree) searchPath(key []byte) ([]*bptreePathElem, error) {
+	var path []*bptreePathElem
+	pgid := bpt.root
+	for {
+		node, err := bpt.node(pgid)
+		if err != nil {
+			return nil, err
+		}
+		if node.leaf {
+			return append(path, &bptreePathElem{node: node}), nil
+		}
+		idx := node.childIndex(key)
+		path = append(path, &bptreePathElem{node: node, idx: idx})
+		pgid = node.children[idx]
+	}
+}
+
+// 写时复制，路径上没有修改过的节点复制到新的页中，并更新父节点中的指针
+func (bpt *BPlusTree) copyPath(path []*bptreePathElem) {
+	for i, elem := range path {
+		if _, ok := bpt.dirty[elem.node.pgid]; !ok {
+			clone := elem.node.clone(bpt.allocate())
+			bpt.release(elem.node.pgid)
+			bpt.dirty[clone.pgid] = clone
+			elem.node = clone
+		}
+		if i == 0 {
+			bpt.root = elem.node.pgid
+		} else {
+			path[i-1].node.children[path[i-1].idx] = elem.node.pgid
+		}
+	}
+}
+
+// 从叶子节点开始向上分裂超过页大小的节点
+func (bpt *BPlusTree) splitPath(path []*bptreePathElem) {
+	for level := len(path) - 1; level >= 0; level-- {
+		node := path[level].node
+		if node.size() <= bptreePageSize {
+			return
+		}
+		right := node.split(bpt.allocate())
+		bpt.dirty[right.pgid] = right
+
+		if level == 0 {
+			// 根节点分裂，树的高度加一
+			root := &bptreeNode{
+				pgid:     bpt.allocate(),
+				keys:     [][]byte{node.keys[0], right.keys[0]},
+				children: []uint64{node.pgid, right.pgid},
+			}
+			bpt.dirty[root.pgid] = root
+			bpt.root = root.pgid
+			return
+		}
+		parent := path[level-1]
+		parent.node.keys = insertAt(parent.node.keys, parent.idx+1, right.keys[0])
+		parent.node.children = insertAt(parent.node.children, parent.idx+1, right.pgid)
+	}
+}
+
+func (bpt *BPlusTree) readPage(pgid uint64) ([]byte, error) {
+	buf := make([]byte, bptreePageSize)
+	n, err := bpt.file.ReadAt(buf, int64(pgid)*bptreePageSize)
+	if err != nil && !(err == io.EOF && n > 0) {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (bpt *BPlusTree) writePage(pgid uint64, buf []byte) error {
+	_, err := bpt.file.WriteAt(buf, int64(pgid)*bptreePageSize)
+	return err
+}
+
+// 在节点中查找 key，返回第一个大于等于 key 的下标以及是否找到
+func (n *bptreeNode) search(key []byte) (int, bool) {
+	idx := sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], key) >= 0
+	})
+	return idx, idx < len(n.keys) && bytes.Equal(n.keys[idx], key)
+}
+
+// 分支节点中可能包含 key 的子节点，即最小 key 小于等于 key 的最后一个子节点
+func (n *bptreeNode) childIndex(key []byte) int {
+	idx := sort.Search(len(n.keys), func(i int) bool {
+		return bytes.Compare(n.keys[i], key) > 0
+	}) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return idx
+}
+
+func (n *bptreeNode) clone(pgid uint64) *bptreeNode {
+	return &bptreeNode{
+		pgid:     pgid,
+		leaf:     n.leaf,
+		keys:     append([][]byte(nil), n.keys...),
+		poses:    append([]*data.LogRecordPos(nil), n.poses...),
+		children: append([]uint64(nil), n.children...),
+	}
+}
+
+// 将节点按照编码后的大小分成两半，返回右半部分
+func (n *bptreeNode) split(pgid uint64) *bptreeNode {
+	total, half := n.size(), bptreePageHeaderSize
+	mid := 1
+	for ; mid < len(n.keys)-1; mid++ {
+		half += n.entrySize(mid - 1)
+		if half >= total/2 {
+			break
+		}
+	}
+	right := &bptreeNode{
+		pgid: pgid,
+		leaf: n.leaf,
+		keys: append([][]byte(nil), n.keys[mid:]...),
+	}
+	n.keys = n.keys[:mid:mid]
+	if n.leaf {
+		right.poses = append([]*data.LogRecordPos(nil), n.poses[mid:]...)
+		n.poses = n.poses[:mid:mid]
+	} else {
+		right.children = append([]uint64(nil), n.children[mid:]...)
+		n.children = n.children[:mid:mid]
+	}
+	return right
+}
+
+// 编码后的节点大小
+func (n *bptreeNode) size() int {
+	size := bptreePageHeaderSize
+	for i := range n.keys {
+		size += n.entrySize(i)
+	}
+	return size
+}
+
+func (n *bptreeNode) entrySize(i int) int {
+	var buf [binary.MaxVarintLen64]byte
+	size := binary.PutUvarint(buf[:], uint64(len(n.keys[i]))) + len(n.keys[i])
+	if n.leaf {
+		size += binary.PutUvarint(buf[:], uint64(n.poses[i].Fid))
+		size += binary.PutVarint(buf[:], n.poses[i].Offset)
+		size += binary.PutUvarint(buf[:], uint64(n.poses[i].Size))
+		size += binary.PutUvarint(buf[:], n.poses[i].Version)
+	} else {
+		size += binary.PutUvarint(buf[:], n.children[i])
+	}
+	return size
+}
+
+// 节点编码
+// | type (1字节) | count (2字节) | keySize (变长) | key | fid offset size version 或者子节点页 id (变长) | ...
+func (n *bptreeNode) encode() []byte {
+	buf := make([]byte, bptreePageSize)
+	buf[0] = bptreeBranchPage
+	if n.leaf {
+		buf[0] = bptreeLeafPage
+	}
+	binary.BigEndian.PutUint16(buf[1:], uint16(len(n.keys)))
+	index := bptreePageHeaderSize
+	for i, key := range n.keys {
+		index += binary.PutUvarint(buf[index:], uint64(len(key)))
+		index += copy(buf[index:], key)
+		if n.leaf {
+			index += binary.PutUvarint(buf[index:], uint64(n.poses[i].Fid))
+			index += binary.PutVarint(buf[index:], n.poses[i].Offset)
+			index += binary.PutUvarint(buf[index:], uint64(n.poses[i].Size))
+			index += binary.PutUvarint(buf[index:], n.poses[i].Version)
+		} else {
+			index += binary.PutUvarint(buf[index:], n.children[i])
+		}
+	}
+	return buf
+}
+
+func decodeBPTreeNode(pgid uint64, buf []byte) (*bptreeNode, error) {
+	if buf[0] != bptreeLeafPage && buf[0] != bptreeBranchPage {
+		return nil, ErrBPlusTreeCorrupted
+	}
+	node := &bptreeNode{pgid: pgid, leaf: buf[0] == bptreeLeafPage}
+	count := int(binary.BigEndian.Uint16(buf[1:]))
+	index := bptreePageHeaderSize
+	for i := 0; i < count; i++ {
+		keySize, n := binary.Uvarint(buf[index:])
+		if n <= 0 || index+n+int(keySize) > len(buf) {
+			return nil, ErrBPlusTreeCorrupted
+		}
+		index += n
+		node.keys = append(node.keys, buf[index:index+int(keySize)])
+		index += int(keySize)
+		if node.leaf {
+			fid, n := binary.Uvarint(buf[index:])
+			index += n
+			offset, m := binary.Varint(buf[index:])
+			index += m
+			size, k := binary.Uvarint(buf[index:])
+			index += k
+			version, v := binary.Uvarint(buf[index:])
+			index += v
+			if n <= 0 || m <= 0 || k <= 0 || v <= 0 {
+				return nil, ErrBPlusTreeCorrupted
+			}
+			node.poses = append(node.poses, &data.LogRecordPos{Fid: uint32(fid), Offset: offset, Size: uint32(size), Version: version})
+		} else {
+			child, n := binary.Uvarint(buf[index:])
+			if n <= 0 {
+				return nil, ErrBPlusTreeCorrupted
+			}
+			index += n
+			node.children = append(node.children, child)
+		}
+	}
+	return node, nil
+}
+
+// 元信息编码
+// | magic (4字节) | txid | root | highWater | freelist | size (各 8 字节) | checkpointSize (2字节) | checkpoint | crc (4字节) |
+func (m *bptreeMeta) encode() []byte {
+	buf := make([]byte, bptreePageSize)
+	binary.BigEndian.PutUint32(buf[0:], bptreeMagic)
+	binary.BigEndian.PutUint64(buf[4:], m.txid)
+	binary.BigEndian.PutUint64(buf[12:], m.root)
+	binary.BigEndian.PutUint64(buf[20:], m.highWater)
+	binary.BigEndian.PutUint64(buf[28:], m.freelist)
+	binary.BigEndian.PutUint64(buf[36:], m.size)
+	binary.BigEndian.PutUint16(buf[44:], uint16(len(m.checkpoint)))
+	end := 46 + copy(buf[46:], m.checkpoint)
+	binary.BigEndian.PutUint32(buf[end:], crc32.ChecksumIEEE(buf[:end]))
+	return buf
+}
+
+// 解码元信息页，无效时返回 nil
+func decodeBPTreeMeta(buf []byte) *bptreeMeta {
+	if len(buf) < bptreePageSize || binary.BigEndian.Uint32(buf[0:]) != bptreeMagic {
+		return nil
+	}
+	checkpointSize := int(binary.BigEndian.Uint16(buf[44:]))
+	end := 46 + checkpointSize
+	if end+crc32.Size > len(buf) || crc32.ChecksumIEEE(buf[:end]) != binary.BigEndian.Uint32(buf[end:]) {
+		return nil
+	}
+	return &bptreeMeta{
+		txid:       binary.BigEndian.Uint64(buf[4:]),
+		root:       binary.BigEndian.Uint64(buf[12:]),
+		highWater:  binary.BigEndian.Uint64(buf[20:]),
+		freelist:   binary.BigEndian.Uint64(buf[28:]),
+		size:       binary.BigEndian.Uint64(buf[36:]),
+		checkpoint: append([]byte(nil), buf[46:end]...),
+	}
+}
+
+func insertAt[T any](s []T, idx int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[idx+1:], s[idx:])
+	s[idx] = v
+	return s
+}
+
+func removeAt[T any](s []T, idx int) []T {
+	return append(s[:idx], s[idx+1:]...)
+}
+
+// 已提交节点的 LRU 缓存
+type bptreeCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[uint64]*list.Element
+}
+
+func newBPTreeCache(capacity int) *bptreeCache {
+	return &bptreeCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[uint64]*list.Element),
+	}
+}
+
+func (c *bptreeCache) get(pgid uint64) *bptreeNode {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[pgid]; ok {
+		c.ll.MoveToFront(elem)
+		return elem.Value.(*bptreeNode)
+	}
+	return nil
+}
+
+func (c *bptreeCache) put(pgid uint64, node *bptreeNode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[pgid]; ok {
+		elem.Value = node
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.items[pgid] = c.ll.PushFront(node)
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*bptreeNode).pgid)
+	}
+}
+
+func (c *bptreeCache) remove(pgid uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[pgid]; ok {
+		c.ll.Remove(elem)
+		delete(c.items, pgid)
+	}
+}
+
+// B+ 树索引迭代器，按需从磁盘中读取节点
+// 迭代过程中索引被修改时，根据当前的 key 重新定位；读取节点失败时迭代器失效，错误通过 Err 返回
+type bptreeIterator struct {
+	tree    *BPlusTree
+	reverse bool
+	version uint64
+	stack   []*bptreePathElem
+	key     []byte
+	pos     *data.LogRecordPos
+	err     error
+}
+
+func (it *bptreeIterator) Rewind() {
+	it.tree.lock.RLock()
+	defer it.tree.lock.RUnlock()
+	it.seekLocked(nil)
+}
+
+func (it *bptreeIterator) Seek(key []byte) {
+	it.tree.lock.RLock()
+	defer it.tree.lock.RUnlock()
+	it.seekLocked(key)
+}
+
+func (it *bptreeIterator) Next() {
+	if it.key == nil {
+		return
+	}
+	it.tree.lock.RLock()
+	defer it.tree.lock.RUnlock()
+
+	if it.version != it.tree.version {
+		// 索引已被修改，从当前的 key 重新定位，并跳过当前的 key
+		current := it.key
+		it.seekLocked(current)
+		if it.key != nil && bytes.Equal(it.key, current) {
+			it.stepLocked()
+		}
+		return
+	}
+	it.stepLocked()
+}
+
+// 定位到第一个大于等于（反向遍历时为小于等于）key 的位置，key 为 nil 时定位到起点
+func (it *bptreeIterator) seekLocked(key []byte) {
+	it.version = it.tree.version
+	it.stack = it.stack[:0]
+	it.key, it.pos, it.err = nil, nil, nil
+	if it.tree.root == 0 {
+		return
+	}
+
+	pgid := it.tree.root
+	for {
+		node, err := it.tree.node(pgid)
+		if err != nil {
+			it.stack = nil
+			it.err = err
+			return
+		}
+		var idx int
+		switch {
+		case !node.leaf && key == nil && it.reverse:
+			idx = len(node.children) - 1
+		case !node.leaf && key == nil:
+			idx = 0
+		case !node.leaf:
+			idx = node.childIndex(key)
+		case key == nil && it.reverse:
+			idx = len(node.keys) - 1
+		case key == nil:
+			idx = 0
+		default:
+			var found bool
+			idx, found = node.search(key)
+			if it.reverse && !found {
+				idx--
+			}
+		}
+		it.stack = append(it.stack, &bptreePathElem{node: node, idx: idx})
+		if node.leaf {
+			break
+		}
+		pgid = node.children[idx]
+	}
+	it.settleLocked()
+}
+
+// 移动到下一个位置
+func (it *bptreeIterator) stepLocked() {
+	top := it.stack[len(it.stack)-1]
+	if it.reverse {
+		top.idx--
+	} else {
+		top.idx++
+	}
+	it.settleLocked()
+}
+
+// 当前叶子节点已经遍历完时，移动到相邻的叶子节点，并更新当前的 key
+func (it *bptreeIterator) settleLocked() {
+	it.key, it.pos = nil, nil
+	for len(it.stack) > 0 {
+		top := it.stack[len(it.stack)-1]
+		count := len(top.node.keys)
+		if top.idx >= 0 && top.idx < count {
+			if top.node.leaf {
+				it.key = top.node.keys[top.idx]
+				it.pos = top.node.poses[top.idx]
+				return
+			}
+			// 进入子节点的起点
+			child, err := it.tree.node(top.node.children[top.idx])
+			if err != nil {
+				it.stack = nil
+				it.err = err
+				return
+			}
+			idx := 0
+			if it.reverse {
+				idx = len(child.keys) - 1
+			}
+			it.stack = append(it.stack, &bptreePathElem{node: child, idx: idx})
+			continue
+		}
+		// 当前节点已经遍历完，回到父节点
+		it.stack = it.stack[:len(it.stack)-1]
+		if len(it.stack) == 0 {
+			return
+		}
+		parent := it.stack[len(it.stack)-1]
+		if it.reverse {
+			parent.idx--
+		} else {
+			parent.idx++
+		}
+	}
+}
+
+// Err 遍历过程中读取节点遇到的错误
+func (it *bptreeIterator) Err() error {
+	return it.err
+}
+
+func (it *bptreeIterator) Valid() bool {
+	return it.key != nil
+}
+
+func (it *bptreeIterator) Key() []byte {
+	return it.key
+}
+
+func (it *bptreeIterator) Value() *data.LogRecordPos {
+	return it.pos
+}
+
+func (it *bptreeIterator) Close() {
+	it.stack = nil
+	it.key, it.pos = nil, nil
+}
@@ -0,0 +1,140 @@
+package index
+
+import (
+	"bitcask-kv-go/data"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBPlusTree_PutGetDelete(t *testing.T) {
+	dir := t.TempDir()
+	bpt, err := NewBPlusTree(dir)
+	assert.Nil(t, err)
+
+	assert.False(t, bpt.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 1}))
+	assert.False(t, bpt.Put(make([]byte, BPTreeMaxKeySize+1), &data.LogRecordPos{Fid: 1, Offset: 1}))
+	assert.Nil(t, bpt.Get([]byte("not-exist")))
+	assert.False(t, bpt.Delete([]byte("not-exist")))
+
+	// 足够多的 key，保证节点发生分裂
+	const n = 20000
+	for i := 0; i < n; i++ {
+		assert.True(t, bpt.Put([]byte(fmt.Sprintf("key-%06d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)}))
+	}
+	assert.Equal(t, n, bpt.Size())
+	assert.True(t, bpt.Put([]byte("key-000010"), &data.LogRecordPos{Fid: 2, Offset: 100}))
+	assert.Equal(t, n, bpt.Size())
+	for i := 0; i < n; i += 2 {
+		assert.True(t, bpt.Delete([]byte(fmt.Sprintf("key-%06d", i))))
+	}
+	assert.Equal(t, n/2, bpt.Size())
+
+	check := func(bpt *BPlusTree) {
+		assert.Equal(t, n/2, bpt.Size())
+		assert.Nil(t, bpt.Get([]byte("key-000010")))
+		for i := 1; i < n; i += 998 {
+			pos := bpt.Get([]byte(fmt.Sprintf("key-%06d", i)))
+			assert.NotNil(t, pos)
+			assert.Equal(t, int64(i), pos.Offset)
+		}
+	}
+	check(bpt)
+
+	// 提交之后重新打开
+	assert.Nil(t, bpt.Commit([]byte("checkpoint")))
+	assert.Nil(t, bpt.Close())
+	bpt2, err := NewBPlusTree(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("checkpoint"), bpt2.Checkpoint())
+	check(bpt2)
+
+	// 未提交的修改在重新打开之后丢弃
+	assert.True(t, bpt2.Put([]byte("uncommitted"), &data.LogRecordPos{Fid: 3, Offset: 1}))
+	assert.Nil(t, bpt2.Close())
+	bpt3, err := NewBPlusTree(dir)
+	assert.Nil(t, err)
+	defer bpt3.Close()
+	assert.Nil(t, bpt3.Get([]byte("uncommitted")))
+	check(bpt3)
+
+	// 删除所有的 key
+	for i := 1; i < n; i += 2 {
+		assert.True(t, bpt3.Delete([]byte(fmt.Sprintf("key-%06d", i))))
+	}
+	assert.Equal(t, 0, bpt3.Size())
+	assert.Nil(t, bpt3.Get([]byte("key-000001")))
+}
+
+func TestBPlusTree_ReusePages(t *testing.T) {
+	bpt, err := NewBPlusTree(t.TempDir())
+	assert.Nil(t, err)
+	defer bpt.Close()
+
+	for i := 0; i < 1000; i++ {
+		bpt.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	assert.Nil(t, bpt.Commit(nil))
+
+	// 反复更新相同的 key，文件大小保持稳定
+	var highWater uint64
+	for round := 0; round < 20; round++ {
+		for i := 0; i < 1000; i += 10 {
+			bpt.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 2, Offset: int64(round)})
+		}
+		assert.Nil(t, bpt.Commit(nil))
+		if round == 5 {
+			highWater = bpt.highWater
+		}
+	}
+	assert.Equal(t, highWater, bpt.highWater)
+}
+
+func TestBPlusTree_Iterator(t *testing.T) {
+	bpt, err := NewBPlusTree(t.TempDir())
+	assert.Nil(t, err)
+	defer bpt.Close()
+
+	iter := bpt.Iterator(false)
+	iter.Rewind()
+	assert.False(t, iter.Valid())
+
+	var keys []string
+	for _, i := range rand.Perm(5000) {
+		key := fmt.Sprintf("key-%05d", i*2)
+		keys = append(keys, key)
+		bpt.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	sort.Strings(keys)
+
+	iter = bpt.Iterator(false)
+	var got []string
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		got = append(got, string(iter.Key()))
+	}
+	assert.Equal(t, keys, got)
+
+	iter.Seek([]byte("key-00101"))
+	assert.Equal(t, "key-00102", string(iter.Key()))
+
+	reverse := bpt.Iterator(true)
+	reverse.Seek([]byte("key-00101"))
+	assert.Equal(t, "key-00100", string(reverse.Key()))
+	reverse.Seek([]byte("a"))
+	assert.False(t, reverse.Valid())
+	reverse.Rewind()
+	assert.Equal(t, keys[len(keys)-1], string(reverse.Key()))
+
+	// 迭代过程中修改索引
+	iter.Seek([]byte("key-00200"))
+	bpt.Delete([]byte("key-00202"))
+	bpt.Put([]byte("key-00201"), &data.LogRecordPos{Fid: 2, Offset: 0})
+	iter.Next()
+	assert.Equal(t, "key-00201", string(iter.Key()))
+	iter.Next()
+	assert.Equal(t, "key-00204", string(iter.Key()))
+	iter.Close()
+}
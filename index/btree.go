@@ -3,7 +3,6 @@ package index
 import (
 	"bitcask-kv-go/data"
 	"bytes"
-	"sort"
 	"sync"
 
 	"github.com/google/btree"
@@ -65,63 +64,54 @@ func (bt *BTree) Size() int {
 	return bt.tree.Len()
 }
 
+// Iterator 基于 BTree 的写时复制快照创建迭代器，创建的开销与索引中的数据量无关
 func (bt *BTree) Iterator(reverse bool) Iterator {
 	if bt.tree == nil {
 		return nil
 	}
-	bt.lock.RLock()
-	defer bt.lock.RUnlock()
-	return newBTreeIterator(bt.tree, reverse)
+	// Clone 会修改原树的写时复制上下文，需要和写操作互斥
+	bt.lock.Lock()
+	snapshot := bt.tree.Clone()
+	bt.lock.Unlock()
+	return newBTreeIterator(snapshot, reverse)
 }
 
+// 迭代器每次从快照中读取的数据条数
+const btreeIteratorBatchSize = 64
+
 // BTree 索引迭代器
+// 在树的快照上按批次读取数据，快照不受后续写操作的影响，迭代的开销只和实际遍历的数据量相关
 type btreeIterator struct {
-	currIndex int     // 当前遍历的下标位置
-	reverse   bool    // 是否是反向遍历
-	values    []*Item // key+位置索引信息
+	tree      *btree.BTree // 创建迭代器时索引的快照
+	reverse   bool         // 是否是反向遍历
+	currIndex int          // 当前批次中遍历的下标位置
+	values    []*Item      // 当前批次的 key+位置索引信息
+	exhausted bool         // 快照中是否已经没有更多的数据
 }
 
 func newBTreeIterator(tree *btree.BTree, reverse bool) *btreeIterator {
-	// 创建一个长度为 0，容量为 tree.Len() 的切片，避免 nil 元素
-	values := make([]*Item, 0, tree.Len())
-	if reverse {
-		// 将所有键值对按降序加载到 values 切片中
-		tree.Descend(func(item btree.Item) bool {
-			values = append(values, item.(*Item))
-			return true
-		})
-	} else {
-		// 所有键值对按升序加载到一个切片 values 中
-		tree.Ascend(func(item btree.Item) bool {
-			values = append(values, item.(*Item))
-			return true
-		})
-	}
-	return &btreeIterator{
-		currIndex: 0,
-		reverse:   reverse,
-		values:    values,
+	bti := &btreeIterator{
+		tree:    tree,
+		reverse: reverse,
+		values:  make([]*Item, 0, btreeIteratorBatchSize),
 	}
+	bti.Rewind()
+	return bti
 }
 
 func (bti *btreeIterator) Rewind() {
-	bti.currIndex = 0
+	bti.load(nil, true)
 }
 
 func (bti *btreeIterator) Seek(key []byte) {
-	if bti.reverse {
-		bti.currIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) <= 0
-		})
-	} else {
-		bti.currIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) >= 0
-		})
-	}
+	bti.load(key, true)
 }
 
 func (bti *btreeIterator) Next() {
 	bti.currIndex += 1
+	if bti.currIndex >= len(bti.values) && !bti.exhausted && len(bti.values) > 0 {
+		bti.load(bti.values[len(bti.values)-1].key, false)
+	}
 }
 
 func (bti *btreeIterator) Valid() bool {
@@ -137,5 +127,35 @@ func (bti *btreeIterator) Value() *data.LogRecordPos {
 }
 
 func (bti *btreeIterator) Close() {
+	bti.tree = nil
 	bti.values = nil
 }
+
+// 从 start 开始（start 为 nil 时从头开始）读取下一批数据，inclusive 表示是否包含 start 本身
+func (bti *btreeIterator) load(start []byte, inclusive bool) {
+	bti.currIndex = 0
+	bti.values = bti.values[:0]
+	if bti.tree == nil {
+		return
+	}
+	fn := func(it btree.Item) bool {
+		item := it.(*Item)
+		if !inclusive && bytes.Equal(item.key, start) {
+			return true
+		}
+		bti.values = append(bti.values, item)
+		return len(bti.values) < btreeIteratorBatchSize
+	}
+
+	switch {
+	case start == nil && bti.reverse:
+		bti.tree.Descend(fn)
+	case start == nil:
+		bti.tree.Ascend(fn)
+	case bti.reverse:
+		bti.tree.DescendLessOrEqual(&Item{key: start}, fn)
+	default:
+		bti.tree.AscendGreaterOrEqual(&Item{key: start}, fn)
+	}
+	bti.exhausted = len(bti.values) < btreeIteratorBatchSize
+}
@@ -2,6 +2,7 @@ package index
 
 import (
 	"bitcask-kv-go/data"
+	"fmt"
 	"strconv"
 	"sync"
 	"testing"
@@ -184,3 +185,49 @@ func TestBTree_Iterator(t *testing.T) {
 		assert.Equal(t, []byte("ccde"), iter.Key())
 	})
 }
+
+func TestBTree_IteratorBatches(t *testing.T) {
+	bt := NewBTree()
+	const n = btreeIteratorBatchSize*3 + 7
+	for i := 0; i < n; i++ {
+		bt.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	t.Run("Forward", func(t *testing.T) {
+		iter := bt.Iterator(false)
+		defer iter.Close()
+		count := 0
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			assert.Equal(t, fmt.Sprintf("key-%04d", count), string(iter.Key()))
+			assert.Equal(t, int64(count), iter.Value().Offset)
+			count++
+		}
+		assert.Equal(t, n, count)
+	})
+
+	t.Run("Reverse seek across batches", func(t *testing.T) {
+		iter := bt.Iterator(true)
+		defer iter.Close()
+		iter.Seek([]byte("key-0100"))
+		count := 0
+		for ; iter.Valid(); iter.Next() {
+			assert.Equal(t, fmt.Sprintf("key-%04d", 100-count), string(iter.Key()))
+			count++
+		}
+		assert.Equal(t, 101, count)
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		iter := bt.Iterator(false)
+		defer iter.Close()
+		// 创建迭代器之后的写入对迭代器不可见
+		bt.Put([]byte("key-0000-new"), &data.LogRecordPos{Fid: 2})
+		bt.Delete([]byte("key-0100"))
+		count := 0
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			count++
+		}
+		assert.Equal(t, n, count)
+		assert.Equal(t, n, bt.Size())
+	})
+}
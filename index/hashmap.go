@@ -0,0 +1,162 @@
+package index
+
+import (
+	"bitcask-kv-go/data"
+	"bytes"
+	"hash/maphash"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// 哈希索引的分片数量
+const hashMapShards = 64
+
+// HashMap 分片哈希索引，只适合点查的场景
+// key 按照哈希值分布到多个分片中，每个分片使用独立的读写锁，减少并发写入时的锁竞争
+// 哈希索引本身是无序的，迭代时需要先把所有的 key 复制出来排序，开销和 key 的数量成正比
+type HashMap struct {
+	seed   maphash.Seed
+	shards [hashMapShards]hashMapShard
+	size   atomic.Int64
+}
+
+type hashMapShard struct {
+	lock  sync.RWMutex
+	items map[string]*data.LogRecordPos
+}
+
+// 新建 HashMap 索引结构
+func NewHashMap() *HashMap {
+	hm := &HashMap{seed: maphash.MakeSeed()}
+	for i := range hm.shards {
+		hm.shards[i].items = make(map[string]*data.LogRecordPos)
+	}
+	return hm
+}
+
+func (hm *HashMap) Put(key []byte, pos *data.LogRecordPos) bool {
+	if len(key) == 0 {
+		return false
+	}
+	shard := hm.shard(key)
+	shard.lock.Lock()
+	if _, ok := shard.items[string(key)]; !ok {
+		hm.size.Add(1)
+	}
+	shard.items[string(key)] = pos
+	shard.lock.Unlock()
+	return true
+}
+
+func (hm *HashMap) Get(key []byte) *data.LogRecordPos {
+	if len(key) == 0 {
+		return nil
+	}
+	shard := hm.shard(key)
+	shard.lock.RLock()
+	pos := shard.items[string(key)]
+	shard.lock.RUnlock()
+	return pos
+}
+
+func (hm *HashMap) Delete(key []byte) bool {
+	if len(key) == 0 {
+		return false
+	}
+	shard := hm.shard(key)
+	shard.lock.Lock()
+	_, ok := shard.items[string(key)]
+	if ok {
+		delete(shard.items, string(key))
+		hm.size.Add(-1)
+	}
+	shard.lock.Unlock()
+	return ok
+}
+
+func (hm *HashMap) Size() int {
+	return int(hm.size.Load())
+}
+
+// Iterator 哈希索引没有顺序，第一次定位时才会复制所有的 key 并排序，生成一个有序的视图
+func (hm *HashMap) Iterator(reverse bool) Iterator {
+	return &hashMapIterator{hm: hm, reverse: reverse}
+}
+
+func (hm *HashMap) shard(key []byte) *hashMapShard {
+	return &hm.shards[maphash.Bytes(hm.seed, key)%hashMapShards]
+}
+
+// 复制所有的 key 并排序
+func (hm *HashMap) sortedItems(reverse bool) []*Item {
+	items := make([]*Item, 0, hm.Size())
+	for i := range hm.shards {
+		shard := &hm.shards[i]
+		shard.lock.RLock()
+		for key, pos := range shard.items {
+			items = append(items, &Item{key: []byte(key), pos: pos})
+		}
+		shard.lock.RUnlock()
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if reverse {
+			return bytes.Compare(items[i].key, items[j].key) > 0
+		}
+		return bytes.Compare(items[i].key, items[j].key) < 0
+	})
+	return items
+}
+
+// HashMap 索引迭代器，在第一次定位时生成有序视图
+type hashMapIterator struct {
+	hm        *HashMap
+	reverse   bool
+	built     bool
+	currIndex int
+	values    []*Item
+}
+
+func (hmi *hashMapIterator) Rewind() {
+	hmi.build()
+	hmi.currIndex = 0
+}
+
+func (hmi *hashMapIterator) Seek(key []byte) {
+	hmi.build()
+	hmi.currIndex = sort.Search(len(hmi.values), func(i int) bool {
+		if hmi.reverse {
+			return bytes.Compare(hmi.values[i].key, key) <= 0
+		}
+		return bytes.Compare(hmi.values[i].key, key) >= 0
+	})
+}
+
+func (hmi *hashMapIterator) Next() {
+	hmi.currIndex += 1
+}
+
+func (hmi *hashMapIterator) Valid() bool {
+	hmi.build()
+	return hmi.currIndex < len(hmi.values)
+}
+
+func (hmi *hashMapIterator) Key() []byte {
+	return hmi.values[hmi.currIndex].key
+}
+
+func (hmi *hashMapIterator) Value() *data.LogRecordPos {
+	return hmi.values[hmi.currIndex].pos
+}
+
+func (hmi *hashMapIterator) Close() {
+	hmi.values = nil
+}
+
+func (hmi *hashMapIterator) build() {
+	if hmi.built {
+		return
+	}
+	hmi.built = true
+	hmi.values = hmi.hm.sortedItems(hmi.reverse)
+}
@@ -0,0 +1,77 @@
+package index
+
+import (
+	"bitcask-kv-go/data"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashMap_PutGetDelete(t *testing.T) {
+	hm := NewHashMap()
+
+	assert.False(t, hm.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100}))
+	assert.Nil(t, hm.Get(nil))
+	assert.False(t, hm.Delete(nil))
+
+	assert.True(t, hm.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2}))
+	assert.True(t, hm.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3}))
+	assert.Equal(t, 1, hm.Size())
+	assert.Equal(t, int64(3), hm.Get([]byte("a")).Offset)
+
+	assert.True(t, hm.Delete([]byte("a")))
+	assert.False(t, hm.Delete([]byte("a")))
+	assert.Nil(t, hm.Get([]byte("a")))
+	assert.Equal(t, 0, hm.Size())
+}
+
+func TestHashMap_Iterator(t *testing.T) {
+	hm := NewHashMap()
+
+	iter1 := hm.Iterator(false)
+	assert.False(t, iter1.Valid())
+	iter1.Close()
+
+	for _, key := range []string{"ccde", "adse", "bbcd", "acee"} {
+		hm.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 10})
+	}
+
+	var keys []string
+	iter2 := hm.Iterator(false)
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		assert.NotNil(t, iter2.Value())
+		keys = append(keys, string(iter2.Key()))
+	}
+	assert.Equal(t, []string{"acee", "adse", "bbcd", "ccde"}, keys)
+	iter2.Close()
+
+	iter3 := hm.Iterator(true)
+	iter3.Seek([]byte("bz"))
+	assert.Equal(t, "bbcd", string(iter3.Key()))
+	iter3.Next()
+	assert.Equal(t, "adse", string(iter3.Key()))
+	iter3.Close()
+}
+
+func TestHashMap_Concurrent(t *testing.T) {
+	hm := NewHashMap()
+	wg := new(sync.WaitGroup)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := []byte(fmt.Sprintf("key-%d-%d", w, i))
+				hm.Put(key, &data.LogRecordPos{Fid: uint32(w), Offset: int64(i)})
+				assert.NotNil(t, hm.Get(key))
+				if i%2 == 0 {
+					hm.Delete(key)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	assert.Equal(t, 8*500, hm.Size())
+}
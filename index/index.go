@@ -24,6 +24,18 @@ type Indexer interface {
 	Iterator(reverse bool) Iterator
 }
 
+// ErrGetter 需要读取磁盘的索引实现的接口，读取出错时返回错误，而不是将其视为 key 不存在
+type ErrGetter interface {
+	// GetErr 根据 key 取出对应的索引位置信息，key 不存在时返回 nil
+	GetErr(key []byte) (*data.LogRecordPos, error)
+}
+
+// ErrIterator 需要读取磁盘的索引迭代器实现的接口，读取出错时迭代器失效，通过 Err 返回错误
+type ErrIterator interface {
+	// Err 遍历过程中遇到的错误
+	Err() error
+}
+
 type IndexType = int8
 
 const (
@@ -32,6 +44,15 @@ const (
 
 	// ART 自适应基数树索引
 	ART
+
+	// BPTree 持久化在磁盘上的 B+ 树索引
+	BPTree
+
+	// Skiplist 并发跳表索引
+	Skiplist
+
+	// Hash 分片哈希索引
+	Hash
 )
 
 // 根据类型初始化索引
@@ -42,6 +63,10 @@ func NewIndexer(typ IndexType) Indexer {
 	case ART:
 		// todo
 		return nil
+	case Skiplist:
+		return NewSkipList()
+	case Hash:
+		return NewHashMap()
 	default:
 		panic("unsupported index type")
 	}
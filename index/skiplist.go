@@ -0,0 +1,354 @@
+package index
+
+import (
+	"bitcask-kv-go/data"
+	"bytes"
+	"math/rand/v2"
+	"sync/atomic"
+)
+
+const (
+	// 跳表的最大层数
+	skipListMaxHeight = 20
+
+	// 每提升一层的概率为 1/skipListBranching
+	skipListBranching = 4
+)
+
+// SkipList 并发跳表索引
+// 读操作和迭代器不加锁，写操作通过 CAS 把新节点链接到每一层
+// 删除分为两步：先把节点的位置信息置为 nil（逻辑删除），再标记节点每一层的后继指针，之后的查找通过 CAS 把被标记的节点从链表中摘除（物理删除）
+// 后继指针被标记之后不能再在节点之后链接新的节点，被删除的 key 再次写入时会链接一个新的节点
+type SkipList struct {
+	head   *skipNode
+	height atomic.Int32
+	size   atomic.Int64
+}
+
+type skipNode struct {
+	key  []byte
+	pos  atomic.Pointer[data.LogRecordPos] // 为 nil 时表示 key 已经被删除，被删除的节点不会再被复用
+	next []atomic.Pointer[skipLink]
+}
+
+// 指向后继节点的指针，和删除标记一起原子地替换，创建之后不再修改
+type skipLink struct {
+	node   *skipNode
+	marked bool // 所属的节点已经被删除，等待从这一层摘除
+}
+
+// 新建 SkipList 索引结构
+func NewSkipList() *SkipList {
+	sl := &SkipList{head: newSkipNode(nil, skipListMaxHeight)}
+	sl.height.Store(1)
+	return sl
+}
+
+func newSkipNode(key []byte, height int) *skipNode {
+	node := &skipNode{key: key, next: make([]atomic.Pointer[skipLink], height)}
+	for level := range node.next {
+		node.next[level].Store(&skipLink{})
+	}
+	return node
+}
+
+func (sl *SkipList) Put(key []byte, pos *data.LogRecordPos) bool {
+	if len(key) == 0 || pos == nil {
+		return false
+	}
+	var preds, succs [skipListMaxHeight]*skipNode
+	for {
+		if sl.find(key, &preds, &succs) {
+			node := succs[0]
+			old := node.pos.Load()
+			if old == nil {
+				// 节点已经被逻辑删除，协助完成删除之后链接新的节点
+				node.mark()
+				continue
+			}
+			if node.pos.CompareAndSwap(old, pos) {
+				return true
+			}
+			continue
+		}
+
+		height := sl.randomHeight()
+		node := newSkipNode(key, height)
+		node.pos.Store(pos)
+		for level := 0; level < height; level++ {
+			node.next[level].Store(&skipLink{node: succs[level]})
+		}
+		sl.raiseHeight(height)
+
+		// 先链接最底层，成功之后 key 才算真正写入
+		if !casLink(&preds[0].next[0], succs[0], node) {
+			// 有其他写入者修改了该位置，重新查找
+			continue
+		}
+		sl.size.Add(1)
+
+		// 再依次链接上面的层，这些层只用于加速查找
+		for level := 1; level < height; level++ {
+			for {
+				// 节点在链接的过程中被删除，不再链接剩下的层
+				link := node.next[level].Load()
+				if link.marked {
+					return true
+				}
+				if link.node != succs[level] && !node.next[level].CompareAndSwap(link, &skipLink{node: succs[level]}) {
+					continue
+				}
+				if casLink(&preds[level].next[level], succs[level], node) {
+					break
+				}
+				sl.find(key, &preds, &succs)
+			}
+			// 链接之前节点已经被删除，删除时的查找可能没有摘除这一层，重新查找一次
+			if node.next[level].Load().marked {
+				sl.find(key, &preds, &succs)
+				return true
+			}
+		}
+		return true
+	}
+}
+
+func (sl *SkipList) Get(key []byte) *data.LogRecordPos {
+	if len(key) == 0 {
+		return nil
+	}
+	node := sl.findGreaterOrEqual(key)
+	if node == nil || !bytes.Equal(node.key, key) {
+		return nil
+	}
+	return node.pos.Load()
+}
+
+func (sl *SkipList) Delete(key []byte) bool {
+	if len(key) == 0 {
+		return false
+	}
+	var preds, succs [skipListMaxHeight]*skipNode
+	for {
+		if !sl.find(key, &preds, &succs) {
+			return false
+		}
+		node := succs[0]
+		old := node.pos.Load()
+		if old == nil {
+			// 其他的删除已经完成了逻辑删除
+			return false
+		}
+		if !node.pos.CompareAndSwap(old, nil) {
+			continue
+		}
+		sl.size.Add(-1)
+		node.mark()
+		// 重新查找一次，把节点从每一层摘除
+		sl.find(key, &preds, &succs)
+		return true
+	}
+}
+
+func (sl *SkipList) Size() int {
+	return int(sl.size.Load())
+}
+
+func (sl *SkipList) Iterator(reverse bool) Iterator {
+	it := &skipListIterator{list: sl, reverse: reverse}
+	it.Rewind()
+	return it
+}
+
+// 从上到下标记节点每一层的后继指针，标记之后节点不能再作为前驱链接新的节点
+func (n *skipNode) mark() {
+	for level := len(n.next) - 1; level >= 0; level-- {
+		for {
+			link := n.next[level].Load()
+			if link.marked || n.next[level].CompareAndSwap(link, &skipLink{node: link.node, marked: true}) {
+				break
+			}
+		}
+	}
+}
+
+// 后继指针没有被标记并且指向 expected 时，替换为指向 node
+// 每次修改都会创建新的 skipLink，比较的是读取到的 skipLink 本身，期间有任何修改都会失败
+func casLink(ptr *atomic.Pointer[skipLink], expected, node *skipNode) bool {
+	link := ptr.Load()
+	if link.marked || link.node != expected {
+		return false
+	}
+	return ptr.CompareAndSwap(link, &skipLink{node: node})
+}
+
+// 查找每一层中 key 的前驱和后继节点，返回 key 是否存在，存在时 succs[0] 为对应的节点
+// 查找过程中遇到被标记的节点时将其从这一层摘除，摘除失败说明前驱也发生了变化，从头重新查找
+func (sl *SkipList) find(key []byte, preds, succs *[skipListMaxHeight]*skipNode) bool {
+retry:
+	height := int(sl.height.Load())
+	for level := height; level < skipListMaxHeight; level++ {
+		preds[level], succs[level] = sl.head, nil
+	}
+	pred := sl.head
+	for level := height - 1; level >= 0; level-- {
+		curr := pred.next[level].Load().node
+		for curr != nil {
+			link := curr.next[level].Load()
+			if link.marked {
+				if !casLink(&pred.next[level], curr, link.node) {
+					goto retry
+				}
+				curr = link.node
+				continue
+			}
+			if bytes.Compare(curr.key, key) >= 0 {
+				break
+			}
+			pred, curr = curr, link.node
+		}
+		preds[level], succs[level] = pred, curr
+	}
+	return succs[0] != nil && bytes.Equal(succs[0].key, key)
+}
+
+// 跳过被标记的节点，返回 node 在这一层之后第一个没有被标记的节点
+func (n *skipNode) nextLive(level int) *skipNode {
+	next := n.next[level].Load().node
+	for next != nil {
+		link := next.next[level].Load()
+		if !link.marked {
+			return next
+		}
+		next = link.node
+	}
+	return nil
+}
+
+// 查找第一个大于等于 key 的节点，只读取不修改，跳过被标记的节点
+func (sl *SkipList) findGreaterOrEqual(key []byte) *skipNode {
+	pred := sl.head
+	var next *skipNode
+	for level := int(sl.height.Load()) - 1; level >= 0; level-- {
+		next = pred.nextLive(level)
+		for next != nil && bytes.Compare(next.key, key) < 0 {
+			pred = next
+			next = pred.nextLive(level)
+		}
+	}
+	return next
+}
+
+// 查找最后一个小于 key 的节点，key 为 nil 时查找最后一个节点，不存在时返回 nil
+func (sl *SkipList) findLess(key []byte) *skipNode {
+	pred := sl.head
+	for level := int(sl.height.Load()) - 1; level >= 0; level-- {
+		next := pred.nextLive(level)
+		for next != nil && (key == nil || bytes.Compare(next.key, key) < 0) {
+			pred = next
+			next = pred.nextLive(level)
+		}
+	}
+	if pred == sl.head {
+		return nil
+	}
+	return pred
+}
+
+func (sl *SkipList) randomHeight() int {
+	height := 1
+	for height < skipListMaxHeight && rand.Uint32()%skipListBranching == 0 {
+		height++
+	}
+	return height
+}
+
+func (sl *SkipList) raiseHeight(height int) {
+	for {
+		curr := sl.height.Load()
+		if int32(height) <= curr || sl.height.CompareAndSwap(curr, int32(height)) {
+			return
+		}
+	}
+}
+
+// SkipList 索引迭代器
+// 迭代器直接在跳表上遍历，不持有锁也不复制数据，可以看到遍历过程中其他写入者的修改
+type skipListIterator struct {
+	list    *SkipList
+	reverse bool
+	node    *skipNode          // 当前遍历到的节点
+	pos     *data.LogRecordPos // 移动到当前节点时读取到的位置信息
+}
+
+func (it *skipListIterator) Rewind() {
+	if it.reverse {
+		it.settleBackward(it.list.findLess(nil))
+	} else {
+		it.settleForward(it.list.head.nextLive(0))
+	}
+}
+
+func (it *skipListIterator) Seek(key []byte) {
+	if !it.reverse {
+		it.settleForward(it.list.findGreaterOrEqual(key))
+		return
+	}
+	node := it.list.findGreaterOrEqual(key)
+	if node != nil && bytes.Equal(node.key, key) {
+		it.settleBackward(node)
+		return
+	}
+	it.settleBackward(it.list.findLess(key))
+}
+
+func (it *skipListIterator) Next() {
+	if it.node == nil {
+		return
+	}
+	if it.reverse {
+		it.settleBackward(it.list.findLess(it.node.key))
+	} else {
+		it.settleForward(it.node.next[0].Load().node)
+	}
+}
+
+func (it *skipListIterator) Valid() bool {
+	return it.node != nil
+}
+
+func (it *skipListIterator) Key() []byte {
+	return it.node.key
+}
+
+func (it *skipListIterator) Value() *data.LogRecordPos {
+	return it.pos
+}
+
+func (it *skipListIterator) Close() {
+	it.node, it.pos = nil, nil
+}
+
+// 从 node 开始向后跳过已经删除的节点
+func (it *skipListIterator) settleForward(node *skipNode) {
+	for node != nil {
+		if pos := node.pos.Load(); pos != nil {
+			it.node, it.pos = node, pos
+			return
+		}
+		node = node.next[0].Load().node
+	}
+	it.node, it.pos = nil, nil
+}
+
+// 从 node 开始向前跳过已经删除的节点
+func (it *skipListIterator) settleBackward(node *skipNode) {
+	for node != nil {
+		if pos := node.pos.Load(); pos != nil {
+			it.node, it.pos = node, pos
+			return
+		}
+		node = it.list.findLess(node.key)
+	}
+	it.node, it.pos = nil, nil
+}
@@ -0,0 +1,267 @@
+package index
+
+import (
+	"bitcask-kv-go/data"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSkipList_Put(t *testing.T) {
+	sl := NewSkipList()
+
+	assert.False(t, sl.Put(nil, &data.LogRecordPos{Fid: 1, Offset: 100}))
+	assert.False(t, sl.Put([]byte(""), &data.LogRecordPos{Fid: 1, Offset: 100}))
+
+	assert.True(t, sl.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 2}))
+	assert.True(t, sl.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 3}))
+	assert.Equal(t, 1, sl.Size())
+	assert.Equal(t, int64(3), sl.Get([]byte("a")).Offset)
+}
+
+func TestSkipList_Get(t *testing.T) {
+	sl := NewSkipList()
+	assert.Nil(t, sl.Get(nil))
+	assert.Nil(t, sl.Get([]byte("not-exist")))
+
+	for i := 0; i < 1000; i++ {
+		assert.True(t, sl.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)}))
+	}
+	for i := 0; i < 1000; i++ {
+		pos := sl.Get([]byte(fmt.Sprintf("key-%04d", i)))
+		assert.NotNil(t, pos)
+		assert.Equal(t, int64(i), pos.Offset)
+	}
+	assert.Nil(t, sl.Get([]byte("key-1000")))
+	assert.Equal(t, 1000, sl.Size())
+}
+
+func TestSkipList_Delete(t *testing.T) {
+	sl := NewSkipList()
+	assert.False(t, sl.Delete(nil))
+	assert.False(t, sl.Delete([]byte("not-exist")))
+
+	assert.True(t, sl.Put([]byte("aaa"), &data.LogRecordPos{Fid: 22, Offset: 33}))
+	assert.True(t, sl.Delete([]byte("aaa")))
+	assert.False(t, sl.Delete([]byte("aaa")))
+	assert.Nil(t, sl.Get([]byte("aaa")))
+	assert.Equal(t, 0, sl.Size())
+
+	// 被删除的 key 再次写入时链接新的节点
+	assert.True(t, sl.Put([]byte("aaa"), &data.LogRecordPos{Fid: 22, Offset: 44}))
+	assert.Equal(t, int64(44), sl.Get([]byte("aaa")).Offset)
+	assert.Equal(t, 1, sl.Size())
+	assert.Equal(t, 1, countSkipNodes(sl))
+}
+
+// 统计每一层中链接的节点数量，被删除的节点已经被摘除时和 Size 相同
+func countSkipNodes(sl *SkipList) int {
+	count := 0
+	for node := sl.head.next[0].Load().node; node != nil; node = node.next[0].Load().node {
+		count++
+	}
+	for level := 1; level < skipListMaxHeight; level++ {
+		for node := sl.head.next[level].Load().node; node != nil; node = node.next[level].Load().node {
+			if node.pos.Load() == nil {
+				return -1
+			}
+		}
+	}
+	return count
+}
+
+func TestSkipList_DeleteUnlinksNodes(t *testing.T) {
+	sl := NewSkipList()
+	for i := 0; i < 1000; i++ {
+		assert.True(t, sl.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)}))
+	}
+	for i := 0; i < 1000; i += 2 {
+		assert.True(t, sl.Delete([]byte(fmt.Sprintf("key-%04d", i))))
+	}
+	// 被删除的节点从每一层中摘除，不会一直占用内存
+	assert.Equal(t, 500, sl.Size())
+	assert.Equal(t, 500, countSkipNodes(sl))
+	for i := 0; i < 1000; i++ {
+		pos := sl.Get([]byte(fmt.Sprintf("key-%04d", i)))
+		if i%2 == 0 {
+			assert.Nil(t, pos)
+		} else {
+			assert.Equal(t, int64(i), pos.Offset)
+		}
+	}
+}
+
+func TestSkipList_ConcurrentDelete(t *testing.T) {
+	sl := NewSkipList()
+	const workers, keyCount = 8, 500
+
+	// 每个 key 都被多个 goroutine 交替写入和删除，最后一轮统一写入
+	wg := new(sync.WaitGroup)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for round := 0; round < 10; round++ {
+				for i := 0; i < keyCount; i++ {
+					key := []byte(fmt.Sprintf("key-%04d", i))
+					if (i+w+round)%2 == 0 {
+						sl.Put(key, &data.LogRecordPos{Fid: uint32(w), Offset: int64(i)})
+					} else {
+						sl.Delete(key)
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	for i := 0; i < keyCount; i += 2 {
+		sl.Delete([]byte(fmt.Sprintf("key-%04d", i)))
+	}
+	for i := 1; i < keyCount; i += 2 {
+		sl.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Offset: int64(i)})
+	}
+
+	assert.Equal(t, keyCount/2, sl.Size())
+	assert.Equal(t, keyCount/2, countSkipNodes(sl))
+	var prev []byte
+	iter := sl.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		if prev != nil {
+			assert.Less(t, string(prev), string(iter.Key()))
+		}
+		prev = iter.Key()
+	}
+}
+
+func TestSkipList_Iterator(t *testing.T) {
+	sl := NewSkipList()
+
+	// 空的跳表
+	iter1 := sl.Iterator(false)
+	assert.False(t, iter1.Valid())
+	iter1.Close()
+
+	for _, key := range []string{"ccde", "adse", "bbcd", "acee", "eede"} {
+		sl.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 10})
+	}
+	sl.Delete([]byte("bbcd"))
+
+	var keys []string
+	iter2 := sl.Iterator(false)
+	for iter2.Rewind(); iter2.Valid(); iter2.Next() {
+		assert.NotNil(t, iter2.Value())
+		keys = append(keys, string(iter2.Key()))
+	}
+	assert.Equal(t, []string{"acee", "adse", "ccde", "eede"}, keys)
+
+	keys = nil
+	iter3 := sl.Iterator(true)
+	for iter3.Rewind(); iter3.Valid(); iter3.Next() {
+		keys = append(keys, string(iter3.Key()))
+	}
+	assert.Equal(t, []string{"eede", "ccde", "adse", "acee"}, keys)
+
+	// Seek 正向
+	iter4 := sl.Iterator(false)
+	iter4.Seek([]byte("b"))
+	assert.Equal(t, "ccde", string(iter4.Key()))
+	iter4.Seek([]byte("eede"))
+	assert.Equal(t, "eede", string(iter4.Key()))
+	iter4.Seek([]byte("z"))
+	assert.False(t, iter4.Valid())
+
+	// Seek 反向
+	iter5 := sl.Iterator(true)
+	iter5.Seek([]byte("ccde"))
+	assert.Equal(t, "ccde", string(iter5.Key()))
+	iter5.Seek([]byte("c"))
+	assert.Equal(t, "adse", string(iter5.Key()))
+	iter5.Next()
+	assert.Equal(t, "acee", string(iter5.Key()))
+	iter5.Next()
+	assert.False(t, iter5.Valid())
+	iter5.Seek([]byte("a"))
+	assert.False(t, iter5.Valid())
+}
+
+func TestSkipList_Concurrent(t *testing.T) {
+	sl := NewSkipList()
+	const writers, keysPerWriter = 8, 2000
+
+	wg := new(sync.WaitGroup)
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < keysPerWriter; i++ {
+				key := []byte(fmt.Sprintf("key-%06d", i*writers+w))
+				sl.Put(key, &data.LogRecordPos{Fid: uint32(w), Offset: int64(i)})
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < keysPerWriter; i++ {
+				sl.Get([]byte(fmt.Sprintf("key-%06d", rand.IntN(writers*keysPerWriter))))
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, writers*keysPerWriter, sl.Size())
+	var prev []byte
+	count := 0
+	iter := sl.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		if prev != nil {
+			assert.Less(t, string(prev), string(iter.Key()))
+		}
+		prev = iter.Key()
+		count++
+	}
+	assert.Equal(t, writers*keysPerWriter, count)
+}
+
+// 多个 goroutine 并发读写时的性能对比，writePercent 为写操作所占的百分比
+func BenchmarkIndexer_MixedReadWrite(b *testing.B) {
+	const keyCount = 100000
+	keys := make([][]byte, keyCount)
+	for i := range keys {
+		keys[i] = []byte("bench-key-" + strconv.Itoa(i))
+	}
+	indexers := []struct {
+		name string
+		new  func() Indexer
+	}{
+		{"BTree", func() Indexer { return NewBTree() }},
+		{"SkipList", func() Indexer { return NewSkipList() }},
+		{"HashMap", func() Indexer { return NewHashMap() }},
+	}
+
+	for _, writePercent := range []int{0, 10, 50} {
+		for _, idx := range indexers {
+			b.Run(fmt.Sprintf("%s/write=%d%%", idx.name, writePercent), func(b *testing.B) {
+				indexer := idx.new()
+				pos := &data.LogRecordPos{Fid: 1, Offset: 1}
+				for _, key := range keys {
+					indexer.Put(key, pos)
+				}
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					r := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+					for pb.Next() {
+						key := keys[r.IntN(keyCount)]
+						if r.IntN(100) < writePercent {
+							indexer.Put(key, pos)
+						} else {
+							indexer.Get(key)
+						}
+					}
+				})
+			})
+		}
+	}
+}
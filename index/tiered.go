@@ -0,0 +1,614 @@
+package index
+
+import (
+	"bitcask-kv-go/data"
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/google/btree"
+)
+
+var ErrKeyFileIncomplete = errors.New("the key file is incomplete")
+
+// key 文件结束标记的 key
+var keyFileFinishedKey = []byte("key-file.finished")
+
+// Tiered 分层索引，用于低内存模式
+// 未封存的数据文件中的 key 保存在内存中，数据文件封存时，其中的 key 按顺序写入对应的 key 文件，
+// 内存中只保留每个 key 文件的稀疏索引，查询时先查内存，再按照从新到旧的顺序查找 key 文件
+type Tiered struct {
+	mem       *btree.BTree // 未封存的 key，位置为 nil 的表示 key 已被删除
+	tables    []*keyTable  // 已封存的 key 文件，按文件 id 从小到大排列
+	blockSize int          // key 文件中每个块包含的 key 数量
+	lock      *sync.RWMutex
+
+	size      int    // 有效 key 的数量，sizeKnown 为 true 时随写入和删除一起维护
+	sizeKnown bool   // 第一次获取数量时遍历所有的 key 计算，之后不再需要遍历
+	version   uint64 // 每次修改递增，用于判断计算数量期间是否有并发的修改
+
+	maxVersion uint64 // 已加载的 key 文件中记录的数据版本号的最大值
+}
+
+// key 文件，按 key 有序存储一个数据文件封存时其中的 key 以及删除标记
+type keyTable struct {
+	fid     uint32
+	file    *data.DataFile
+	blocks  []*keyBlock // 稀疏索引，记录每个块的第一个 key
+	end     int64       // 最后一个块的结束位置
+	lastKey []byte      // 文件中最大的 key
+}
+
+// key 文件中的一个块
+type keyBlock struct {
+	firstKey []byte
+	offset   int64
+}
+
+// 新建分层索引，blockSize 为 key 文件中每个块包含的 key 数量
+func NewTiered(blockSize int) *Tiered {
+	return &Tiered{
+		mem:       btree.New(32),
+		blockSize: blockSize,
+		lock:      new(sync.RWMutex),
+	}
+}
+
+// Put 读取 key 文件失败时返回 false
+// 已经计算过 key 的数量时，需要先判断 key 是否存在，key 不在内存中时会读取 key 文件
+func (t *Tiered) Put(key []byte, pos *data.LogRecordPos) bool {
+	if len(key) == 0 || pos == nil {
+		return false
+	}
+	existed, checked, err := t.exists(key)
+	if err != nil {
+		return false
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.mem.ReplaceOrInsert(&Item{key: key, pos: pos})
+	t.version++
+	if !existed {
+		t.updateSizeLocked(checked, 1)
+	}
+	return true
+}
+
+// Get 读取 key 文件失败时视为 key 不存在，需要区分时使用 GetErr
+func (t *Tiered) Get(key []byte) *data.LogRecordPos {
+	pos, _ := t.GetErr(key)
+	return pos
+}
+
+// GetErr 根据 key 取出对应的索引位置信息，读取 key 文件失败时返回错误
+func (t *Tiered) GetErr(key []byte) (*data.LogRecordPos, error) {
+	if len(key) == 0 {
+		return nil, nil
+	}
+	t.lock.RLock()
+	memItem := t.mem.Get(&Item{key: key})
+	tables := t.tables
+	t.lock.RUnlock()
+
+	if memItem != nil {
+		return memItem.(*Item).pos, nil
+	}
+	// 从新到旧查找 key 文件
+	for i := len(tables) - 1; i >= 0; i-- {
+		item, err := tables[i].get(key)
+		if err != nil {
+			return nil, err
+		}
+		if item != nil {
+			return item.pos, nil
+		}
+	}
+	return nil, nil
+}
+
+// 判断 key 是否存在，用于维护 key 的数量，还没有计算过数量时不需要读取 key 文件，此时 checked 为 false
+func (t *Tiered) exists(key []byte) (existed, checked bool, err error) {
+	t.lock.RLock()
+	sizeKnown := t.sizeKnown
+	t.lock.RUnlock()
+	if !sizeKnown {
+		return false, false, nil
+	}
+	pos, err := t.GetErr(key)
+	return pos != nil, true, err
+}
+
+// 更新 key 的数量，判断 key 是否存在之后数量才被计算出来时，无法确定是否需要更新，下次重新计算
+func (t *Tiered) updateSizeLocked(checked bool, delta int) {
+	if !t.sizeKnown {
+		return
+	}
+	if !checked {
+		t.sizeKnown = false
+		return
+	}
+	t.size += delta
+}
+
+// Delete 删除 key，已经有 key 文件时在内存中记录删除标记，用于屏蔽 key 文件中的旧位置
+// 读取 key 文件失败时返回 false
+func (t *Tiered) Delete(key []byte) bool {
+	if len(key) == 0 {
+		return false
+	}
+	existed, checked, err := t.exists(key)
+	if err != nil {
+		return false
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.version++
+	if existed || !checked {
+		t.updateSizeLocked(checked, -1)
+	}
+	if len(t.tables) > 0 {
+		t.mem.ReplaceOrInsert(&Item{key: key})
+		return true
+	}
+	return t.mem.Delete(&Item{key: key}) != nil
+}
+
+// Size 第一次调用时遍历所有的 key 文件计算数量，之后随写入和删除一起维护
+// 读取 key 文件失败时返回已经遍历到的数量，并且不会记录下来
+func (t *Tiered) Size() int {
+	t.lock.RLock()
+	size, sizeKnown, version := t.size, t.sizeKnown, t.version
+	t.lock.RUnlock()
+	if sizeKnown {
+		return size
+	}
+
+	iter := t.Iterator(false).(*tieredIterator)
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		size++
+	}
+	if iter.Err() != nil {
+		return size
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	// 计算期间有并发的修改时不记录，下次重新计算
+	if t.version == version {
+		t.size, t.sizeKnown = size, true
+	}
+	return size
+}
+
+func (t *Tiered) Iterator(reverse bool) Iterator {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	// 内存中的 key 数量有限，直接生成快照
+	memItems := make([]*Item, 0, t.mem.Len())
+	t.mem.Ascend(func(item btree.Item) bool {
+		memItems = append(memItems, item.(*Item))
+		return true
+	})
+
+	// 从新到旧排列，相同的 key 以更新的为准
+	cursors := []*tieredCursor{newMemCursor(memItems, reverse)}
+	for i := len(t.tables) - 1; i >= 0; i-- {
+		cursors = append(cursors, newTableCursor(t.tables[i], reverse))
+	}
+	return &tieredIterator{cursors: cursors, reverse: reverse}
+}
+
+// Seal 将内存中位于 fid 及之前的数据文件中的 key，连同所有的删除标记写入 key 文件，
+// 写入完成之后这些 key 只保存在 key 文件中
+// dataVersion 为封存时已经分配的最大的数据版本号，key 文件中的删除标记没有版本号，重新加载时需要依靠它恢复版本号
+// 调用方需要保证 fid 之前的数据文件都已经封存，并且封存过程中没有并发的写入
+func (t *Tiered) Seal(fid uint32, file *data.DataFile, dataVersion uint64) error {
+	t.lock.RLock()
+	var items []*Item
+	t.mem.Ascend(func(item btree.Item) bool {
+		it := item.(*Item)
+		if it.pos == nil || it.pos.Fid <= fid {
+			items = append(items, it)
+		}
+		return true
+	})
+	t.lock.RUnlock()
+
+	table := &keyTable{fid: fid, file: file, end: file.WriteOff}
+	var buf bytes.Buffer
+	for i, item := range items {
+		record := &data.LogRecord{Key: item.key, Type: data.LogRecordDeleted}
+		if item.pos != nil {
+			record.Type = data.LogRecordNormal
+			record.Value = data.EncodeLogRecordPos(item.pos)
+		}
+		encRecord, _, err := file.EncodeLogRecord(record)
+		if err != nil {
+			return err
+		}
+		// 每个块的第一个 key 写入稀疏索引
+		if i%t.blockSize == 0 {
+			if err := file.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
+			table.blocks = append(table.blocks, &keyBlock{firstKey: item.key, offset: file.WriteOff})
+		}
+		buf.Write(encRecord)
+		table.lastKey = item.key
+	}
+	if err := file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	table.end = file.WriteOff
+
+	// 写入结束标记，没有结束标记的 key 文件在加载时视为不完整
+	finRecord, _, err := file.EncodeLogRecord(&data.LogRecord{
+		Key:     keyFileFinishedKey,
+		Type:    data.LogRecordTxnFinished,
+		Version: dataVersion,
+	})
+	if err != nil {
+		return err
+	}
+	if err := file.Write(finRecord); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	// 封存只是把 key 从内存移动到 key 文件中，不影响 key 的数量
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, item := range items {
+		// 只移除封存之后没有被更新过的 key
+		if current := t.mem.Get(item); current == item {
+			t.mem.Delete(item)
+		}
+	}
+	t.addTableLocked(table)
+	if dataVersion > t.maxVersion {
+		t.maxVersion = dataVersion
+	}
+	return nil
+}
+
+// Load 加载已封存的 key 文件，只在内存中保留稀疏索引
+func (t *Tiered) Load(fid uint32, file *data.DataFile) error {
+	table := &keyTable{fid: fid, file: file}
+	var count int
+	var dataVersion uint64
+	var offset int64 = 0
+	for {
+		record, size, err := file.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				return ErrKeyFileIncomplete
+			}
+			return err
+		}
+		if record.Type == data.LogRecordTxnFinished {
+			table.end = offset
+			dataVersion = record.Version
+			break
+		}
+		if record.Type != data.LogRecordFileHeader {
+			if count%t.blockSize == 0 {
+				table.blocks = append(table.blocks, &keyBlock{firstKey: record.Key, offset: offset})
+			}
+			table.lastKey = record.Key
+			count++
+		}
+		offset += size
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.addTableLocked(table)
+	// 加载的 key 文件中可能包含已经存在的 key，需要重新计算数量
+	t.sizeKnown = false
+	t.version++
+	if dataVersion > t.maxVersion {
+		t.maxVersion = dataVersion
+	}
+	return nil
+}
+
+// MaxVersion 封存或者加载的 key 文件中记录的数据版本号的最大值
+func (t *Tiered) MaxVersion() uint64 {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.maxVersion
+}
+
+// Close 关闭所有的 key 文件
+func (t *Tiered) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, table := range t.tables {
+		if err := table.file.Close(); err != nil {
+			return err
+		}
+	}
+	t.tables = nil
+	return nil
+}
+
+func (t *Tiered) addTableLocked(table *keyTable) {
+	idx := sort.Search(len(t.tables), func(i int) bool {
+		return t.tables[i].fid > table.fid
+	})
+	// 生成新的切片，避免影响正在进行的查询和迭代
+	tables := make([]*keyTable, 0, len(t.tables)+1)
+	tables = append(tables, t.tables[:idx]...)
+	tables = append(tables, table)
+	tables = append(tables, t.tables[idx:]...)
+	t.tables = tables
+}
+
+// 查找 key，返回的位置为 nil 表示 key 已被删除，没有找到时返回 nil
+func (kt *keyTable) get(key []byte) (*Item, error) {
+	if len(kt.blocks) == 0 ||
+		bytes.Compare(key, kt.blocks[0].firstKey) < 0 || bytes.Compare(key, kt.lastKey) > 0 {
+		return nil, nil
+	}
+	items, err := kt.readBlock(kt.searchBlock(key))
+	if err != nil {
+		return nil, err
+	}
+	idx := sort.Search(len(items), func(i int) bool {
+		return bytes.Compare(items[i].key, key) >= 0
+	})
+	if idx < len(items) && bytes.Equal(items[idx].key, key) {
+		return items[idx], nil
+	}
+	return nil, nil
+}
+
+// 找到可能包含 key 的块，即第一个 key 小于等于 key 的最后一个块，没有则返回 -1
+func (kt *keyTable) searchBlock(key []byte) int {
+	return sort.Search(len(kt.blocks), func(i int) bool {
+		return bytes.Compare(kt.blocks[i].firstKey, key) > 0
+	}) - 1
+}
+
+// 读取一个块中的所有 key
+func (kt *keyTable) readBlock(idx int) ([]*Item, error) {
+	end := kt.end
+	if idx+1 < len(kt.blocks) {
+		end = kt.blocks[idx+1].offset
+	}
+	var items []*Item
+	for offset := kt.blocks[idx].offset; offset < end; {
+		record, size, err := kt.file.ReadLogRecord(offset)
+		if err != nil {
+			return nil, err
+		}
+		item := &Item{key: record.Key}
+		if record.Type == data.LogRecordNormal {
+			item.pos = data.DecodeLogRecordPos(record.Value)
+		}
+		items = append(items, item)
+		offset += size
+	}
+	return items, nil
+}
+
+// 分层索引中一层的游标，按块读取 key
+type tieredCursor struct {
+	reverse   bool
+	numBlocks int
+	firstKey  func(block int) []byte
+	load      func(block int) ([]*Item, error)
+	block     int
+	items     []*Item
+	idx       int
+	err       error // 读取块时遇到的错误，出错之后游标失效
+}
+
+func newMemCursor(items []*Item, reverse bool) *tieredCursor {
+	return &tieredCursor{
+		reverse:   reverse,
+		numBlocks: 1,
+		firstKey:  func(int) []byte { return nil },
+		load:      func(int) ([]*Item, error) { return items, nil },
+	}
+}
+
+func newTableCursor(table *keyTable, reverse bool) *tieredCursor {
+	return &tieredCursor{
+		reverse:   reverse,
+		numBlocks: len(table.blocks),
+		firstKey:  func(block int) []byte { return table.blocks[block].firstKey },
+		load:      table.readBlock,
+	}
+}
+
+// 加载指定的块，并定位到块的起点，读取失败时记录错误，游标失效
+func (c *tieredCursor) loadBlock(block int) {
+	c.block, c.items = block, nil
+	if block < 0 || block >= c.numBlocks {
+		return
+	}
+	items, err := c.load(block)
+	if err != nil {
+		c.block = c.numBlocks
+		c.err = err
+		return
+	}
+	c.items = items
+	if c.reverse {
+		c.idx = len(items) - 1
+	} else {
+		c.idx = 0
+	}
+}
+
+func (c *tieredCursor) rewind() {
+	if c.reverse {
+		c.loadBlock(c.numBlocks - 1)
+	} else {
+		c.loadBlock(0)
+	}
+	c.skipEmpty()
+}
+
+func (c *tieredCursor) seek(key []byte) {
+	block := sort.Search(c.numBlocks, func(i int) bool {
+		return bytes.Compare(c.firstKey(i), key) > 0
+	}) - 1
+	if block < 0 {
+		if c.reverse {
+			c.loadBlock(-1)
+			return
+		}
+		block = 0
+	}
+	c.loadBlock(block)
+	if c.reverse {
+		c.idx = sort.Search(len(c.items), func(i int) bool {
+			return bytes.Compare(c.items[i].key, key) > 0
+		}) - 1
+	} else {
+		c.idx = sort.Search(len(c.items), func(i int) bool {
+			return bytes.Compare(c.items[i].key, key) >= 0
+		})
+	}
+	c.skipEmpty()
+}
+
+func (c *tieredCursor) next() {
+	if c.reverse {
+		c.idx--
+	} else {
+		c.idx++
+	}
+	c.skipEmpty()
+}
+
+// 当前块已经遍历完时，切换到下一个块
+func (c *tieredCursor) skipEmpty() {
+	for c.block >= 0 && c.block < c.numBlocks && (c.idx < 0 || c.idx >= len(c.items)) {
+		if c.reverse {
+			c.loadBlock(c.block - 1)
+		} else {
+			c.loadBlock(c.block + 1)
+		}
+	}
+}
+
+func (c *tieredCursor) valid() bool {
+	return c.block >= 0 && c.block < c.numBlocks && c.idx >= 0 && c.idx < len(c.items)
+}
+
+func (c *tieredCursor) item() *Item {
+	return c.items[c.idx]
+}
+
+// 分层索引迭代器，合并内存和所有 key 文件中的 key
+// 任意一层读取失败时迭代器失效，避免返回被更新的层屏蔽的旧位置，错误通过 Err 返回
+type tieredIterator struct {
+	cursors []*tieredCursor // 从新到旧排列
+	reverse bool
+	curr    *Item
+	err     error
+}
+
+func (ti *tieredIterator) Rewind() {
+	ti.err = nil
+	for _, c := range ti.cursors {
+		c.err = nil
+		c.rewind()
+	}
+	ti.findNext()
+}
+
+func (ti *tieredIterator) Seek(key []byte) {
+	ti.err = nil
+	for _, c := range ti.cursors {
+		c.err = nil
+		c.seek(key)
+	}
+	ti.findNext()
+}
+
+func (ti *tieredIterator) Next() {
+	ti.findNext()
+}
+
+// 找到下一个有效的 key，同一个 key 以最新的一层为准，跳过已删除的 key
+func (ti *tieredIterator) findNext() {
+	for {
+		if ti.checkErr() {
+			return
+		}
+		var best *Item
+		for _, c := range ti.cursors {
+			if !c.valid() {
+				continue
+			}
+			item := c.item()
+			if best == nil {
+				best = item
+				continue
+			}
+			cmp := bytes.Compare(item.key, best.key)
+			if (!ti.reverse && cmp < 0) || (ti.reverse && cmp > 0) {
+				best = item
+			}
+		}
+		if best == nil {
+			ti.curr = nil
+			return
+		}
+		// 所有层中相同的 key 都向后移动
+		for _, c := range ti.cursors {
+			if c.valid() && bytes.Equal(c.item().key, best.key) {
+				c.next()
+			}
+		}
+		if best.pos != nil {
+			ti.curr = best
+			return
+		}
+	}
+}
+
+// 检查各层的游标是否出错，出错时迭代器失效
+func (ti *tieredIterator) checkErr() bool {
+	for _, c := range ti.cursors {
+		if c.err != nil {
+			ti.err = c.err
+			ti.curr = nil
+			return true
+		}
+	}
+	return false
+}
+
+// Err 遍历过程中读取 key 文件遇到的错误
+func (ti *tieredIterator) Err() error {
+	return ti.err
+}
+
+func (ti *tieredIterator) Valid() bool {
+	return ti.curr != nil
+}
+
+func (ti *tieredIterator) Key() []byte {
+	return ti.curr.key
+}
+
+func (ti *tieredIterator) Value() *data.LogRecordPos {
+	return ti.curr.pos
+}
+
+func (ti *tieredIterator) Close() {
+	ti.cursors = nil
+	ti.curr = nil
+}
@@ -0,0 +1,196 @@
+package index
+
+import (
+	"bitcask-kv-go/data"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sealTiered(t *testing.T, tiered *Tiered, dir string, fid uint32) {
+	t.Helper()
+	keyFile, err := data.OpenKeyFile(dir, fid)
+	assert.Nil(t, err)
+	assert.Nil(t, keyFile.WriteFileHeader(nil))
+	assert.Nil(t, tiered.Seal(fid, keyFile, 0))
+}
+
+func TestTiered_SealAndGet(t *testing.T) {
+	dir := t.TempDir()
+	tiered := NewTiered(4)
+	defer tiered.Close()
+
+	for i := 0; i < 20; i++ {
+		assert.True(t, tiered.Put([]byte(fmt.Sprintf("key-%03d", i)), &data.LogRecordPos{Fid: 0, Offset: int64(i)}))
+	}
+	sealTiered(t, tiered, dir, 0)
+	// 封存之后内存中不再保存 key
+	assert.Equal(t, 0, tiered.mem.Len())
+
+	// 更新和删除封存文件中的 key
+	assert.True(t, tiered.Put([]byte("key-005"), &data.LogRecordPos{Fid: 1, Offset: 100}))
+	assert.True(t, tiered.Delete([]byte("key-006")))
+	assert.True(t, tiered.Put([]byte("key-100"), &data.LogRecordPos{Fid: 1, Offset: 200}))
+
+	check := func() {
+		assert.Equal(t, int64(3), tiered.Get([]byte("key-003")).Offset)
+		assert.Equal(t, int64(100), tiered.Get([]byte("key-005")).Offset)
+		assert.Nil(t, tiered.Get([]byte("key-006")))
+		assert.Equal(t, int64(200), tiered.Get([]byte("key-100")).Offset)
+		assert.Nil(t, tiered.Get([]byte("key-050")))
+		assert.Nil(t, tiered.Get([]byte("a")))
+		assert.Equal(t, 20, tiered.Size())
+	}
+	check()
+	sealTiered(t, tiered, dir, 1)
+	assert.Equal(t, 0, tiered.mem.Len())
+	check()
+
+	// 重新加载 key 文件
+	loaded := NewTiered(4)
+	defer loaded.Close()
+	for _, fid := range []uint32{1, 0} {
+		keyFile, err := data.OpenKeyFile(dir, fid)
+		assert.Nil(t, err)
+		assert.Nil(t, loaded.Load(fid, keyFile))
+	}
+	tiered = loaded
+	check()
+}
+
+func TestTiered_LoadIncomplete(t *testing.T) {
+	dir := t.TempDir()
+	keyFile, err := data.OpenKeyFile(dir, 0)
+	assert.Nil(t, err)
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   []byte("key"),
+		Value: data.EncodeLogRecordPos(&data.LogRecordPos{Fid: 0, Offset: 0}),
+	})
+	assert.Nil(t, keyFile.Write(encRecord))
+
+	tiered := NewTiered(4)
+	assert.Equal(t, ErrKeyFileIncomplete, tiered.Load(0, keyFile))
+	assert.Nil(t, keyFile.Close())
+}
+
+func TestTiered_Iterator(t *testing.T) {
+	dir := t.TempDir()
+	tiered := NewTiered(3)
+	defer tiered.Close()
+
+	for i := 0; i < 10; i += 2 {
+		tiered.Put([]byte(fmt.Sprintf("key-%d", i)), &data.LogRecordPos{Fid: 0, Offset: int64(i)})
+	}
+	sealTiered(t, tiered, dir, 0)
+	for i := 1; i < 10; i += 2 {
+		tiered.Put([]byte(fmt.Sprintf("key-%d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	sealTiered(t, tiered, dir, 1)
+	tiered.Put([]byte("key-4"), &data.LogRecordPos{Fid: 2, Offset: 40})
+	tiered.Delete([]byte("key-7"))
+
+	collect := func(iter Iterator) []string {
+		var keys []string
+		for ; iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return keys
+	}
+
+	iter := tiered.Iterator(false)
+	iter.Rewind()
+	assert.Equal(t, []string{"key-0", "key-1", "key-2", "key-3", "key-4", "key-5", "key-6", "key-8", "key-9"}, collect(iter))
+	iter.Seek([]byte("key-4"))
+	assert.True(t, iter.Valid())
+	assert.Equal(t, int64(40), iter.Value().Offset)
+	iter.Seek([]byte("key-65"))
+	assert.Equal(t, []string{"key-8", "key-9"}, collect(iter))
+	iter.Close()
+
+	iter = tiered.Iterator(true)
+	iter.Rewind()
+	assert.Equal(t, []string{"key-9", "key-8", "key-6", "key-5", "key-4", "key-3", "key-2", "key-1", "key-0"}, collect(iter))
+	iter.Seek([]byte("key-65"))
+	assert.Equal(t, []string{"key-6", "key-5", "key-4", "key-3", "key-2", "key-1", "key-0"}, collect(iter))
+	iter.Seek([]byte("a"))
+	assert.False(t, iter.Valid())
+	iter.Close()
+}
+
+func TestTiered_Size(t *testing.T) {
+	dir := t.TempDir()
+	tiered := NewTiered(4)
+	defer tiered.Close()
+
+	for i := 0; i < 10; i++ {
+		tiered.Put([]byte(fmt.Sprintf("key-%d", i)), &data.LogRecordPos{Fid: 0, Offset: int64(i)})
+	}
+	assert.Equal(t, 10, tiered.Size())
+	sealTiered(t, tiered, dir, 0)
+	assert.Equal(t, 10, tiered.Size())
+
+	// 数量已知之后随写入和删除维护，覆盖和删除不存在的 key 不改变数量
+	tiered.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 1})
+	tiered.Put([]byte("key-10"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	tiered.Delete([]byte("key-2"))
+	tiered.Delete([]byte("key-2"))
+	tiered.Delete([]byte("not-exist"))
+	assert.Equal(t, 10, tiered.Size())
+	sealTiered(t, tiered, dir, 1)
+	tiered.Delete([]byte("key-10"))
+	tiered.Put([]byte("key-2"), &data.LogRecordPos{Fid: 2, Offset: 2})
+	assert.Equal(t, 10, tiered.Size())
+
+	count := 0
+	iter := tiered.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, count, tiered.Size())
+}
+
+func TestTiered_KeyFileCorrupted(t *testing.T) {
+	dir := t.TempDir()
+	tiered := NewTiered(4)
+	defer tiered.Close()
+
+	for i := 0; i < 8; i++ {
+		tiered.Put([]byte(fmt.Sprintf("key-%d", i)), &data.LogRecordPos{Fid: 0, Offset: int64(i)})
+	}
+	sealTiered(t, tiered, dir, 0)
+
+	// 篡改第二个块中的数据
+	fd, err := os.OpenFile(data.GetKeyFileName(dir, 0), os.O_RDWR, 0)
+	assert.Nil(t, err)
+	offset := tiered.tables[0].blocks[1].offset + 8
+	b := make([]byte, 1)
+	_, err = fd.ReadAt(b, offset)
+	assert.Nil(t, err)
+	b[0] ^= 0xff
+	_, err = fd.WriteAt(b, offset)
+	assert.Nil(t, err)
+	assert.Nil(t, fd.Close())
+
+	pos, err := tiered.GetErr([]byte("key-1"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), pos.Offset)
+	_, err = tiered.GetErr([]byte("key-5"))
+	assert.NotNil(t, err)
+	assert.Nil(t, tiered.Get([]byte("key-5")))
+
+	// 读取出错时迭代器失效，并返回错误
+	iter := tiered.Iterator(false)
+	defer iter.Close()
+	count := 0
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		count++
+	}
+	assert.Equal(t, 4, count)
+	assert.NotNil(t, iter.(ErrIterator).Err())
+	iter.Seek([]byte("key-0"))
+	assert.True(t, iter.Valid())
+	assert.Nil(t, iter.(ErrIterator).Err())
+}
@@ -3,6 +3,7 @@ package bitcask_kv_go
 import (
 	"bitcask-kv-go/index"
 	"bytes"
+	"sync"
 )
 
 // 迭代器
@@ -10,16 +11,32 @@ type Iterator struct {
 	indexIter index.Iterator // 索引迭代器
 	db        *DB
 	options   IteratorOptions
+	blobPins  *sync.WaitGroup // 迭代器关闭之前固定的 blob 回收周期，快照中的 blob 引用一直有效
+
+	lower          []byte // 合并前缀之后的下界
+	lowerExclusive bool
+	upper          []byte // 合并前缀之后的上界
+	upperInclusive bool
+
+	count int  // 已经遍历的 key 数量，用于 Limit
+	done  bool // 是否已经越过了遍历范围的终点
 }
 
 // 初始化迭代器
+// 读取 value 的迭代器关闭之前 GCBlobs 不会删除快照中引用的 blob 文件，使用完之后需要及时调用 Close
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
-	indexIter := db.index.Iterator(opts.Reverse)
-	iter := &Iterator{
-		db:        db,
-		indexIter: indexIter,
-		options:   opts,
+	iter := &Iterator{db: db, options: opts}
+	if opts.KeyOnly {
+		// 只遍历 key 时不读取 value，持有锁的调用方也可以创建
+		iter.indexIter = db.index.Iterator(opts.Reverse)
+	} else {
+		// 在同一个临界区内创建索引快照和固定回收周期
+		db.mu.RLock()
+		iter.indexIter = db.index.Iterator(opts.Reverse)
+		iter.blobPins = db.pinBlobsLocked()
+		db.mu.RUnlock()
 	}
+	iter.initBounds()
 
 	// 创建迭代器后，立即 Rewind，使其定位到正确的起始位置
 	iter.Rewind()
@@ -28,24 +45,27 @@ func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
 
 // 重新回到迭代器的起点，即第一个数据
 func (it *Iterator) Rewind() {
-	it.indexIter.Rewind()
-	it.skipToNext()
+	it.seek(nil)
 }
 
 // 根据传入的 key 查找到第一个大于（或小于）等于的目标 key，从这个 key 开始遍历
+// 传入的 key 位于遍历范围之外时，从范围的起点开始遍历
 func (it *Iterator) Seek(key []byte) {
-	it.indexIter.Seek(key)
-	it.skipToNext()
+	it.seek(key)
 }
 
 // 跳转到下一个 key
 func (it *Iterator) Next() {
 	it.indexIter.Next()
-	it.skipToNext()
+	it.count++
+	it.settle()
 }
 
 // 是否有效，即是否已经遍历完了所有的 key，用于退出遍历
 func (it *Iterator) Valid() bool {
+	if it.done || (it.options.Limit > 0 && it.count >= it.options.Limit) {
+		return false
+	}
 	return it.indexIter.Valid()
 }
 
@@ -56,28 +76,123 @@ func (it *Iterator) Key() []byte {
 
 // 当前遍历位置的 Value 数据
 func (it *Iterator) Value() ([]byte, error) {
+	if it.options.KeyOnly {
+		return nil, ErrKeyOnlyIterator
+	}
+	return it.db.getValueByPositionUnlocked(it.indexIter.Value())
+}
+
+// 当前遍历位置数据的元信息
+func (it *Iterator) Meta() (*RecordMeta, error) {
 	logRecordPos := it.indexIter.Value()
-	it.db.mu.RLock()
-	defer it.db.mu.RUnlock()
-	return it.db.getValueByPosition(logRecordPos)
+	logRecord, err := it.db.getLogRecordByPositionUnlocked(logRecordPos)
+	if err != nil {
+		return nil, err
+	}
+	return newRecordMeta(logRecord, logRecordPos), nil
+}
+
+// Err 遍历过程中读取索引遇到的错误，低内存模式和 B+ 树索引需要从磁盘读取索引，读取失败时迭代器提前结束
+// 遍历结束之后需要检查，否则无法区分遍历完成和读取出错
+func (it *Iterator) Err() error {
+	if errIter, ok := it.indexIter.(index.ErrIterator); ok {
+		return errIter.Err()
+	}
+	return nil
 }
 
 // 关闭迭代器，释放相应资源
 func (it *Iterator) Close() {
 	it.indexIter.Close()
+	if it.blobPins != nil {
+		it.blobPins.Done()
+		it.blobPins = nil
+	}
 }
 
-// 跳转到下一个符合前缀的 key
-func (it *Iterator) skipToNext() {
-	prefixLen := len(it.options.Prefix)
-	if prefixLen == 0 {
+// 将前缀转换为范围，和 LowerBound、UpperBound 合并成最终的遍历范围
+func (it *Iterator) initBounds() {
+	opts := it.options
+	it.lower, it.lowerExclusive = opts.LowerBound, opts.LowerExclusive
+	it.upper, it.upperInclusive = opts.UpperBound, opts.UpperInclusive
+	if len(opts.Prefix) == 0 {
 		return
 	}
 
+	// 前缀为 p 的 key 都位于 [p, p 的后继) 范围内
+	if cmp := bytes.Compare(opts.Prefix, it.lower); it.lower == nil || cmp > 0 {
+		it.lower, it.lowerExclusive = opts.Prefix, false
+	}
+	if succ := prefixSuccessor(opts.Prefix); succ != nil {
+		if cmp := bytes.Compare(succ, it.upper); it.upper == nil || cmp < 0 || (cmp == 0 && it.upperInclusive) {
+			it.upper, it.upperInclusive = succ, false
+		}
+	}
+}
+
+// 定位到遍历范围的起点，key 不为 nil 时从 key 和范围起点中更靠后的一个开始
+func (it *Iterator) seek(key []byte) {
+	it.count = 0
+	it.done = false
+
+	start := it.lower
+	if it.options.Reverse {
+		start = it.upper
+	}
+	if key != nil && (start == nil || it.options.Reverse == (bytes.Compare(key, start) < 0)) {
+		start = key
+	}
+	if start == nil {
+		it.indexIter.Rewind()
+	} else {
+		it.indexIter.Seek(start)
+	}
+	it.settle()
+}
+
+// 跳过位于范围起点之前的 key，越过范围终点时停止遍历
+func (it *Iterator) settle() {
 	for ; it.indexIter.Valid(); it.indexIter.Next() {
 		key := it.indexIter.Key()
-		if prefixLen <= len(key) && bytes.Compare(it.options.Prefix, key[:prefixLen]) == 0 {
-			break
+		beforeStart, afterEnd := it.belowLower(key), it.aboveUpper(key)
+		if it.options.Reverse {
+			beforeStart, afterEnd = afterEnd, beforeStart
+		}
+		if afterEnd {
+			it.done = true
+			return
+		}
+		if !beforeStart {
+			return
+		}
+	}
+}
+
+func (it *Iterator) belowLower(key []byte) bool {
+	if it.lower == nil {
+		return false
+	}
+	cmp := bytes.Compare(key, it.lower)
+	return cmp < 0 || (cmp == 0 && it.lowerExclusive)
+}
+
+func (it *Iterator) aboveUpper(key []byte) bool {
+	if it.upper == nil {
+		return false
+	}
+	cmp := bytes.Compare(key, it.upper)
+	return cmp > 0 || (cmp == 0 && !it.upperInclusive)
+}
+
+// 返回大于所有以 prefix 为前缀的 key 的最小值，prefix 全部为 0xff 时不存在，返回 nil
+func prefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			succ := make([]byte, i+1)
+			copy(succ, prefix)
+			succ[i]++
+			return succ
 		}
 	}
+	return nil
 }
@@ -30,6 +30,12 @@ func TestIterator_CRUD(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, []byte("value1"), val)
 
+	// Test Meta
+	meta, err := iter.Meta()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len("value1")), meta.ValueSize)
+	assert.False(t, meta.Timestamp.IsZero())
+
 	// Test Next
 	iter.Next()
 	assert.True(t, iter.Valid())
@@ -123,3 +129,67 @@ func TestIterator_PrefixScan(t *testing.T) {
 		assert.Equal(t, []byte("b/1"), iter.Key())
 	})
 }
+
+func TestIterator_Bounds(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+
+	for _, key := range []string{"a", "b", "c", "d", "e", "ea", "eb", "f"} {
+		assert.Nil(t, db.Put([]byte(key), []byte(key)))
+	}
+	collect := func(opts IteratorOptions) []string {
+		var keys []string
+		iter := db.NewIterator(opts)
+		defer iter.Close()
+		for ; iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		return keys
+	}
+
+	t.Run("default bounds", func(t *testing.T) {
+		opts := IteratorOptions{LowerBound: []byte("b"), UpperBound: []byte("e")}
+		assert.Equal(t, []string{"b", "c", "d"}, collect(opts))
+		opts.Reverse = true
+		assert.Equal(t, []string{"d", "c", "b"}, collect(opts))
+	})
+
+	t.Run("exclusive lower and inclusive upper", func(t *testing.T) {
+		opts := IteratorOptions{LowerBound: []byte("b"), LowerExclusive: true, UpperBound: []byte("e"), UpperInclusive: true}
+		assert.Equal(t, []string{"c", "d", "e"}, collect(opts))
+		opts.Reverse = true
+		assert.Equal(t, []string{"e", "d", "c"}, collect(opts))
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		opts := IteratorOptions{LowerBound: []byte("c"), Limit: 2}
+		assert.Equal(t, []string{"c", "d"}, collect(opts))
+		opts.Reverse = true
+		assert.Equal(t, []string{"f", "eb"}, collect(opts))
+	})
+
+	t.Run("prefix with bounds", func(t *testing.T) {
+		assert.Equal(t, []string{"e", "ea", "eb"}, collect(IteratorOptions{Prefix: []byte("e")}))
+		assert.Equal(t, []string{"eb", "ea", "e"}, collect(IteratorOptions{Prefix: []byte("e"), Reverse: true}))
+		assert.Equal(t, []string{"ea"}, collect(IteratorOptions{Prefix: []byte("e"), LowerBound: []byte("e"), LowerExclusive: true, UpperBound: []byte("eb")}))
+	})
+
+	t.Run("seek", func(t *testing.T) {
+		iter := db.NewIterator(IteratorOptions{LowerBound: []byte("b"), UpperBound: []byte("e")})
+		defer iter.Close()
+		// 范围之前的 key 定位到范围的起点
+		iter.Seek([]byte("a"))
+		assert.Equal(t, []byte("b"), iter.Key())
+		iter.Seek([]byte("cc"))
+		assert.Equal(t, []byte("d"), iter.Key())
+		iter.Seek([]byte("e"))
+		assert.False(t, iter.Valid())
+	})
+}
+
+func TestPrefixSuccessor(t *testing.T) {
+	assert.Equal(t, []byte("b"), prefixSuccessor([]byte("a")))
+	assert.Equal(t, []byte{'a', 0x01}, prefixSuccessor([]byte{'a', 0x00}))
+	assert.Equal(t, []byte("b"), prefixSuccessor([]byte{'a', 0xff}))
+	assert.Nil(t, prefixSuccessor([]byte{0xff, 0xff}))
+}
@@ -0,0 +1,94 @@
+package bitcask_kv_go
+
+import (
+	"bitcask-kv-go/data"
+	"bitcask-kv-go/index"
+	"os"
+)
+
+// 根据配置初始化索引，低内存模式使用分层索引
+func newIndexer(options Options) (index.Indexer, error) {
+	switch {
+	case options.LowMemory:
+		return index.NewTiered(options.KeyFileBlockSize), nil
+	case options.IndexType == BPTree:
+		return openBPlusTree(options.DirPath)
+	default:
+		return index.NewIndexer(options.IndexType), nil
+	}
+}
+
+// 封存所有等待封存的数据文件，为其生成 key 文件
+// 需要在写操作完成、索引更新之后调用，保证跨越多个文件的事务已经全部进入索引
+// 在访问此方法前必须持有互斥锁
+func (db *DB) sealFilesLocked() error {
+	for len(db.pendingSeals) > 0 {
+		if err := db.sealFileLocked(db.pendingSeals[0]); err != nil {
+			return err
+		}
+		db.pendingSeals = db.pendingSeals[1:]
+	}
+	return nil
+}
+
+// 将内存索引中位于该数据文件及之前的 key 写入 key 文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) sealFileLocked(fid uint32) error {
+	tiered, ok := db.index.(*index.Tiered)
+	if !ok {
+		return nil
+	}
+	// 清理上次没有写完的 key 文件
+	fileName := data.GetKeyFileName(db.options.DirPath, fid)
+	if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	keyFile, err := data.OpenKeyFile(db.options.DirPath, fid)
+	if err != nil {
+		return err
+	}
+	if err := db.initFileHeader(keyFile); err != nil {
+		_ = keyFile.Close()
+		return err
+	}
+	if err := tiered.Seal(fid, keyFile, db.version); err != nil {
+		_ = keyFile.Close()
+		return err
+	}
+	return nil
+}
+
+// 加载数据文件对应的 key 文件，key 文件不存在或者不完整时返回 false，需要重新读取数据文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) loadKeyFileLocked(fid uint32) (bool, error) {
+	tiered, ok := db.index.(*index.Tiered)
+	if !ok {
+		return false, nil
+	}
+	fileName := data.GetKeyFileName(db.options.DirPath, fid)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return false, nil
+	}
+	// 更早的数据文件需要先封存，保证 key 文件按照文件 id 的顺序生成
+	if err := db.sealFilesLocked(); err != nil {
+		return false, err
+	}
+
+	keyFile, err := data.OpenKeyFile(db.options.DirPath, fid)
+	if err != nil {
+		return false, err
+	}
+	if err := db.initFileHeader(keyFile); err != nil {
+		_ = keyFile.Close()
+		return false, err
+	}
+	if err := tiered.Load(fid, keyFile); err != nil {
+		_ = keyFile.Close()
+		if err == index.ErrKeyFileIncomplete {
+			return false, os.Remove(fileName)
+		}
+		return false, err
+	}
+	db.updateVersionLocked(tiered.MaxVersion())
+	return true, nil
+}
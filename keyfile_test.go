@@ -0,0 +1,166 @@
+package bitcask_kv_go
+
+import (
+	"bitcask-kv-go/data"
+	"bitcask-kv-go/index"
+	"bitcask-kv-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func initLowMemoryDB(t *testing.T) (*DB, Options) {
+	t.Helper()
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.DataFileSize = 16 * 1024
+	opts.LowMemory = true
+	opts.KeyFileBlockSize = 8
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	return db, opts
+}
+
+func TestDB_LowMemory(t *testing.T) {
+	db, opts := initLowMemoryDB(t)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(32)))
+	}
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	for i := 100; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("updated")))
+	}
+
+	// 已封存的数据文件都有对应的 key 文件，内存中只保留未封存的 key
+	assert.Greater(t, len(db.olderFiles), 1)
+	for fid := range db.olderFiles {
+		_, err := os.Stat(data.GetKeyFileName(opts.DirPath, fid))
+		assert.Nil(t, err)
+	}
+
+	check := func(db *DB) {
+		for i := 0; i < 1000; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			switch {
+			case i < 100:
+				assert.Equal(t, ErrKeyNotFound, err)
+			case i < 200:
+				assert.Nil(t, err)
+				assert.Equal(t, []byte("updated"), val)
+			default:
+				assert.Nil(t, err)
+				assert.NotNil(t, val)
+			}
+		}
+		assert.Equal(t, 900, len(db.ListKeys()))
+
+		iter := db.NewIterator(IteratorOptions{Reverse: true})
+		defer iter.Close()
+		var count int
+		var prev []byte
+		for ; iter.Valid(); iter.Next() {
+			if prev != nil {
+				assert.Less(t, string(iter.Key()), string(prev))
+			}
+			prev = iter.Key()
+			count++
+		}
+		assert.Equal(t, 900, count)
+	}
+	check(db)
+
+	// 重启之后从 key 文件加载索引
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	check(db2)
+
+	// merge 之后同样生成 key 文件
+	assert.Nil(t, db2.Merge())
+	assert.Nil(t, db2.Close())
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	defer db3.Close()
+	check(db3)
+}
+
+func TestDB_LowMemoryWriteBatch(t *testing.T) {
+	db, opts := initLowMemoryDB(t)
+
+	// 事务跨越多个数据文件
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, wb.Commit())
+	assert.Greater(t, len(db.olderFiles), 1)
+
+	assert.Nil(t, db.Close())
+	// 删除 key 文件之后重新打开，会根据数据文件重新生成
+	for fid := range db.olderFiles {
+		assert.Nil(t, os.Remove(data.GetKeyFileName(opts.DirPath, fid)))
+	}
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer db2.Close()
+	_, ok := db2.index.(*index.Tiered)
+	assert.True(t, ok)
+	for i := 0; i < 500; i++ {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+}
+
+func TestDB_LowMemoryOptions(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.LowMemory = true
+	opts.KeepHistory = true
+	_, err := Open(opts)
+	assert.Equal(t, ErrLowMemoryOptionsInvalid, err)
+}
+
+func TestDB_LowMemoryKeyFileCorrupted(t *testing.T) {
+	db, opts := initLowMemoryDB(t)
+	defer db.Close()
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(32)))
+	}
+	assert.Greater(t, len(db.olderFiles), 1)
+
+	// 篡改第一个 key 文件中间的数据
+	fileName := data.GetKeyFileName(opts.DirPath, 0)
+	stat, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	fd, err := os.OpenFile(fileName, os.O_RDWR, 0)
+	assert.Nil(t, err)
+	b := make([]byte, 16)
+	_, err = fd.ReadAt(b, stat.Size()/2)
+	assert.Nil(t, err)
+	for i := range b {
+		b[i] ^= 0xff
+	}
+	_, err = fd.WriteAt(b, stat.Size()/2)
+	assert.Nil(t, err)
+	assert.Nil(t, fd.Close())
+
+	// 读取 key 文件出错时返回错误，而不是当作 key 不存在
+	var failed int
+	for i := 0; i < 500; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.NotEqual(t, ErrKeyNotFound, err)
+		if err != nil {
+			failed++
+		}
+	}
+	assert.Greater(t, failed, 0)
+
+	err = db.Fold(func(key []byte, value []byte) bool { return true })
+	assert.NotNil(t, err)
+}
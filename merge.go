@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"time"
 )
 
 const (
@@ -27,30 +28,46 @@ func (db *DB) Merge() error {
 		db.mu.Unlock()
 		return ErrMergeIsProgress
 	}
+	// 流式写入的分块已经写入但清单还没有写入时，merge 会丢弃这些分块
+	if db.streamWriters > 0 {
+		db.mu.Unlock()
+		return ErrStreamWriteInProgress
+	}
+	// 范围删除记录已经写入但索引中还有没删除的 key 时，merge 会把这些 key 当作有效数据重写
+	if db.rangeDeleters > 0 {
+		db.mu.Unlock()
+		return ErrRangeDeleteInProgress
+	}
 	db.isMerging = true
 	defer func() {
 		db.isMerging = false
 	}()
 
-	// 持久化当前活跃文件
-	if err := db.activeFile.Sync(); err != nil {
+	// 持久化当前活跃文件，将其转换为旧的数据文件，并打开新的活跃文件
+	if err := db.rotateActiveFileLocked(); err != nil {
 		db.mu.Unlock()
 		return err
 	}
-	// 将当前活跃文件转换为旧的数据文件
-	db.olderFiles[db.activeFile.FileId] = db.activeFile
-	// 打开新的活跃文件
-	if err := db.setActiveDataFile(); err != nil {
+	if err := db.sealFilesLocked(); err != nil {
 		db.mu.Unlock()
 		return err
 	}
-	// 记录最近没有参与 merge 的文件 id
+	// 持久化索引的检查点需要位于 merge 的文件之后，保证重新打开时不会读取被 merge 重写的文件
+	if err := db.commitIndexLocked(true); err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	// 记录最近没有参与 merge 的文件 id，以及 merge 的文件中可能出现的最大版本号
 	nonMergeFileId := db.activeFile.FileId
+	mergeVersion := db.version
+	db.dedupBoundary = nonMergeFileId
 
 	// 取出所有需要 merge 的文件
 	var mergeFiles []*data.DataFile
+	mergeFileMap := make(map[uint32]*data.DataFile, len(db.olderFiles))
 	for _, file := range db.olderFiles {
 		mergeFiles = append(mergeFiles, file)
+		mergeFileMap[file.FileId] = file
 	}
 	db.mu.Unlock()
 
@@ -74,16 +91,80 @@ func (db *DB) Merge() error {
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
 	mergeOptions.SyncWrites = false
+	// blob 文件不参与 merge，数据文件中的 blob 位置直接保留
+	mergeOptions.BlobThreshold = 0
+	// 临时实例不需要缓存 value
+	mergeOptions.ValueCacheSize = 0
+	// 临时实例的索引不需要持久化
+	if mergeOptions.IndexType == BPTree {
+		mergeOptions.IndexType = BTree
+	}
 	mergeDB, err := Open(mergeOptions)
 	if err != nil {
 		return err
 	}
+	// 出错返回时关闭临时实例，正常结束时在标记 merge 完成之前关闭
+	defer func() {
+		if mergeDB != nil {
+			_ = mergeDB.Close()
+		}
+	}()
 
 	// 打开 hint 文件存储索引
 	hintFile, err := data.OpenHintFile(mergePath)
 	if err != nil {
 		return err
 	}
+	defer func() { _ = hintFile.Close() }()
+	if err := db.initFileHeader(hintFile); err != nil {
+		return err
+	}
+	// 开启历史版本时，记录每个 key 在 merge 之后最新版本的位置，用于重新串联版本链
+	mergedHeads := make(map[string]*data.LogRecordPos)
+	// 保留在这个时间之后写入的历史版本
+	historyCutoff := time.Now().Add(-db.options.HistoryRetention).UnixNano()
+	// 暂存事务中需要保留的历史版本，事务完成之后才写入
+	txnHistory := make(map[uint64][]*data.LogRecord)
+	// 已经重写的共享 value 在 merge 之后的位置，key 为 value 的哈希
+	mergedDedupValues := make(map[string]*data.LogRecordPos)
+
+	rewrite := func(realKey []byte, logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+		// 清除事务标记
+		logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+		logRecord.Prev = mergedHeads[string(realKey)]
+		// 引用的共享 value 需要先重写，并更新引用中的位置
+		if logRecord.Flags&data.FlagDedupRef != 0 {
+			ref, err := db.rewriteDedupValue(mergeDB, mergeFileMap, hintFile, mergedDedupValues, logRecord.Value)
+			if err != nil {
+				return nil, err
+			}
+			logRecord.Value = ref
+		}
+		// 流式写入的 value 需要先重写所有的分块
+		if logRecord.Flags&data.FlagStreamRef != 0 {
+			manifest, err := db.rewriteStreamChunks(mergeDB, mergeFileMap, realKey, logRecord.Value)
+			if err != nil {
+				return nil, err
+			}
+			logRecord.Value = manifest
+		}
+		pos, err := mergeDB.appendLogRecordLocked(logRecord)
+		if err != nil {
+			return nil, err
+		}
+		if db.options.KeepHistory {
+			mergedHeads[string(realKey)] = pos
+		}
+		// 低内存模式下 merge 之后的数据文件同样需要生成 key 文件
+		if db.options.LowMemory {
+			mergeDB.updateIndexLocked(realKey, logRecord, pos)
+			if err := mergeDB.sealFilesLocked(); err != nil {
+				return nil, err
+			}
+		}
+		return pos, nil
+	}
+
 	// 遍历处理每个数据文件
 	for _, dataFile := range mergeFiles {
 		var offset int64 = 0
@@ -95,23 +176,73 @@ func (db *DB) Merge() error {
 				}
 				return err
 			}
+			// 文件头不需要重写，merge 之后的文件会使用当前的密钥
+			// 流式写入的分块在重写引用它的清单时一起重写
+			if logRecord.Type == data.LogRecordFileHeader || logRecord.Type == data.LogRecordValueChunk {
+				offset += size
+				continue
+			}
 			// 解析拿到实际的 key
-			realKey, _ := parseLogRecordKey(logRecord.Key)
-			logRecordPos := db.index.Get(realKey)
+			realKey, seqNo := parseLogRecordKey(logRecord.Key)
+			// 共享的 value 在重写引用它的记录时一起重写，没有被保留的记录引用的 value 被清理
+			if logRecord.Type == data.LogRecordDedupValue {
+				offset += size
+				continue
+			}
+			// 读取索引失败时不能把记录当作无效数据丢弃
+			logRecordPos, err := db.getIndexPos(realKey)
+			if err != nil {
+				return err
+			}
 			// 和内存中的索引位置进行比较，如果有效则重写
 			if logRecordPos != nil &&
 				logRecordPos.Fid == dataFile.FileId &&
 				logRecordPos.Offset == offset {
-				// 清除事务标记
-				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
-				pos, err := mergeDB.appendLogRecordLocked(logRecord)
+				pos, err := rewrite(realKey, logRecord)
 				if err != nil {
 					return err
 				}
-				// 将当前位置索引写到 Hint 文件当中
-				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
+				// 将当前位置索引写到 Hint 文件当中，引用了共享 value 的 key 同时记录 value 的哈希
+				if logRecord.Flags&data.FlagDedupRef != 0 {
+					err = hintFile.WriteDedupRefHintRecord(realKey, pos, dedupRefHash(logRecord))
+				} else {
+					err = hintFile.WriteHintRecord(realKey, data.LogRecordNormal, pos)
+				}
+				if err != nil {
 					return err
 				}
+			} else if db.options.KeepHistory {
+				// 保留时间范围内的历史版本
+				switch {
+				case logRecord.Type == data.LogRecordTxnFinished:
+					for _, txnRecord := range txnHistory[seqNo] {
+						if _, err := rewrite(txnRecord.Key, txnRecord); err != nil {
+							return err
+						}
+					}
+					delete(txnHistory, seqNo)
+				case logRecord.Type == data.LogRecordRangeDeleted:
+					if err := db.rewriteRangeDeleted(mergeDB, hintFile, mergedHeads, logRecord, dataFile.FileId, offset, historyCutoff); err != nil {
+						return err
+					}
+				case logRecord.Timestamp < historyCutoff:
+				case db.isDeletedHead(realKey, dataFile.FileId, offset):
+					// 已删除 key 的删除记录是版本链的起点，同样需要写入 Hint 文件
+					pos, err := rewrite(realKey, logRecord)
+					if err != nil {
+						return err
+					}
+					if err := hintFile.WriteHintRecord(realKey, data.LogRecordDeleted, pos); err != nil {
+						return err
+					}
+				case seqNo == nonTransactionSeqNo:
+					if _, err := rewrite(realKey, logRecord); err != nil {
+						return err
+					}
+				default:
+					logRecord.Key = realKey
+					txnHistory[seqNo] = append(txnHistory[seqNo], logRecord)
+				}
 			}
 			// 增加 offset
 			offset += size
@@ -125,17 +256,37 @@ func (db *DB) Merge() error {
 	if err := mergeDB.Sync(); err != nil {
 		return err
 	}
+	// merge 之后的数据文件都是旧的数据文件，最后一个文件同样需要封存
+	if mergeDB.activeFile != nil {
+		if err := mergeDB.activeFile.WriteFooter(); err != nil {
+			return err
+		}
+	}
+	// 关闭临时实例，保证 merge 目录中的文件在被移动到数据目录之前都已经关闭
+	err = mergeDB.Close()
+	mergeDB = nil
+	if err != nil {
+		return err
+	}
 
 	// 写标识 merge 完成的文件
 	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
 	if err != nil {
 		return err
 	}
+	defer func() { _ = mergeFinishedFile.Close() }()
+	if err := db.initFileHeader(mergeFinishedFile); err != nil {
+		return err
+	}
 	mergeFinRecord := &data.LogRecord{
-		Key:   []byte(mergeFinishedKey),
-		Value: []byte(strconv.Itoa(int(nonMergeFileId))),
+		Key:     []byte(mergeFinishedKey),
+		Value:   []byte(strconv.Itoa(int(nonMergeFileId))),
+		Version: mergeVersion,
+	}
+	encRecord, _, err := mergeFinishedFile.EncodeLogRecord(mergeFinRecord)
+	if err != nil {
+		return err
 	}
-	encRecord, _ := data.EncodeLogRecord(mergeFinRecord)
 	if err := mergeFinishedFile.Write(encRecord); err != nil {
 		return err
 	}
@@ -146,6 +297,89 @@ func (db *DB) Merge() error {
 	return nil
 }
 
+// merge 时将引用的共享 value 重写到 merge 之后的数据文件中，返回更新了位置的引用
+// 相同的 value 只重写一次，被多个 key 或者多个历史版本引用时共享同一条记录
+func (db *DB) rewriteDedupValue(mergeDB *DB, files map[uint32]*data.DataFile, hintFile *data.DataFile,
+	merged map[string]*data.LogRecordPos, ref []byte) ([]byte, error) {
+	hash, pos, err := data.DecodeDedupRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	if newPos, ok := merged[string(hash)]; ok {
+		return data.EncodeDedupRef(hash, newPos), nil
+	}
+	// 共享的 value 在引用它的记录之前写入，一定位于参与 merge 的文件中
+	dataFile := files[pos.Fid]
+	if dataFile == nil {
+		return nil, ErrDedupValueNotFound
+	}
+	valueRecord, _, err := dataFile.ReadLogRecord(pos.Offset)
+	if err != nil {
+		return nil, err
+	}
+	if valueRecord.Type != data.LogRecordDedupValue {
+		return nil, ErrDedupValueNotFound
+	}
+	valueRecord.Key = logRecordKeyWithSeq(hash, nonTransactionSeqNo)
+	newPos, err := mergeDB.appendLogRecordLocked(valueRecord)
+	if err != nil {
+		return nil, err
+	}
+	if err := hintFile.WriteHintRecord(hash, data.LogRecordDedupValue, newPos); err != nil {
+		return nil, err
+	}
+	merged[string(hash)] = newPos
+	return data.EncodeDedupRef(hash, newPos), nil
+}
+
+// 重写范围删除记录，它是范围内每个 key 的删除版本
+// 保留时间范围内的记录，以及仍然作为已删除 key 版本链起点的记录；范围内的 key 之后的版本需要指向重写之后的记录
+func (db *DB) rewriteRangeDeleted(mergeDB *DB, hintFile *data.DataFile, mergedHeads map[string]*data.LogRecordPos,
+	logRecord *data.LogRecord, fid uint32, offset int64, historyCutoff int64) error {
+	heads := db.deletedHeadKeys(fid, offset)
+	if len(heads) == 0 && logRecord.Timestamp < historyCutoff {
+		return nil
+	}
+	pos, err := mergeDB.appendLogRecordLocked(logRecord)
+	if err != nil {
+		return err
+	}
+	start, _ := parseLogRecordKey(logRecord.Key)
+	end := rangeDeleteEnd(logRecord)
+	for key := range mergedHeads {
+		if key >= string(start) && (end == nil || key < string(end)) {
+			mergedHeads[key] = pos
+		}
+	}
+	for _, key := range heads {
+		if err := hintFile.WriteHintRecord(key, data.LogRecordDeleted, pos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 获取版本链起点为指定位置的删除记录的所有 key
+func (db *DB) deletedHeadKeys(fid uint32, offset int64) [][]byte {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var keys [][]byte
+	for key, pos := range db.deletedHeads {
+		if pos.Fid == fid && pos.Offset == offset {
+			keys = append(keys, []byte(key))
+		}
+	}
+	return keys
+}
+
+// 判断指定位置的记录是否为已删除 key 的版本链起点
+func (db *DB) isDeletedHead(key []byte, fid uint32, offset int64) bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	pos := db.deletedHeads[string(key)]
+	return pos != nil && pos.Fid == fid && pos.Offset == offset
+}
+
 func (db *DB) getMergePath() string {
 	dir := path.Dir(path.Clean(db.options.DirPath))
 	base := path.Base(db.options.DirPath)
@@ -183,18 +417,23 @@ func (db *DB) loadMergeFiles() error {
 		return nil
 	}
 
-	nonMergeFileId, err := db.getNonMergeFileId(mergePath)
+	nonMergeFileId, _, err := db.getNonMergeFileId(mergePath)
 	if err != nil {
 		return err // 如果无法获取 nonMergeFileId，应该返回错误，防止数据库状态不一致
 	}
 
-	// 删除旧的数据文件
+	// 删除旧的数据文件以及对应的 key 文件
 	var fileId uint32 = 0
 	for ; fileId < nonMergeFileId; fileId++ {
-		fileName := data.GetDataFileName(db.options.DirPath, fileId)
-		if _, err := os.Stat(fileName); err == nil {
-			if err := os.Remove(fileName); err != nil {
-				return err
+		fileNames := []string{
+			data.GetDataFileName(db.options.DirPath, fileId),
+			data.GetKeyFileName(db.options.DirPath, fileId),
+		}
+		for _, fileName := range fileNames {
+			if _, err := os.Stat(fileName); err == nil {
+				if err := os.Remove(fileName); err != nil {
+					return err
+				}
 			}
 		}
 	}
@@ -210,20 +449,73 @@ func (db *DB) loadMergeFiles() error {
 	return nil
 }
 
-func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
+// 根据 hint 文件更新持久化索引中指向被 merge 的数据文件的位置
+func (db *DB) remapIndexFromHintFile(nonMergeFileId uint32) error {
+	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
+	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
+		return nil
+	}
+	hintFile, err := data.OpenHintFile(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	defer hintFile.Close()
+	if err := db.initFileHeader(hintFile); err != nil {
+		return err
+	}
+
+	var offset int64 = 0
+	for {
+		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		offset += size
+		if logRecord.Type != data.LogRecordNormal {
+			continue
+		}
+		pos, err := db.getIndexPos(logRecord.Key)
+		if err != nil {
+			return err
+		}
+		if pos != nil && pos.Fid < nonMergeFileId {
+			db.index.Put(logRecord.Key, data.DecodeHintRecordPos(logRecord))
+		}
+	}
+	return nil
+}
+
+// 读取 merge 完成标识，返回最近没有参与 merge 的文件 id，以及 merge 开始时已经分配的版本号
+// merge 会丢弃删除记录，版本号需要单独记录，否则删除之后重新写入的 key 可能得到重复的版本号
+func (db *DB) getNonMergeFileId(dirPath string) (uint32, uint64, error) {
 	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
+	}
+	defer func() { _ = mergeFinishedFile.Close() }()
+	if err := db.initFileHeader(mergeFinishedFile); err != nil {
+		return 0, 0, err
 	}
-	record, _, err := mergeFinishedFile.ReadLogRecord(0)
+	// 跳过文件头，以最初的格式写入的文件没有文件头
+	var offset int64 = 0
+	if mergeFinishedFile.FileHeader() != nil {
+		_, offset, err = mergeFinishedFile.ReadLogRecord(0)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	record, _, err := mergeFinishedFile.ReadLogRecord(offset)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 	nonMergeFileId, err := strconv.Atoi(string(record.Value))
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
-	return uint32(nonMergeFileId), nil
+	return uint32(nonMergeFileId), record.Version, nil
 }
 
 // 从 hint 文件中加载索引
@@ -239,8 +531,13 @@ func (db *DB) loadIndexFromHintFile() error {
 	if err != nil {
 		return err
 	}
+	defer hintFile.Close()
+	if err := db.initFileHeader(hintFile); err != nil {
+		return err
+	}
 
-	// 读取文件中的索引
+	// 读取文件中的索引，对共享 value 的引用在所有共享 value 加载之后再统计
+	var dedupRefs []*data.LogRecord
 	var offset int64 = 0
 	for {
 		logRecord, size, err := hintFile.ReadLogRecord(offset)
@@ -251,10 +548,33 @@ func (db *DB) loadIndexFromHintFile() error {
 			return err
 		}
 
+		if logRecord.Type == data.LogRecordFileHeader {
+			offset += size
+			continue
+		}
+
 		// 解码拿到实际的位置索引
-		pos := data.DecodeLogRecordPos(logRecord.Value)
-		db.index.Put(logRecord.Key, pos)
+		pos := data.DecodeHintRecordPos(logRecord)
+		switch {
+		case logRecord.Type == data.LogRecordDeleted:
+			db.deletedHeads[string(logRecord.Key)] = pos
+		case logRecord.Type == data.LogRecordDedupValue:
+			// hint 文件中没有记录 value 的长度，需要读取共享的 value
+			valueRecord, err := db.readLogRecord(pos)
+			if err != nil {
+				return err
+			}
+			db.putDedupValueLocked(logRecord.Key, pos, int64(len(valueRecord.Value)))
+		default:
+			db.index.Put(logRecord.Key, pos)
+			if hash := data.DedupRefHash(logRecord); hash != nil {
+				dedupRefs = append(dedupRefs, &data.LogRecord{Key: logRecord.Key, Value: hash, Flags: data.FlagDedupRef})
+			}
+		}
 		offset += size
 	}
+	for _, ref := range dedupRefs {
+		db.updateDedupRefLocked(ref.Key, ref)
+	}
 	return nil
 }
@@ -0,0 +1,158 @@
+package bitcask_kv_go
+
+import (
+	"bitcask-kv-go/data"
+	"bytes"
+	"sort"
+)
+
+const (
+	// 同一个文件中两条记录的间隔不超过该值时合并为一次读取
+	multiGetMaxGap = 4 * 1024
+	// 合并之后单次读取的最大长度
+	multiGetMaxSpan = 1024 * 1024
+)
+
+// GetResult MultiGet 中单个 key 的读取结果
+type GetResult struct {
+	Value []byte
+	Err   error // key 不存在时为 ErrKeyNotFound
+}
+
+// 一次待读取的记录
+type multiGetRead struct {
+	idx int // 在输入 keys 中的下标
+	pos *data.LogRecordPos
+}
+
+// MultiGet 批量读取多个 key，结果和 keys 的顺序一一对应
+// 所有 key 的位置在同一把锁内从索引中获取，读取按照文件 id 和偏移排序，相邻的记录合并为一次读取，读取文件时不持有锁
+func (db *DB) MultiGet(keys [][]byte) []GetResult {
+	results := make([]GetResult, len(keys))
+	if len(keys) == 0 {
+		return results
+	}
+
+	db.mu.RLock()
+	reads := make([]multiGetRead, 0, len(keys))
+	for i, key := range keys {
+		if len(key) == 0 {
+			results[i].Err = ErrKeyIsEmpty
+			continue
+		}
+		pos, err := db.getIndexPos(key)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		if pos == nil {
+			results[i].Err = ErrKeyNotFound
+			continue
+		}
+		if value, ok := db.getCachedValue(pos); ok {
+			results[i].Value = bytes.Clone(value)
+			continue
+		}
+		reads = append(reads, multiGetRead{idx: i, pos: pos})
+	}
+	blobPins := db.pinBlobsLocked()
+	db.mu.RUnlock()
+	defer blobPins.Done()
+
+	sort.Slice(reads, func(i, j int) bool {
+		a, b := reads[i].pos, reads[j].pos
+		if a.Fid != b.Fid {
+			return a.Fid < b.Fid
+		}
+		return a.Offset < b.Offset
+	})
+
+	for start := 0; start < len(reads); {
+		end := db.multiGetSpanEnd(reads, start)
+		db.readMultiGetSpan(reads[start:end], results)
+		start = end
+	}
+	return results
+}
+
+// 从 start 开始，找到可以合并为一次读取的记录范围 [start, end)
+// 没有记录长度的位置信息（由旧版本写入）只能单独读取
+func (db *DB) multiGetSpanEnd(reads []multiGetRead, start int) int {
+	first := reads[start].pos
+	if first.Size == 0 {
+		return start + 1
+	}
+	spanEnd := first.Offset + int64(first.Size)
+	end := start + 1
+	for ; end < len(reads); end++ {
+		pos := reads[end].pos
+		if pos.Fid != first.Fid || pos.Size == 0 || pos.Offset-spanEnd > multiGetMaxGap {
+			break
+		}
+		recordEnd := pos.Offset + int64(pos.Size)
+		if recordEnd > spanEnd {
+			if recordEnd-first.Offset > multiGetMaxSpan {
+				break
+			}
+			spanEnd = recordEnd
+		}
+	}
+	return end
+}
+
+// 读取一组相邻的记录并填充结果，调用方不能持有锁
+func (db *DB) readMultiGetSpan(reads []multiGetRead, results []GetResult) {
+	if len(reads) == 1 {
+		logRecord, err := db.getLogRecordByPositionUnlocked(reads[0].pos)
+		if err != nil {
+			results[reads[0].idx].Err = err
+			return
+		}
+		results[reads[0].idx].Value = logRecord.Value
+		db.cacheValue(reads[0].pos, logRecord.Value)
+		return
+	}
+
+	first := reads[0].pos
+	dataFile, err := db.pinDataFile(first.Fid)
+	if err != nil {
+		for _, read := range reads {
+			results[read.idx].Err = err
+		}
+		return
+	}
+	last := reads[len(reads)-1].pos
+	spanEnd := last.Offset + int64(last.Size)
+	for _, read := range reads {
+		if end := read.pos.Offset + int64(read.pos.Size); end > spanEnd {
+			spanEnd = end
+		}
+	}
+	buf, err := dataFile.ReadBytes(first.Offset, spanEnd-first.Offset)
+	dataFile.Unpin()
+	if err != nil {
+		for _, read := range reads {
+			results[read.idx].Err = err
+		}
+		return
+	}
+
+	for _, read := range reads {
+		// 每条记录使用单独的内存，返回的 value 之间互不影响，解密和解压也不会改写其他记录的数据
+		begin := read.pos.Offset - first.Offset
+		recordBuf := append([]byte(nil), buf[begin:begin+int64(read.pos.Size)]...)
+		logRecord, _, err := dataFile.DecodeLogRecord(recordBuf)
+		if err == nil && logRecord.Type == data.LogRecordDeleted {
+			err = ErrKeyNotFound
+		}
+		if err == nil {
+			err = db.resolveValueUnlocked(logRecord)
+		}
+		if err != nil {
+			results[read.idx].Err = err
+			continue
+		}
+		results[read.idx].Value = logRecord.Value
+		db.cacheValue(read.pos, logRecord.Value)
+	}
+}
@@ -0,0 +1,93 @@
+package bitcask_kv_go
+
+import (
+	"bitcask-kv-go/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_MultiGet(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+
+	assert.Empty(t, db.MultiGet(nil))
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(50)))
+
+	// 乱序、重复、不存在以及空的 key
+	keys := [][]byte{
+		utils.GetTestKey(99), utils.GetTestKey(3), utils.GetTestKey(3),
+		utils.GetTestKey(50), []byte("unknown"), nil, utils.GetTestKey(42),
+	}
+	results := db.MultiGet(keys)
+	assert.Equal(t, len(keys), len(results))
+	for i, key := range keys {
+		val, err := db.Get(key)
+		assert.Equal(t, err, results[i].Err)
+		assert.Equal(t, val, results[i].Value)
+	}
+	assert.Equal(t, ErrKeyNotFound, results[3].Err)
+	assert.Equal(t, ErrKeyNotFound, results[4].Err)
+	assert.Equal(t, ErrKeyIsEmpty, results[5].Err)
+
+	// 重复的 key 返回的 value 互不影响
+	results[1].Value[0] ^= 0xff
+	assert.NotEqual(t, results[1].Value, results[2].Value)
+}
+
+func TestDB_MultiGetAcrossFiles(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.DataFileSize = 16 * 1024
+	opts.Compression = FlateCompression
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	keys := make([][]byte, 0, 500)
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+		keys = append(keys, utils.GetTestKey(499-i))
+	}
+	assert.True(t, len(db.olderFiles) > 1)
+
+	check := func() {
+		results := db.MultiGet(keys)
+		for i, key := range keys {
+			val, err := db.Get(key)
+			assert.Nil(t, err)
+			assert.Nil(t, results[i].Err)
+			assert.Equal(t, val, results[i].Value)
+		}
+	}
+	check()
+
+	// 重启之后从数据文件中恢复记录长度
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer func() { _ = db.Close() }()
+	check()
+}
+
+func TestDB_MultiGetCorrupted(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+
+	assert.Nil(t, db.Put([]byte("a"), []byte("va")))
+	assert.Nil(t, db.Put([]byte("b"), []byte("vb")))
+	assert.Nil(t, db.Put([]byte("c"), []byte("vc")))
+
+	// 篡改 b 的记录长度，解码失败只影响 b
+	pos := *db.index.Get([]byte("b"))
+	pos.Size--
+	db.index.Put([]byte("b"), &pos)
+
+	results := db.MultiGet([][]byte{[]byte("a"), []byte("b"), []byte("c")})
+	assert.Equal(t, []byte("va"), results[0].Value)
+	assert.NotNil(t, results[1].Err)
+	assert.Equal(t, []byte("vc"), results[2].Value)
+}
@@ -1,6 +1,11 @@
 package bitcask_kv_go
 
-import "os"
+import (
+	"bitcask-kv-go/data"
+	"compress/flate"
+	"os"
+	"time"
+)
 
 type IndexerType = int8
 
@@ -10,6 +15,28 @@ const (
 
 	// ART Adaptive Radix Tree 自适应基数树索引
 	ART
+
+	// BPTree 持久化在磁盘上的 B+ 树索引，打开数据库时不需要重建索引
+	BPTree
+
+	// SkipList 并发跳表索引，读操作不加锁，适合读多写多的并发场景
+	SkipList
+
+	// Hash 分片哈希索引，只适合点查的场景，迭代时需要先对所有的 key 排序
+	Hash
+)
+
+type CompressionType = data.CompressionType
+
+const (
+	// 不压缩
+	NoCompression CompressionType = data.CompressionNone
+
+	// 使用 flate 压缩
+	FlateCompression CompressionType = data.CompressionFlate
+
+	// 使用 gzip 压缩
+	GzipCompression CompressionType = data.CompressionGzip
 )
 
 type Options struct {
@@ -24,13 +51,88 @@ type Options struct {
 
 	// 索引类型
 	IndexType IndexerType
+
+	// 是否保留 key 的历史版本，开启后每条记录都会指向同一个 key 的上一个版本
+	KeepHistory bool
+
+	// merge 时保留多长时间之内写入的历史版本，为 0 则只保留最新版本
+	HistoryRetention time.Duration
+
+	// value 的压缩算法
+	Compression CompressionType
+
+	// value 长度大于等于该值时才进行压缩
+	CompressionThreshold int
+
+	// 压缩级别，取值和标准库 compress/flate 一致
+	CompressionLevel int
+
+	// 数据加密配置
+	Encryption EncryptionOptions
+
+	// value 长度大于等于该值时写入单独的 blob 文件，数据文件中只保存 blob 的位置，为 0 表示不分离
+	BlobThreshold int
+
+	// blob 文件的大小
+	BlobFileSize int64
+
+	// 是否开启 value 去重，开启后相同的 value 只存储一份，记录中只保存 value 的哈希
+	Dedup bool
+
+	// value 长度大于等于该值时才进行去重
+	DedupThreshold int
+
+	// 是否开启低内存模式，开启后数据文件封存时会生成有序的 key 文件，内存中只保留活跃文件中的 key 和 key 文件的稀疏索引
+	// 低内存模式不支持历史版本和 value 去重
+	LowMemory bool
+
+	// 低内存模式下 key 文件中每个块包含的 key 数量，内存中为每个块保留一个 key
+	KeyFileBlockSize int
+
+	// PutReader 流式写入时每个分块的大小，读写流式 value 时内存中最多只保留一个分块
+	StreamChunkSize int
+
+	// value 缓存的大小（字节），按照记录的位置缓存读取到的 value，为 0 表示不开启缓存
+	// 使用分段 LRU 淘汰策略，Fold 和迭代器的大范围遍历不会把经常访问的 value 挤出缓存
+	ValueCacheSize int64
+
+	// 最多同时打开的旧数据文件数量，为 0 表示不限制，所有数据文件一直保持打开
+	// 设置之后旧的数据文件在读取时才打开，超出限制时关闭最久没有读取的文件，活跃文件、blob 文件和 key 文件不计算在内
+	MaxOpenFiles int
+}
+
+// 数据加密配置项
+type EncryptionOptions struct {
+	// 密钥提供者，为 nil 时不加密，开启后数据文件和 hint 文件中的记录都会使用 AES-GCM 加密
+	KeyProvider KeyProvider
 }
 
 var DefaultOptions = Options{
-	DirPath:      os.TempDir(),
-	DataFileSize: 256 * 1024 * 1024, // 256MB
-	SyncWrites:   false,
-	IndexType:    BTree,
+	DirPath:          os.TempDir(),
+	DataFileSize:     256 * 1024 * 1024, // 256MB
+	SyncWrites:       false,
+	IndexType:        BTree,
+	KeepHistory:      false,
+	HistoryRetention: 0,
+
+	Compression:          NoCompression,
+	CompressionThreshold: 256,
+	CompressionLevel:     flate.DefaultCompression,
+
+	BlobThreshold: 0,
+	BlobFileSize:  256 * 1024 * 1024, // 256MB
+
+	Dedup:          false,
+	DedupThreshold: 1024,
+
+	LowMemory:        false,
+	KeyFileBlockSize: 128,
+
+	StreamChunkSize: 1024 * 1024, // 1MB
+
+	ValueCacheSize: 0,
+
+	MaxOpenFiles: 0,
 }
 
 // 索引迭代器配置项
@@ -39,6 +141,22 @@ type IteratorOptions struct {
 	Prefix []byte
 	// 是否反向遍历，默认 false 是正向
 	Reverse bool
+
+	// 遍历范围的下界，默认为空，表示没有下界
+	LowerBound []byte
+	// 下界是否不包含 LowerBound 本身，默认 false 表示包含
+	LowerExclusive bool
+
+	// 遍历范围的上界，默认为空，表示没有上界
+	UpperBound []byte
+	// 上界是否包含 UpperBound 本身，默认 false 表示不包含
+	UpperInclusive bool
+
+	// 最多遍历的 key 数量，默认 0 表示不限制
+	Limit int
+
+	// 是否只遍历 key，为 true 时不会读取数据文件，Value 返回 ErrKeyOnlyIterator
+	KeyOnly bool
 }
 
 var DefaultIteratorOptions = IteratorOptions{
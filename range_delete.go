@@ -0,0 +1,128 @@
+package bitcask_kv_go
+
+import (
+	"bitcask-kv-go/data"
+	"bytes"
+)
+
+// 范围删除时每一批从索引中删除的 key 数量
+const rangeDeleteBatchSize = 1024
+
+// DeletePrefix 删除前缀为 prefix 的所有 key，只写入一条范围删除记录
+func (db *DB) DeletePrefix(prefix []byte) error {
+	if len(prefix) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return db.DeleteRange(prefix, prefixSuccessor(prefix))
+}
+
+// DeleteRange 删除 [start, end) 范围内的所有 key，start 为 nil 时从第一个 key 开始，end 为 nil 时表示没有终点
+// 只写入一条范围删除记录，重启和 merge 之后在这条记录之前写入的 key 仍然保持删除
+// 写入记录之后分批从索引中删除范围内的 key，每批之间释放锁，不会一次性加载范围内所有的 key，也不会长时间阻塞其他的读写；
+// 删除完成之前并发的读取可能看到部分 key 已经删除，并发写入范围内的 key 不会被删除
+// 开启历史版本时，范围删除记录作为范围内每个 key 的删除版本，版本链在这里结束，更早的版本不再可以通过 History、GetAt 读取
+func (db *DB) DeleteRange(start, end []byte) error {
+	if end != nil && bytes.Compare(start, end) >= 0 {
+		return nil
+	}
+
+	db.mu.Lock()
+	// 范围内没有 key，不需要写入删除记录
+	if !db.rangeHasKeys(start, end) {
+		db.mu.Unlock()
+		return nil
+	}
+	logRecord := &data.LogRecord{
+		Key:     logRecordKeyWithSeq(start, nonTransactionSeqNo),
+		Value:   end,
+		Type:    data.LogRecordRangeDeleted,
+		Version: db.nextVersionLocked(),
+	}
+	pos, err := db.appendLogRecordLocked(logRecord)
+	if err == nil {
+		err = db.finishWriteLocked()
+	}
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	db.rangeDeleters++
+	db.mu.Unlock()
+	defer func() {
+		db.mu.Lock()
+		db.rangeDeleters--
+		db.mu.Unlock()
+	}()
+
+	// start 为 nil 时表示从第一个 key 开始，返回的下一批的起点为 nil 时才表示结束
+	for next := start; ; {
+		db.mu.Lock()
+		next, err = db.deleteRangeBatchLocked(next, end, pos)
+		db.mu.Unlock()
+		if err != nil || next == nil {
+			return err
+		}
+	}
+}
+
+// 从内存索引中删除范围内的所有 key，用于重启时重放范围删除记录
+// 在访问此方法前必须持有互斥锁
+func (db *DB) deleteRangeFromIndexLocked(start, end []byte, pos *data.LogRecordPos) {
+	for next := start; ; {
+		var err error
+		if next, err = db.deleteRangeBatchLocked(next, end, pos); err != nil {
+			panic("failed to update index at startup")
+		}
+		if next == nil {
+			return
+		}
+	}
+}
+
+// 范围内是否有 key
+func (db *DB) rangeHasKeys(start, end []byte) bool {
+	it := db.NewIterator(IteratorOptions{LowerBound: start, UpperBound: end, KeyOnly: true})
+	defer it.Close()
+	return it.Valid()
+}
+
+// 检查索引中 [start, end) 范围内最多 rangeDeleteBatchSize 个 key 并删除，返回下一批的起点，全部检查之后返回 nil
+// 只删除在范围删除记录之前写入的 key，删除过程中写入的 key 版本号更大，不受影响
+// 按照单个 key 的删除处理每个 key，同时维护共享 value 的引用计数和历史版本链的起点
+// 在访问此方法前必须持有互斥锁
+func (db *DB) deleteRangeBatchLocked(start, end []byte, pos *data.LogRecordPos) ([]byte, error) {
+	it := db.NewIterator(IteratorOptions{LowerBound: start, UpperBound: end, KeyOnly: true})
+	var keys [][]byte
+	var next []byte
+	for scanned := 0; it.Valid(); it.Next() {
+		if scanned == rangeDeleteBatchSize {
+			next = it.Key()
+			break
+		}
+		scanned++
+		if it.indexIter.Value().Version < pos.Version {
+			keys = append(keys, it.Key())
+		}
+	}
+	err := it.Err()
+	it.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	tombstone := &data.LogRecord{Type: data.LogRecordDeleted}
+	for _, key := range keys {
+		if !db.updateIndexLocked(key, tombstone, pos) {
+			return nil, ErrIndexUpdateFailed
+		}
+	}
+	return next, nil
+}
+
+// 获取范围删除记录的终点，没有终点时返回 nil
+func rangeDeleteEnd(logRecord *data.LogRecord) []byte {
+	if len(logRecord.Value) == 0 {
+		return nil
+	}
+	return logRecord.Value
+}
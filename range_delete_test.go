@@ -0,0 +1,210 @@
+package bitcask_kv_go
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_DeletePrefix(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.DataFileSize = 8 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	assert.Equal(t, ErrKeyIsEmpty, db.DeletePrefix(nil))
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("tenant-a:%03d", i)), []byte("value")))
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("tenant-b:%03d", i)), []byte("value")))
+	}
+
+	// 只写入一条删除记录
+	before, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Nil(t, db.DeletePrefix([]byte("tenant-a:")))
+	assert.Equal(t, 0, db.CountPrefix([]byte("tenant-a:")))
+	assert.Equal(t, 200, db.CountPrefix([]byte("tenant-b:")))
+	after, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Less(t, after.DiskSize-before.DiskSize, int64(100))
+
+	// 删除之后重新写入的 key 不受影响
+	assert.Nil(t, db.Put([]byte("tenant-a:new"), []byte("value")))
+
+	// 重启之后重放范围删除记录
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, db.CountPrefix([]byte("tenant-a:")))
+	_, err = db.Get([]byte("tenant-a:010"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// merge 之后仍然保持删除
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer func() { _ = db.Close() }()
+	assert.Equal(t, 1, db.CountPrefix([]byte("tenant-a:")))
+	assert.Equal(t, 200, db.CountPrefix([]byte("tenant-b:")))
+	val, err := db.Get([]byte("tenant-a:new"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+}
+
+func TestDB_DeleteRange(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		assert.Nil(t, db.Put([]byte(key), []byte(key)))
+	}
+
+	// 空的范围
+	assert.Nil(t, db.DeleteRange([]byte("c"), []byte("c")))
+	assert.Nil(t, db.DeleteRange([]byte("x"), nil))
+	assert.Equal(t, 5, len(db.ListKeys()))
+
+	assert.Nil(t, db.DeleteRange([]byte("b"), []byte("d")))
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("d"), []byte("e")}, db.ListKeys())
+
+	assert.Nil(t, db.DeleteRange(nil, []byte("e")))
+	assert.Equal(t, [][]byte{[]byte("e")}, db.ListKeys())
+
+	assert.Nil(t, db.DeleteRange([]byte("e"), nil))
+	assert.Empty(t, db.ListKeys())
+}
+
+func TestDB_DeleteRangeIndexes(t *testing.T) {
+	cases := map[string]func(opts *Options){
+		"low memory": func(opts *Options) { opts.LowMemory = true },
+		"bptree":     func(opts *Options) { opts.IndexType = BPTree },
+		"dedup":      func(opts *Options) { opts.Dedup = true; opts.DedupThreshold = 1 },
+	}
+	for name, setup := range cases {
+		t.Run(name, func(t *testing.T) {
+			opts := DefaultOptions
+			opts.DirPath = t.TempDir()
+			opts.DataFileSize = 4 * 1024
+			setup(&opts)
+			db, err := Open(opts)
+			assert.Nil(t, err)
+
+			for i := 0; i < 100; i++ {
+				assert.Nil(t, db.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte("shared-value")))
+			}
+			assert.Nil(t, db.DeleteRange([]byte("key-020"), []byte("key-080")))
+			assert.Nil(t, db.Put([]byte("key-050"), []byte("shared-value")))
+			assert.Equal(t, 41, db.CountRange(nil, nil))
+			assert.Nil(t, db.Close())
+
+			db, err = Open(opts)
+			assert.Nil(t, err)
+			defer func() { _ = db.Close() }()
+			assert.Equal(t, 41, db.CountRange(nil, nil))
+			val, err := db.Get([]byte("key-050"))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("shared-value"), val)
+			_, err = db.Get([]byte("key-051"))
+			assert.Equal(t, ErrKeyNotFound, err)
+		})
+	}
+}
+
+func TestDB_DeleteRangeWithHistory(t *testing.T) {
+	db, opts := initHistoryDB(t, time.Hour)
+
+	assert.Nil(t, db.Put([]byte("a"), []byte("a-v1")))
+	assert.Nil(t, db.Put([]byte("b"), []byte("b-v1")))
+	assert.Nil(t, db.Put([]byte("c"), []byte("c-v1")))
+	beforeDelete := db.LastSeq()
+	assert.Nil(t, db.DeleteRange([]byte("a"), []byte("c")))
+	afterDelete := db.LastSeq()
+	assert.Nil(t, db.Put([]byte("a"), []byte("a-v2")))
+
+	check := func(db *DB) {
+		// 范围删除记录作为删除版本，版本链在这里结束
+		versions, err := db.History([]byte("a"), 0)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(versions))
+		assert.Equal(t, []byte("a-v2"), versions[0].Value)
+		assert.True(t, versions[1].Deleted)
+		assert.Equal(t, afterDelete, versions[1].Seq)
+
+		versions, err = db.History([]byte("b"), 0)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(versions))
+		assert.True(t, versions[0].Deleted)
+		_, err = db.GetAtSeq([]byte("b"), afterDelete)
+		assert.Equal(t, ErrKeyNotFound, err)
+		_, err = db.GetAtSeq([]byte("b"), beforeDelete)
+		assert.Equal(t, ErrKeyNotFound, err)
+
+		// 范围之外的 key 不受影响
+		val, err := db.GetAtSeq([]byte("c"), afterDelete)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("c-v1"), val)
+	}
+	check(db)
+
+	// 重启和 merge 之后保持同样的版本链
+	assert.Nil(t, db.Close())
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	check(db)
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer func() { _ = db.Close() }()
+	check(db)
+	_, err = db.Get([]byte("b"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_DeleteRangeInBatches(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	keyCount := rangeDeleteBatchSize*2 + 100
+	for i := 0; i < keyCount; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key-%05d", i)), []byte("value")))
+	}
+	assert.Nil(t, db.Put([]byte("other"), []byte("value")))
+	assert.Nil(t, db.DeletePrefix([]byte("key-")))
+	assert.Equal(t, 0, db.CountPrefix([]byte("key-")))
+	assert.Equal(t, 1, db.CountRange(nil, nil))
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer func() { _ = db.Close() }()
+	assert.Equal(t, 1, db.CountRange(nil, nil))
+
+	// 只删除在范围删除记录之前写入的 key
+	assert.Nil(t, db.Put([]byte("key-a"), []byte("value")))
+	assert.Nil(t, db.Put([]byte("key-b"), []byte("value")))
+	pos := db.index.Get([]byte("key-b"))
+	db.mu.Lock()
+	next, err := db.deleteRangeBatchLocked([]byte("key-"), nil, pos)
+	db.mu.Unlock()
+	assert.Nil(t, err)
+	assert.Nil(t, next)
+	_, err = db.Get([]byte("key-a"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get([]byte("key-b"))
+	assert.Nil(t, err)
+
+	// 从索引中删除 key 的过程中不能 merge
+	db.mu.Lock()
+	db.rangeDeleters++
+	db.mu.Unlock()
+	assert.Equal(t, ErrRangeDeleteInProgress, db.Merge())
+	db.mu.Lock()
+	db.rangeDeleters--
+	db.mu.Unlock()
+}
@@ -0,0 +1,92 @@
+package bitcask_kv_go
+
+import (
+	"bytes"
+	"encoding/base64"
+)
+
+// 游标格式的版本号
+const scanCursorVersion byte = 1
+
+// KeyValue 一条 key/value 数据
+type KeyValue struct {
+	Key   []byte
+	Value []byte
+}
+
+// Scan 分页遍历数据，每次最多返回 limit 条，同时返回用于获取下一页的游标
+// cursor 为空时从 opts 指定范围的起点开始，返回的游标为空表示已经遍历完毕
+// 游标中只记录了上一页的最后一个 key，因此在数据库重启和 merge 之后仍然有效
+// opts 中的 Limit 会被忽略，同一个游标需要配合相同的 opts 使用；opts 中设置 KeyOnly 时返回的 Value 为 nil
+func (db *DB) Scan(opts IteratorOptions, cursor string, limit int) ([]KeyValue, string, error) {
+	if limit <= 0 {
+		return nil, "", ErrScanLimitInvalid
+	}
+	opts.Limit = 0
+	if cursor != "" {
+		lastKey, err := decodeScanCursor(cursor, opts.Reverse)
+		if err != nil {
+			return nil, "", err
+		}
+		resumeAfter(&opts, lastKey)
+	}
+
+	it := db.NewIterator(opts)
+	defer it.Close()
+
+	page := make([]KeyValue, 0, limit)
+	for ; it.Valid() && len(page) < limit; it.Next() {
+		if opts.KeyOnly {
+			page = append(page, KeyValue{Key: it.Key()})
+			continue
+		}
+		value, err := it.Value()
+		if err != nil {
+			return nil, "", err
+		}
+		page = append(page, KeyValue{Key: it.Key(), Value: value})
+	}
+	if err := it.Err(); err != nil {
+		return nil, "", err
+	}
+	if !it.Valid() {
+		return page, "", nil
+	}
+	return page, encodeScanCursor(page[len(page)-1].Key, opts.Reverse), nil
+}
+
+// 将遍历范围的起点收缩到上一页最后一个 key 之后（不包含该 key）
+func resumeAfter(opts *IteratorOptions, lastKey []byte) {
+	if opts.Reverse {
+		if opts.UpperBound == nil || bytes.Compare(lastKey, opts.UpperBound) <= 0 {
+			opts.UpperBound, opts.UpperInclusive = lastKey, false
+		}
+		return
+	}
+	if opts.LowerBound == nil || bytes.Compare(lastKey, opts.LowerBound) >= 0 {
+		opts.LowerBound, opts.LowerExclusive = lastKey, true
+	}
+}
+
+// 游标格式：version | reverse | key，使用 URL 安全的 base64 编码
+func encodeScanCursor(lastKey []byte, reverse bool) string {
+	buf := make([]byte, 2+len(lastKey))
+	buf[0] = scanCursorVersion
+	if reverse {
+		buf[1] = 1
+	}
+	copy(buf[2:], lastKey)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+func decodeScanCursor(cursor string, reverse bool) ([]byte, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(buf) <= 2 || buf[0] != scanCursorVersion || buf[1] > 1 {
+		return nil, ErrScanCursorInvalid
+	}
+	// 游标的遍历方向需要和本次遍历一致
+	if (buf[1] == 1) != reverse {
+		return nil, ErrScanCursorInvalid
+	}
+	return buf[2:], nil
+}
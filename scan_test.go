@@ -0,0 +1,87 @@
+package bitcask_kv_go
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Scan(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.DataFileSize = 4 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key-%03d", i)), []byte(fmt.Sprintf("value-%d", i))))
+	}
+
+	_, _, err = db.Scan(DefaultIteratorOptions, "", 0)
+	assert.Equal(t, ErrScanLimitInvalid, err)
+	_, _, err = db.Scan(DefaultIteratorOptions, "not a cursor", 10)
+	assert.Equal(t, ErrScanCursorInvalid, err)
+
+	// 第一页
+	page, cursor, err := db.Scan(DefaultIteratorOptions, "", 30)
+	assert.Nil(t, err)
+	assert.Equal(t, 30, len(page))
+	assert.Equal(t, []byte("key-000"), page[0].Key)
+	assert.Equal(t, []byte("value-29"), page[29].Value)
+	assert.NotEmpty(t, cursor)
+
+	// 反向遍历不能使用正向的游标
+	_, _, err = db.Scan(IteratorOptions{Reverse: true}, cursor, 10)
+	assert.Equal(t, ErrScanCursorInvalid, err)
+
+	// 重启并 merge 之后游标仍然有效
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer func() { _ = db.Close() }()
+	assert.Nil(t, db.Merge())
+
+	var keys []string
+	for _, kv := range page {
+		keys = append(keys, string(kv.Key))
+	}
+	for cursor != "" {
+		page, cursor, err = db.Scan(DefaultIteratorOptions, cursor, 30)
+		assert.Nil(t, err)
+		for _, kv := range page {
+			keys = append(keys, string(kv.Key))
+		}
+	}
+	assert.Equal(t, 100, len(keys))
+	for i, key := range keys {
+		assert.Equal(t, fmt.Sprintf("key-%03d", i), key)
+	}
+}
+
+func TestDB_ScanReverseWithBounds(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("key-%02d", i)), []byte("v")))
+	}
+
+	scanOpts := IteratorOptions{Reverse: true, LowerBound: []byte("key-05"), UpperBound: []byte("key-15")}
+	var keys []string
+	cursor := ""
+	for {
+		page, next, err := db.Scan(scanOpts, cursor, 3)
+		assert.Nil(t, err)
+		for _, kv := range page {
+			keys = append(keys, string(kv.Key))
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	assert.Equal(t, 10, len(keys))
+	assert.Equal(t, "key-14", keys[0])
+	assert.Equal(t, "key-05", keys[9])
+}
@@ -0,0 +1,70 @@
+package bitcask_kv_go
+
+import "iter"
+
+// All 按 key 的顺序遍历所有的数据，返回可以直接用于 for range 的迭代函数
+// 读取出错时遍历停止，错误写入调用方传入的 errp，每次遍历开始时 errp 会被重置为 nil，提前退出遍历时同样为 nil
+// errp 为 nil 时忽略错误；同一个迭代函数可以多次遍历，每次都重新从头开始
+//
+//	var err error
+//	for k, v := range db.Prefix(prefix, &err) {
+//		...
+//	}
+//	if err != nil {
+//		...
+//	}
+func (db *DB) All(errp *error) iter.Seq2[[]byte, []byte] {
+	return db.seq(DefaultIteratorOptions, errp)
+}
+
+// Keys 按顺序遍历所有的 key，只访问索引，不会读取 value，错误的处理同 All
+func (db *DB) Keys(errp *error) iter.Seq[[]byte] {
+	opts := IteratorOptions{KeyOnly: true}
+	return func(yield func([]byte) bool) {
+		setSeqErr(errp, nil)
+		it := db.NewIterator(opts)
+		defer it.Close()
+		for ; it.Valid(); it.Next() {
+			if !yield(it.Key()) {
+				return
+			}
+		}
+		setSeqErr(errp, it.Err())
+	}
+}
+
+// Prefix 遍历前缀为 prefix 的所有数据，错误的处理同 All
+func (db *DB) Prefix(prefix []byte, errp *error) iter.Seq2[[]byte, []byte] {
+	return db.seq(IteratorOptions{Prefix: prefix}, errp)
+}
+
+// Range 遍历 [start, end) 范围内的所有数据，start 或 end 为 nil 时表示不限制，错误的处理同 All
+func (db *DB) Range(start, end []byte, errp *error) iter.Seq2[[]byte, []byte] {
+	return db.seq(IteratorOptions{LowerBound: start, UpperBound: end}, errp)
+}
+
+// 返回遍历 key/value 的迭代函数，遍历的状态都在每次调用中，不同的遍历之间不共享
+func (db *DB) seq(opts IteratorOptions, errp *error) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		setSeqErr(errp, nil)
+		it := db.NewIterator(opts)
+		defer it.Close()
+		for ; it.Valid(); it.Next() {
+			value, err := it.Value()
+			if err != nil {
+				setSeqErr(errp, err)
+				return
+			}
+			if !yield(it.Key(), value) {
+				return
+			}
+		}
+		setSeqErr(errp, it.Err())
+	}
+}
+
+func setSeqErr(errp *error, err error) {
+	if errp != nil {
+		*errp = err
+	}
+}
@@ -0,0 +1,139 @@
+package bitcask_kv_go
+
+import (
+	"bitcask-kv-go/data"
+	"bitcask-kv-go/utils"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Seq(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+
+	for _, key := range []string{"b", "a", "ab", "c"} {
+		assert.Nil(t, db.Put([]byte(key), []byte("value-"+key)))
+	}
+
+	var keys []string
+	var err error
+	for k, v := range db.All(&err) {
+		assert.Equal(t, "value-"+string(k), string(v))
+		keys = append(keys, string(k))
+	}
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "ab", "b", "c"}, keys)
+
+	keys = nil
+	for k := range db.Keys(&err) {
+		keys = append(keys, string(k))
+	}
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "ab", "b", "c"}, keys)
+
+	keys = nil
+	for k, v := range db.Prefix([]byte("a"), &err) {
+		assert.Equal(t, "value-"+string(k), string(v))
+		keys = append(keys, string(k))
+	}
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "ab"}, keys)
+
+	// 不关心错误时可以传入 nil
+	keys = nil
+	for k := range db.Range([]byte("ab"), []byte("c"), nil) {
+		keys = append(keys, string(k))
+	}
+	assert.Equal(t, []string{"ab", "b"}, keys)
+
+	// 提前退出遍历
+	keys = nil
+	err = errors.New("stale")
+	for k := range db.Range([]byte("ab"), nil, &err) {
+		keys = append(keys, string(k))
+		break
+	}
+	assert.Equal(t, []string{"ab"}, keys)
+	assert.Nil(t, err)
+}
+
+func TestDB_SeqError(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer db.Close()
+
+	assert.Nil(t, db.Put([]byte("a"), []byte("value-a")))
+	assert.Nil(t, db.Put([]byte("b"), []byte("value-b")))
+	assert.Nil(t, db.Sync())
+
+	// 损坏最后一条记录的 value
+	fileName := data.GetDataFileName(opts.DirPath, 0)
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	content[len(content)-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(fileName, content, 0644))
+
+	var keys []string
+	var seqErr error
+	for k := range db.All(&seqErr) {
+		keys = append(keys, string(k))
+	}
+	assert.Equal(t, []string{"a"}, keys)
+	assert.Equal(t, data.ErrInvalidCRC, seqErr)
+
+	// 只遍历 key 时不会读取 value
+	keys = nil
+	var keysErr error
+	for k := range db.Keys(&keysErr) {
+		keys = append(keys, string(k))
+	}
+	assert.Equal(t, []string{"a", "b"}, keys)
+	assert.Nil(t, keysErr)
+
+	// 每次遍历的错误只写入各自的 errp，互不影响
+	var failed, succeeded error
+	all := db.All(&failed)
+	prefix := db.Prefix([]byte("a"), &succeeded)
+	for range all {
+		for range prefix {
+		}
+	}
+	assert.Equal(t, data.ErrInvalidCRC, failed)
+	assert.Nil(t, succeeded)
+}
+
+func TestDB_SeqIndexError(t *testing.T) {
+	db, opts := initLowMemoryDB(t)
+	defer db.Close()
+
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(32)))
+	}
+
+	// 损坏第一个 key 文件，遍历索引时出错
+	fileName := data.GetKeyFileName(opts.DirPath, 0)
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	for i := len(content) / 2; i < len(content)/2+16; i++ {
+		content[i] ^= 0xff
+	}
+	assert.Nil(t, os.WriteFile(fileName, content, 0644))
+
+	var keysErr error
+	var count int
+	for range db.Keys(&keysErr) {
+		count++
+	}
+	assert.Less(t, count, 500)
+	assert.NotNil(t, keysErr)
+
+	var allErr error
+	for range db.All(&allErr) {
+	}
+	assert.NotNil(t, allErr)
+}
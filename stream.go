@@ -0,0 +1,269 @@
+package bitcask_kv_go
+
+import (
+	"bitcask-kv-go/data"
+	"bytes"
+	"io"
+)
+
+// PutReader 从 r 中流式读取 size 字节的数据作为 key 的 value 写入，value 的长度可以超过 4GB
+// value 按照 StreamChunkSize 切分为多个分块写入数据文件，最后写入一条记录所有分块位置的清单记录，清单写入之后 value 才可见
+// 内存中最多只保留一个分块，写入分块时不会一直持有锁；r 中的数据不足 size 时返回 io.ErrUnexpectedEOF，已经写入的分块会在 merge 时清理
+func (db *DB) PutReader(key []byte, r io.Reader, size int64) error {
+	if err := db.checkPutKey(key); err != nil {
+		return err
+	}
+	if size < 0 {
+		return ErrStreamSizeInvalid
+	}
+
+	// 流式写入期间不能 merge，否则 merge 之后清单中的分块位置会失效
+	db.mu.Lock()
+	if db.isMerging {
+		db.mu.Unlock()
+		return ErrMergeIsProgress
+	}
+	db.streamWriters++
+	db.mu.Unlock()
+	defer func() {
+		db.mu.Lock()
+		db.streamWriters--
+		db.mu.Unlock()
+	}()
+
+	manifest := &data.StreamManifest{Size: uint64(size)}
+	buf := make([]byte, min(int64(db.options.StreamChunkSize), size))
+	for remaining := size; remaining > 0; {
+		n := min(int64(len(buf)), remaining)
+		if _, err := io.ReadFull(r, buf[:n]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		pos, err := db.appendStreamChunk(key, buf[:n])
+		if err != nil {
+			return err
+		}
+		manifest.Chunks = append(manifest.Chunks, pos)
+		remaining -= n
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.putStreamManifestLocked(key, manifest)
+}
+
+// GetReader 获取 key 对应的 value 的流式读取器，使用完之后需要调用 Close
+// 通过 PutReader 写入的 value 在读取时逐个加载分块，内存中最多只保留一个分块；其他的 value 直接从内存中读取
+// 读取器不持有数据库的锁，读取期间 key 被覆盖或删除不影响已经打开的读取器
+func (db *DB) GetReader(key []byte) (io.ReadCloser, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	// 只在查找索引时持有读锁，读取文件时不持有锁
+	db.mu.RLock()
+	pos, err := db.getIndexPos(key)
+	blobPins := db.pinBlobsLocked()
+	db.mu.RUnlock()
+	defer blobPins.Done()
+	if err != nil {
+		return nil, err
+	}
+	if pos == nil {
+		return nil, ErrKeyNotFound
+	}
+	logRecord, err := db.readLogRecordUnlocked(pos)
+	if err != nil {
+		return nil, err
+	}
+	if logRecord.Type == data.LogRecordDeleted {
+		return nil, ErrKeyNotFound
+	}
+	if logRecord.Flags&data.FlagStreamRef == 0 {
+		if err := db.resolveValueUnlocked(logRecord); err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(logRecord.Value)), nil
+	}
+
+	manifest, err := data.DecodeStreamManifest(logRecord.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &streamReader{db: db, chunks: manifest.Chunks, remaining: manifest.Size}, nil
+}
+
+// 流式 value 的读取器，按顺序逐个读取分块
+type streamReader struct {
+	db        *DB
+	chunks    []*data.LogRecordPos // 尚未读取的分块
+	buf       []byte               // 当前分块中尚未读取的数据
+	remaining uint64               // 尚未读取的数据长度
+	closed    bool
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, ErrStreamReaderClosed
+	}
+	for len(r.buf) == 0 {
+		if len(r.chunks) == 0 {
+			// 分块的总长度和清单中记录的长度不一致
+			if r.remaining != 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, io.EOF
+		}
+		chunk, err := r.db.readStreamChunk(r.chunks[0])
+		if err != nil {
+			return 0, err
+		}
+		if uint64(len(chunk)) > r.remaining {
+			return 0, data.ErrInvalidStreamManifest
+		}
+		r.chunks = r.chunks[1:]
+		r.buf = chunk
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.remaining -= uint64(n)
+	return n, nil
+}
+
+func (r *streamReader) Close() error {
+	r.closed = true
+	r.chunks = nil
+	r.buf = nil
+	return nil
+}
+
+// 将一个分块追加写入到活跃文件中
+func (db *DB) appendStreamChunk(key, chunk []byte) (*data.LogRecordPos, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	pos, err := db.appendLogRecordLocked(&data.LogRecord{
+		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value: chunk,
+		Type:  data.LogRecordValueChunk,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.finishWriteLocked(); err != nil {
+		return nil, err
+	}
+	return pos, nil
+}
+
+// 写入清单记录并更新索引
+// 在访问此方法前必须持有互斥锁
+func (db *DB) putStreamManifestLocked(key []byte, manifest *data.StreamManifest) error {
+	logRecord := &data.LogRecord{
+		Key:     logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:   data.EncodeStreamManifest(manifest),
+		Type:    data.LogRecordNormal,
+		Flags:   data.FlagStreamRef,
+		Version: db.nextVersionLocked(),
+	}
+	if db.options.KeepHistory {
+		logRecord.Prev = db.historyHeadLocked(key)
+	}
+
+	pos, err := db.appendLogRecordLocked(logRecord)
+	if err != nil {
+		return err
+	}
+	if ok := db.updateIndexLocked(key, logRecord, pos); !ok {
+		return ErrIndexUpdateFailed
+	}
+	return db.finishWriteLocked()
+}
+
+// 读取一个分块中的数据，读取文件时不持有锁
+func (db *DB) readStreamChunk(pos *data.LogRecordPos) ([]byte, error) {
+	return streamChunkValue(db.readLogRecordUnlocked(pos))
+}
+
+// 在访问此方法前必须持有锁
+func (db *DB) readStreamChunkLocked(pos *data.LogRecordPos) ([]byte, error) {
+	return streamChunkValue(db.readLogRecord(pos))
+}
+
+// 检查读取到的记录是否为分块，返回分块中的数据
+func streamChunkValue(logRecord *data.LogRecord, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	if logRecord.Type != data.LogRecordValueChunk {
+		return nil, data.ErrInvalidStreamManifest
+	}
+	return logRecord.Value, nil
+}
+
+// 如果 value 是流式写入的，则读取所有的分块拼接成完整的 value
+// 在访问此方法前必须持有锁
+func (db *DB) resolveStreamLocked(logRecord *data.LogRecord) error {
+	return resolveStream(logRecord, db.readStreamChunkLocked)
+}
+
+// 如果 value 是流式写入的，则读取所有的分块拼接成完整的 value，读取文件时不持有锁
+func (db *DB) resolveStreamUnlocked(logRecord *data.LogRecord) error {
+	return resolveStream(logRecord, db.readStreamChunk)
+}
+
+// 通过 readChunk 依次读取清单中的分块，拼接成完整的 value
+func resolveStream(logRecord *data.LogRecord, readChunk func(pos *data.LogRecordPos) ([]byte, error)) error {
+	if logRecord.Flags&data.FlagStreamRef == 0 {
+		return nil
+	}
+	manifest, err := data.DecodeStreamManifest(logRecord.Value)
+	if err != nil {
+		return err
+	}
+	value := make([]byte, 0, manifest.Size)
+	for _, pos := range manifest.Chunks {
+		chunk, err := readChunk(pos)
+		if err != nil {
+			return err
+		}
+		value = append(value, chunk...)
+	}
+	if uint64(len(value)) != manifest.Size {
+		return data.ErrInvalidStreamManifest
+	}
+	logRecord.Value = value
+	logRecord.Flags &^= data.FlagStreamRef
+	return nil
+}
+
+// merge 时将清单引用的分块重写到 merge 之后的数据文件中，返回新的清单
+// files 为参与 merge 的数据文件，清单引用的分块都在清单之前写入，一定位于这些文件中
+func (db *DB) rewriteStreamChunks(mergeDB *DB, files map[uint32]*data.DataFile, realKey, manifestValue []byte) ([]byte, error) {
+	manifest, err := data.DecodeStreamManifest(manifestValue)
+	if err != nil {
+		return nil, err
+	}
+	for i, pos := range manifest.Chunks {
+		dataFile := files[pos.Fid]
+		if dataFile == nil {
+			return nil, ErrDataFileNotFound
+		}
+		chunk, _, err := dataFile.ReadLogRecord(pos.Offset)
+		if err != nil {
+			return nil, err
+		}
+		if chunk.Type != data.LogRecordValueChunk {
+			return nil, data.ErrInvalidStreamManifest
+		}
+		chunk.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+		newPos, err := mergeDB.appendLogRecordLocked(chunk)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Chunks[i] = newPos
+	}
+	return data.EncodeStreamManifest(manifest), nil
+}
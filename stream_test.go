@@ -0,0 +1,187 @@
+package bitcask_kv_go
+
+import (
+	"bitcask-kv-go/utils"
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readAllFromReader(t *testing.T, db *DB, key []byte) []byte {
+	t.Helper()
+	reader, err := db.GetReader(key)
+	assert.Nil(t, err)
+	value, err := io.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Nil(t, reader.Close())
+	return value
+}
+
+func TestDB_PutReader(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.StreamChunkSize = 1000
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	assert.Equal(t, ErrKeyIsEmpty, db.PutReader(nil, bytes.NewReader(nil), 0))
+	assert.Equal(t, ErrStreamSizeInvalid, db.PutReader([]byte("k"), bytes.NewReader(nil), -1))
+	_, err = db.GetReader([]byte("k"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 分块大小不能整除 value 的长度
+	value := utils.RandomValue(10500)
+	assert.Nil(t, db.PutReader([]byte("big"), bytes.NewReader(value), int64(len(value))))
+	assert.Nil(t, db.PutReader([]byte("empty"), bytes.NewReader(nil), 0))
+	// 只读取 size 字节
+	assert.Nil(t, db.PutReader([]byte("prefix"), bytes.NewReader(value), 10))
+
+	// 数据不足时不写入 key
+	err = db.PutReader([]byte("short"), bytes.NewReader(value), int64(len(value))+1)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	_, err = db.Get([]byte("short"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	check := func() {
+		assert.Equal(t, value, readAllFromReader(t, db, []byte("big")))
+		assert.Empty(t, readAllFromReader(t, db, []byte("empty")))
+		assert.Equal(t, value[:10], readAllFromReader(t, db, []byte("prefix")))
+
+		// Get 和 GetWithMeta 返回完整的 value
+		val, meta, err := db.GetWithMeta([]byte("big"))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+		assert.Equal(t, int64(len(value)), meta.ValueSize)
+		val, err = db.GetInto([]byte("prefix"), nil)
+		assert.Nil(t, err)
+		assert.Equal(t, value[:10], val)
+	}
+	check()
+
+	// 重启之后分块不会进入索引
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer func() { _ = db.Close() }()
+	check()
+	assert.Equal(t, 3, len(db.ListKeys()))
+
+	// 普通写入的 value 同样可以流式读取
+	assert.Nil(t, db.Put([]byte("small"), []byte("value")))
+	assert.Equal(t, []byte("value"), readAllFromReader(t, db, []byte("small")))
+}
+
+func TestDB_GetReader(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.StreamChunkSize = 16
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() { _ = db.Close() }()
+
+	value := utils.RandomValue(100)
+	assert.Nil(t, db.PutReader([]byte("k"), bytes.NewReader(value), int64(len(value))))
+
+	reader, err := db.GetReader([]byte("k"))
+	assert.Nil(t, err)
+	// 读取过程中覆盖 key，已经打开的读取器仍然读取原来的 value
+	buf := make([]byte, 10)
+	_, err = io.ReadFull(reader, buf)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("k"), []byte("new-value")))
+	rest, err := io.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, value, append(buf, rest...))
+
+	assert.Nil(t, reader.Close())
+	_, err = reader.Read(buf)
+	assert.Equal(t, ErrStreamReaderClosed, err)
+}
+
+func TestDB_PutReaderMerge(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.DataFileSize = 4 * 1024
+	opts.StreamChunkSize = 512
+	opts.Compression = FlateCompression
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	values := make(map[string][]byte)
+	for i := 0; i < 10; i++ {
+		value := utils.RandomValue(3000 + i)
+		key := utils.GetTestKey(i)
+		assert.Nil(t, db.PutReader(key, bytes.NewReader(value), int64(len(value))))
+		values[string(key)] = value
+	}
+	// 覆盖和删除一部分流式写入的 value
+	for i := 0; i < 5; i++ {
+		value := utils.RandomValue(2000)
+		key := utils.GetTestKey(i)
+		assert.Nil(t, db.PutReader(key, bytes.NewReader(value), int64(len(value))))
+		values[string(key)] = value
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(9)))
+	delete(values, string(utils.GetTestKey(9)))
+
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer func() { _ = db.Close() }()
+
+	assert.Equal(t, len(values), len(db.ListKeys()))
+	for key, value := range values {
+		assert.Equal(t, value, readAllFromReader(t, db, []byte(key)))
+	}
+}
+
+// 阻塞的 reader，用于模拟正在进行的流式写入
+type blockingReader struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	close(r.started)
+	<-r.release
+	return 0, io.EOF
+}
+
+func TestDB_MergeDuringPutReader(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+	assert.Nil(t, db.Put([]byte("k"), []byte("v")))
+
+	reader := &blockingReader{started: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan error)
+	go func() {
+		done <- db.PutReader([]byte("stream"), reader, 10)
+	}()
+	<-reader.started
+	assert.Equal(t, ErrStreamWriteInProgress, db.Merge())
+
+	close(reader.release)
+	assert.Equal(t, io.ErrUnexpectedEOF, <-done)
+	assert.Nil(t, db.Merge())
+}
+
+func TestDB_StreamChunkSizeDefault(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.StreamChunkSize = -1
+	_, err := Open(opts)
+	assert.Equal(t, ErrStreamChunkSizeInvalid, err)
+
+	// 直接构造的配置没有设置分块大小和 blob 文件大小，同样可以打开
+	db, err := Open(Options{DirPath: t.TempDir(), DataFileSize: 1024 * 1024, IndexType: BTree})
+	assert.Nil(t, err)
+	defer func() { _ = db.Close() }()
+	assert.Equal(t, DefaultOptions.StreamChunkSize, db.options.StreamChunkSize)
+
+	value := utils.RandomValue(100)
+	assert.Nil(t, db.PutReader([]byte("k"), bytes.NewReader(value), int64(len(value))))
+	assert.Equal(t, value, readAllFromReader(t, db, []byte("k")))
+}
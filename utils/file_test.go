@@ -0,0 +1,20 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirSize(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "a.data"), make([]byte, 100), 0644))
+	assert.Nil(t, os.MkdirAll(filepath.Join(dir, "sub"), os.ModePerm))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "sub", "b.data"), make([]byte, 20), 0644))
+
+	size, err := DirSize(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(120), size)
+}
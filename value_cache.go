@@ -0,0 +1,172 @@
+package bitcask_kv_go
+
+import (
+	"bitcask-kv-go/data"
+	"bytes"
+	"container/list"
+	"sync"
+)
+
+const (
+	// 试用区占缓存容量的比例，新写入缓存的 value 先进入试用区，再次命中后才进入保护区
+	valueCacheProbationRatio = 0.2
+	// 每个缓存项除了 value 之外额外占用的内存估算
+	valueCacheEntryOverhead = 64
+)
+
+// 缓存的 key，数据文件是追加写入的，同一个位置上的记录不会改变
+// key 被覆盖或删除之后索引指向新的位置，旧的缓存项不会再被访问，最终被淘汰
+type valueCacheKey struct {
+	fid    uint32
+	offset int64
+}
+
+type valueCacheEntry struct {
+	key       valueCacheKey
+	value     []byte
+	protected bool // 是否位于保护区
+}
+
+// value 缓存，使用分段 LRU（SLRU）淘汰策略，缓存的大小按照字节数计算
+// 只访问一次的 value 只会在试用区中流转，Fold 或者迭代器的大范围遍历不会把经常访问的 value 挤出保护区
+type valueCache struct {
+	mu sync.Mutex
+
+	capacity     int64 // 缓存的总容量
+	probationCap int64 // 保护区满了之后试用区的容量，同时也是单个 value 的最大长度，保护区没有满时试用区可以使用空闲的容量
+
+	probation     *list.List // 试用区，队首为最近访问的缓存项
+	protected     *list.List // 保护区，队首为最近访问的缓存项
+	probationSize int64
+	protectedSize int64
+	items         map[valueCacheKey]*list.Element
+
+	hits   uint64 // 命中次数
+	misses uint64 // 未命中次数
+}
+
+// capacity 为 0 时不开启缓存，返回 nil
+func newValueCache(capacity int64) *valueCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &valueCache{
+		capacity:     capacity,
+		probationCap: int64(float64(capacity) * valueCacheProbationRatio),
+		probation:    list.New(),
+		protected:    list.New(),
+		items:        make(map[valueCacheKey]*list.Element),
+	}
+}
+
+func newValueCacheKey(pos *data.LogRecordPos) valueCacheKey {
+	return valueCacheKey{fid: pos.Fid, offset: pos.Offset}
+}
+
+func valueCacheEntrySize(value []byte) int64 {
+	return int64(len(value)) + valueCacheEntryOverhead
+}
+
+// 获取位置对应的 value，返回的 value 不能修改
+func (c *valueCache) get(pos *data.LogRecordPos) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[newValueCacheKey(pos)]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+
+	entry := elem.Value.(*valueCacheEntry)
+	if entry.protected {
+		c.protected.MoveToFront(elem)
+		return entry.value, true
+	}
+
+	// 试用区中的缓存项再次命中，移动到保护区
+	size := valueCacheEntrySize(entry.value)
+	c.probation.Remove(elem)
+	c.probationSize -= size
+	entry.protected = true
+	c.items[entry.key] = c.protected.PushFront(entry)
+	c.protectedSize += size
+
+	// 保护区超出容量时，最久没有访问的缓存项降级到试用区
+	for c.protectedSize > c.capacity-c.probationCap && c.protected.Len() > 0 {
+		back := c.protected.Back()
+		demoted := back.Value.(*valueCacheEntry)
+		demotedSize := valueCacheEntrySize(demoted.value)
+		c.protected.Remove(back)
+		c.protectedSize -= demotedSize
+		demoted.protected = false
+		c.items[demoted.key] = c.probation.PushFront(demoted)
+		c.probationSize += demotedSize
+	}
+	c.evict()
+	return entry.value, true
+}
+
+// 缓存位置对应的 value，缓存之后 value 不能再修改
+func (c *valueCache) put(pos *data.LogRecordPos, value []byte) {
+	size := valueCacheEntrySize(value)
+	// 过大的 value 不缓存，避免一次性清空试用区
+	if size > c.probationCap {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := newValueCacheKey(pos)
+	if _, ok := c.items[key]; ok {
+		return
+	}
+	c.items[key] = c.probation.PushFront(&valueCacheEntry{key: key, value: value})
+	c.probationSize += size
+	c.evict()
+}
+
+// 淘汰缓存项直到不超出容量，优先淘汰试用区中最久没有访问的缓存项
+func (c *valueCache) evict() {
+	for c.probationSize+c.protectedSize > c.capacity {
+		segment := c.probation
+		if segment.Len() == 0 {
+			segment = c.protected
+		}
+		back := segment.Back()
+		entry := back.Value.(*valueCacheEntry)
+		size := valueCacheEntrySize(entry.value)
+		segment.Remove(back)
+		if entry.protected {
+			c.protectedSize -= size
+		} else {
+			c.probationSize -= size
+		}
+		delete(c.items, entry.key)
+	}
+}
+
+// 缓存的统计信息
+func (c *valueCache) stat() (hits, misses uint64, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses, c.probationSize + c.protectedSize
+}
+
+// 从缓存中获取位置对应的 value，没有开启缓存时返回 false，返回的 value 不能修改
+func (db *DB) getCachedValue(pos *data.LogRecordPos) ([]byte, bool) {
+	if db.valueCache == nil {
+		return nil, false
+	}
+	return db.valueCache.get(pos)
+}
+
+// 缓存位置对应的 value，value 会被拷贝，调用方之后可以继续使用和修改
+func (db *DB) cacheValue(pos *data.LogRecordPos, value []byte) {
+	if db.valueCache == nil {
+		return
+	}
+	db.valueCache.put(pos, bytes.Clone(value))
+}
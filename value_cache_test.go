@@ -0,0 +1,154 @@
+package bitcask_kv_go
+
+import (
+	"bitcask-kv-go/data"
+	"bitcask-kv-go/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func cachePos(offset int64) *data.LogRecordPos {
+	return &data.LogRecordPos{Fid: 1, Offset: offset}
+}
+
+func TestValueCache_Eviction(t *testing.T) {
+	assert.Nil(t, newValueCache(0))
+
+	// 每个缓存项占用 100 字节，缓存最多 10 项，试用区可以使用保护区空闲的容量
+	c := newValueCache(1000)
+	value := make([]byte, 100-valueCacheEntryOverhead)
+	for i := int64(0); i < 15; i++ {
+		c.put(cachePos(i), value)
+	}
+	// 只访问过一次的缓存项按照 LRU 淘汰
+	for i := int64(0); i < 15; i++ {
+		_, ok := c.get(cachePos(i))
+		assert.Equal(t, i >= 5, ok)
+	}
+	_, _, size := c.stat()
+	assert.Equal(t, int64(1000), size)
+
+	// 超过试用区容量的 value 不缓存
+	c.put(cachePos(100), make([]byte, 300))
+	_, ok := c.get(cachePos(100))
+	assert.False(t, ok)
+
+	hits, misses, _ := c.stat()
+	assert.Equal(t, uint64(10), hits)
+	assert.Equal(t, uint64(6), misses)
+}
+
+func TestValueCache_ScanResistant(t *testing.T) {
+	c := newValueCache(1000)
+	value := make([]byte, 100-valueCacheEntryOverhead)
+
+	// 经常访问的缓存项进入保护区
+	for i := int64(0); i < 5; i++ {
+		c.put(cachePos(i), value)
+		_, ok := c.get(cachePos(i))
+		assert.True(t, ok)
+	}
+	// 大范围的遍历只会在试用区中流转
+	for i := int64(100); i < 1000; i++ {
+		c.put(cachePos(i), value)
+	}
+	for i := int64(0); i < 5; i++ {
+		_, ok := c.get(cachePos(i))
+		assert.True(t, ok)
+	}
+
+	// 保护区满了之后，最久没有访问的缓存项降级到试用区
+	for i := int64(5); i < 12; i++ {
+		c.put(cachePos(i), value)
+		c.get(cachePos(i))
+	}
+	_, _, size := c.stat()
+	assert.True(t, size <= 1000)
+	for i := int64(4); i < 12; i++ {
+		_, ok := c.get(cachePos(i))
+		assert.True(t, ok)
+	}
+}
+
+func TestDB_ValueCache(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.ValueCacheSize = -1
+	_, err := Open(opts)
+	assert.Equal(t, ErrValueCacheSizeInvalid, err)
+
+	opts.ValueCacheSize = 64 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() { _ = db.Close() }()
+
+	assert.Nil(t, db.Put([]byte("hot"), []byte("v1")))
+	for i := 0; i < 3; i++ {
+		val, err := db.Get([]byte("hot"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("v1"), val)
+		// 修改返回的 value 不影响缓存
+		val[0] = 'x'
+	}
+	stat, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), stat.ValueCacheHits)
+	assert.Equal(t, uint64(1), stat.ValueCacheMisses)
+	assert.True(t, stat.ValueCacheBytes > 0)
+
+	// 覆盖之后索引指向新的位置，不会读取到旧的缓存
+	assert.Nil(t, db.Put([]byte("hot"), []byte("v2")))
+	val, err := db.Get([]byte("hot"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+
+	// GetInto、View 和 MultiGet 同样使用缓存
+	val, err = db.GetInto([]byte("hot"), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	assert.Nil(t, db.View([]byte("hot"), func(value []byte) error {
+		assert.Equal(t, []byte("v2"), value)
+		return nil
+	}))
+	results := db.MultiGet([][]byte{[]byte("hot"), []byte("hot")})
+	assert.Equal(t, []byte("v2"), results[0].Value)
+	assert.Equal(t, []byte("v2"), results[1].Value)
+
+	assert.Nil(t, db.Delete([]byte("hot")))
+	_, err = db.Get([]byte("hot"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_ValueCacheFold(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.ValueCacheSize = 32 * 1024
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() { _ = db.Close() }()
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	hotKeys := [][]byte{utils.GetTestKey(1), utils.GetTestKey(2), utils.GetTestKey(3)}
+	for i := 0; i < 2; i++ {
+		for _, key := range hotKeys {
+			_, err := db.Get(key)
+			assert.Nil(t, err)
+		}
+	}
+
+	// 遍历所有的 key 之后，经常访问的 value 仍然在缓存中
+	assert.Nil(t, db.Fold(func(key []byte, value []byte) bool { return true }))
+	before, err := db.Stat()
+	assert.Nil(t, err)
+	for _, key := range hotKeys {
+		_, err := db.Get(key)
+		assert.Nil(t, err)
+	}
+	after, err := db.Stat()
+	assert.Nil(t, err)
+	assert.Equal(t, before.ValueCacheHits+uint64(len(hotKeys)), after.ValueCacheHits)
+	assert.Equal(t, before.ValueCacheMisses, after.ValueCacheMisses)
+}
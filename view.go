@@ -0,0 +1,115 @@
+package bitcask_kv_go
+
+import (
+	"bitcask-kv-go/data"
+	"sync"
+)
+
+// 超过该长度的缓冲区不放回缓冲池，避免个别较大的记录长期占用内存
+const maxPooledRecordBufSize = 1024 * 1024
+
+// 读取记录使用的缓冲池
+var recordBufPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, 4096)
+		return &buf
+	},
+}
+
+// GetInto 读取 key 对应的 value，value 写入到 buf 中并返回（复用 buf 的容量，容量不足时重新分配）
+// 适合在循环中复用同一个缓冲区读取数据，减少内存分配
+func (db *DB) GetInto(key, buf []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	scratch := recordBufPool.Get().(*[]byte)
+	defer putRecordBuf(scratch)
+
+	value, err := db.getValueWithBuf(key, scratch)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf[:0], value...), nil
+}
+
+// View 读取 key 对应的 value 并交给 fn 处理，不会拷贝 value
+// value 只在 fn 执行期间有效，fn 返回之后不能再访问，需要保留时请自行拷贝；fn 的返回值作为 View 的返回值
+// fn 执行时不持有数据库的锁，可以在 fn 中读写数据库
+func (db *DB) View(key []byte, fn func(value []byte) error) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	scratch := recordBufPool.Get().(*[]byte)
+	defer putRecordBuf(scratch)
+
+	value, err := db.getValueWithBuf(key, scratch)
+	if err != nil {
+		return err
+	}
+	return fn(value)
+}
+
+// 读取 key 对应的 value，记录读取到 scratch 中，返回的 value 可能引用 scratch 或者缓存的内存，不能修改
+// 调用方不能持有锁，读取文件时不持有锁
+func (db *DB) getValueWithBuf(key []byte, scratch *[]byte) ([]byte, error) {
+	db.mu.RLock()
+	pos, err := db.getIndexPos(key)
+	blobPins := db.pinBlobsLocked()
+	db.mu.RUnlock()
+	defer blobPins.Done()
+	if err != nil {
+		return nil, err
+	}
+	if pos == nil {
+		return nil, ErrKeyNotFound
+	}
+	// 缓存中的 value 不会被修改，可以直接返回
+	if value, ok := db.getCachedValue(pos); ok {
+		return value, nil
+	}
+	// 旧版本写入的位置信息没有记录长度，只能按照普通的方式读取
+	if pos.Size == 0 {
+		logRecord, err := db.getLogRecordByPositionUnlocked(pos)
+		if err != nil {
+			return nil, err
+		}
+		db.cacheValue(pos, logRecord.Value)
+		return logRecord.Value, nil
+	}
+
+	dataFile, err := db.pinDataFile(pos.Fid)
+	if err != nil {
+		return nil, err
+	}
+	if cap(*scratch) < int(pos.Size) {
+		*scratch = make([]byte, pos.Size)
+	}
+	buf := (*scratch)[:pos.Size]
+	err = dataFile.ReadAt(buf, pos.Offset)
+	dataFile.Unpin()
+	if err != nil {
+		return nil, err
+	}
+	logRecord, _, err := dataFile.DecodeLogRecord(buf)
+	if err != nil {
+		return nil, err
+	}
+	if logRecord.Type == data.LogRecordDeleted {
+		return nil, ErrKeyNotFound
+	}
+	if err := db.resolveValueUnlocked(logRecord); err != nil {
+		return nil, err
+	}
+	db.cacheValue(pos, logRecord.Value)
+	return logRecord.Value, nil
+}
+
+// 将缓冲区放回缓冲池
+func putRecordBuf(buf *[]byte) {
+	if cap(*buf) > maxPooledRecordBufSize {
+		return
+	}
+	recordBufPool.Put(buf)
+}
@@ -0,0 +1,145 @@
+package bitcask_kv_go
+
+import (
+	"bitcask-kv-go/utils"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_GetInto(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+
+	_, err := db.GetInto(nil, nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+	_, err = db.GetInto([]byte("unknown"), nil)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	assert.Nil(t, db.Put([]byte("k1"), []byte("value-1")))
+	assert.Nil(t, db.Put([]byte("k2"), []byte("v2")))
+
+	// 容量足够时复用 buf 的内存
+	buf := make([]byte, 0, 64)
+	val, err := db.GetInto([]byte("k1"), buf)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-1"), val)
+	assert.Equal(t, &buf[:1][0], &val[0])
+
+	val, err = db.GetInto([]byte("k2"), val)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+
+	// 容量不足时重新分配
+	val, err = db.GetInto([]byte("k1"), make([]byte, 0, 1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value-1"), val)
+
+	assert.Nil(t, db.Delete([]byte("k1")))
+	_, err = db.GetInto([]byte("k1"), buf)
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_View(t *testing.T) {
+	db := initDB(t)
+	defer db.Close()
+
+	err := db.View([]byte("unknown"), func(value []byte) error { return nil })
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	assert.Nil(t, db.Put([]byte("k"), []byte("v")))
+	var seen []byte
+	err = db.View([]byte("k"), func(value []byte) error {
+		seen = append(seen, value...)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), seen)
+
+	// fn 的错误原样返回
+	errStop := errors.New("stop")
+	err = db.View([]byte("k"), func(value []byte) error { return errStop })
+	assert.Equal(t, errStop, err)
+
+	// fn 中可以写入数据库
+	err = db.View([]byte("k"), func(value []byte) error {
+		return db.Put([]byte("copy"), value)
+	})
+	assert.Nil(t, err)
+	val, err := db.Get([]byte("copy"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v"), val)
+}
+
+func TestDB_ViewCompressedAndRestart(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = t.TempDir()
+	opts.Compression = FlateCompression
+	opts.CompressionThreshold = 1
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	value := []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	assert.Nil(t, db.Put([]byte("k"), value))
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer func() { _ = db.Close() }()
+
+	val, err := db.GetInto([]byte("k"), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+	assert.Nil(t, db.View([]byte("k"), func(v []byte) error {
+		assert.Equal(t, value, v)
+		return nil
+	}))
+}
+
+// 读取相同的 key 时 Get、GetInto 和 View 的内存分配对比
+func BenchmarkDB_ReadAPIs(b *testing.B) {
+	opts := DefaultOptions
+	opts.DirPath = b.TempDir()
+	db, err := Open(opts)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() { _ = db.Close() }()
+
+	const keyCount = 1000
+	for i := 0; i < keyCount; i++ {
+		if err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024)); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.Run("Get", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := db.Get(utils.GetTestKey(i % keyCount)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("GetInto", func(b *testing.B) {
+		b.ReportAllocs()
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			if buf, err = db.GetInto(utils.GetTestKey(i%keyCount), buf); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("View", func(b *testing.B) {
+		b.ReportAllocs()
+		var n int
+		for i := 0; i < b.N; i++ {
+			if err := db.View(utils.GetTestKey(i%keyCount), func(value []byte) error {
+				n += len(value)
+				return nil
+			}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}